@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/urfave/cli.v1"
+
+	"github.com/andrewyi/crawler/src/config"
+	"github.com/andrewyi/crawler/src/dbstorage"
+)
+
+// 除crawl之外的命令共用，打开数据库并补齐表结构
+func openStorage(c *cli.Context) (*config.Config, *dbstorage.SimpleDBStorage, error) {
+	cfg, err := config.Load(c.GlobalString("config"))
+	if err != nil {
+		return nil, nil, err
+	}
+	dbStorage, err := dbstorage.NewSimpleDBStorage(cfg.Database.URL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("fail to create dbstorage handler, err: %w", err)
+	}
+	if err = dbStorage.Sync(); err != nil {
+		dbStorage.Close()
+		return nil, nil, fmt.Errorf("fail to sync database schema, err: %w", err)
+	}
+	return cfg, dbStorage, nil
+}
+
+// 命令的输出写到stdout，日志写到stderr
+func newLogger() *log.Logger {
+	var logger = log.New()
+	logger.SetFormatter(&log.TextFormatter{
+		DisableColors: true,
+		FullTimestamp: true,
+	})
+	logger.SetOutput(os.Stderr)
+	return logger
+}
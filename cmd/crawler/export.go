@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/urfave/cli.v1"
+
+	"github.com/andrewyi/crawler/src/dbstorage"
+	"github.com/andrewyi/crawler/src/enum"
+	"github.com/andrewyi/crawler/src/export"
+	"github.com/andrewyi/crawler/src/util"
+)
+
+var exportCommand = cli.Command{
+	Name:  "export",
+	Usage: "导出页面及链接关系",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "format,f",
+			Usage: "导出格式：jsonl/csv/parquet",
+			Value: enum.ExportFormatJSONL,
+		},
+		cli.StringFlag{
+			Name:  "output,o",
+			Usage: "输出目录，生成 pages-{时间}.{格式} 及 edges-{时间}.{格式} 两个文件",
+			Value: ".",
+		},
+		cli.StringFlag{
+			Name:  "state",
+			Usage: "仅导出该状态的页面，例如pending/success/fail_transient，为空时不限制",
+		},
+		cli.StringFlag{
+			Name:  "domain",
+			Usage: "仅导出该域名下的页面，为空时不限制",
+		},
+		cli.StringFlag{
+			Name:  "job",
+			Usage: "仅导出该job的页面，为空时不限制",
+		},
+		cli.StringFlag{
+			Name:  "since",
+			Usage: "仅导出在此时间及之后抓取的页面，格式为2006-01-02或RFC3339",
+		},
+		cli.StringFlag{
+			Name:  "until",
+			Usage: "仅导出在此时间之前抓取的页面，格式同since",
+		},
+		cli.StringFlag{
+			Name:  "checkpoint",
+			Usage: "checkpoint文件，指定后从上一次导出的位置继续，并在导出成功后更新",
+		},
+	},
+	Action: exportPages,
+}
+
+func exportPages(c *cli.Context) error {
+	var filter = dbstorage.PageFilter{State: -1, Domain: c.String("domain"), JobID: c.String("job")}
+	if name := c.String("state"); name != "" {
+		state, ok := enum.ParsePageState(name)
+		if !ok {
+			return fmt.Errorf("invalid state: %s", name)
+		}
+		filter.State = state
+	}
+	var err error
+	if filter.FetchedAfter, err = util.ParseTime(c.String("since")); err != nil {
+		return err
+	}
+	if filter.FetchedBefore, err = util.ParseTime(c.String("until")); err != nil {
+		return err
+	}
+
+	var cp export.Checkpoint
+	cpPath := c.String("checkpoint")
+	if cpPath != "" {
+		if cp, err = export.LoadCheckpoint(cpPath); err != nil {
+			return fmt.Errorf("fail to load checkpoint, err: %w", err)
+		}
+	}
+
+	_, dbStorage, err := openStorage(c)
+	if err != nil {
+		return err
+	}
+	defer dbStorage.Close()
+
+	dir := c.String("output")
+	if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	w, err := export.NewWriter(c.String("format"), dir, time.Now().Format("20060102-150405"))
+	if err != nil {
+		return err
+	}
+
+	cp, stats, err := export.Export(dbStorage, w, filter, cp)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("fail to export pages, err: %w", err)
+	}
+
+	if cpPath != "" {
+		if err = export.SaveCheckpoint(cpPath, cp); err != nil {
+			return fmt.Errorf("fail to save checkpoint, err: %w", err)
+		}
+	}
+	fmt.Printf("%d pages, %d edges exported\n", stats.Pages, stats.Edges)
+	return nil
+}
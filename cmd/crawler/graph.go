@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/urfave/cli.v1"
+
+	"github.com/andrewyi/crawler/src/enum"
+	"github.com/andrewyi/crawler/src/graph"
+)
+
+var graphCommand = cli.Command{
+	Name:  "graph",
+	Usage: "计算链接图的出入度、PageRank及强连通分量，写回pages表并可导出",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "format,f",
+			Usage: "导出格式：graphml/gexf/dot",
+			Value: enum.GraphFormatGraphML,
+		},
+		cli.StringFlag{
+			Name:  "output,o",
+			Usage: "导出文件，为空时不导出",
+		},
+		cli.Float64Flag{
+			Name:  "damping",
+			Usage: "PageRank阻尼系数",
+			Value: 0.85,
+		},
+		cli.IntFlag{
+			Name:  "iterations",
+			Usage: "PageRank最大迭代次数",
+			Value: 100,
+		},
+		cli.Float64Flag{
+			Name:  "tolerance",
+			Usage: "相邻两次迭代结果的L1距离小于此值时停止",
+			Value: 1e-6,
+		},
+		cli.StringFlag{
+			Name:  "job",
+			Usage: "仅分析该job的页面，为空时包含所有job",
+		},
+		cli.BoolFlag{
+			Name:  "no-save",
+			Usage: "不将结果写回pages表",
+		},
+	},
+	Action: analyzeGraph,
+}
+
+func analyzeGraph(c *cli.Context) error {
+	_, dbStorage, err := openStorage(c)
+	if err != nil {
+		return err
+	}
+	defer dbStorage.Close()
+
+	logger := newLogger()
+
+	g, err := graph.Load(dbStorage, c.String("job"))
+	if err != nil {
+		return fmt.Errorf("fail to load link graph, err: %w", err)
+	}
+	logger.WithField("nodes", g.NumNodes()).WithField("edges", g.NumEdges()).Info("link graph loaded")
+
+	scores := graph.Analyze(g, c.Float64("damping"), c.Int("iterations"), c.Float64("tolerance"))
+	logger.WithField("components", scores.Components).Info("link graph analyzed")
+
+	if !c.Bool("no-save") {
+		if err = graph.Save(dbStorage, g, scores); err != nil {
+			return fmt.Errorf("fail to save scores, err: %w", err)
+		}
+	}
+
+	path := c.String("output")
+	if path == "" {
+		return nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w, err := graph.NewWriter(c.String("format"), f)
+	if err != nil {
+		return err
+	}
+	if err = graph.Write(dbStorage, g, scores, w); err != nil {
+		return fmt.Errorf("fail to write graph, err: %w", err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/urfave/cli.v1"
+
+	"github.com/andrewyi/crawler/src/dbstorage"
+	"github.com/andrewyi/crawler/src/history"
+	"github.com/andrewyi/crawler/src/util"
+)
+
+var historyCommand = cli.Command{
+	Name:  "history",
+	Usage: "查看页面内容的版本历史（storage.history.location），所有job共用",
+	Subcommands: []cli.Command{
+		{
+			Name:      "list",
+			Usage:     "列出url的所有版本及抓取时间",
+			ArgsUsage: "<url>",
+			Action:    historyList,
+		},
+		{
+			Name:      "diff",
+			Usage:     "比较url的两个版本，输出unified diff，有正文时比较正文，否则比较网页内容",
+			ArgsUsage: "<url>",
+			Flags: []cli.Flag{
+				cli.UintFlag{
+					Name:  "from",
+					Usage: "旧的版本，默认为--to的上一个版本",
+				},
+				cli.UintFlag{
+					Name:  "to",
+					Usage: "新的版本，默认为最新的版本",
+				},
+				cli.IntFlag{
+					Name:  "context",
+					Usage: "每处差异前后保留的行数",
+					Value: 3,
+				},
+			},
+			Action: historyDiff,
+		},
+	},
+}
+
+func historyList(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("url is required")
+	}
+	nURL, err := util.ShortifyURL(c.Args().First())
+	if err != nil {
+		return err
+	}
+	_, dbStorage, err := openStorage(c)
+	if err != nil {
+		return err
+	}
+	defer dbStorage.Close()
+
+	t, err := dbStorage.NewTransaction()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	versions, err := t.GetPageVersions(nURL)
+	if err != nil {
+		return fmt.Errorf("fail to get versions, err: %w", err)
+	}
+	if len(versions) == 0 {
+		return fmt.Errorf("no version of %s", nURL)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tJOB\tFETCHED_AT\tSEEN_AT\tCONTENT_HASH\tSTORAGE_PATH")
+	for _, v := range versions {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", v.Version, v.JobID,
+			v.FetchedAt.Format(time.RFC3339), v.SeenAt.Format(time.RFC3339), v.ContentHash, v.StoragePath)
+	}
+	return w.Flush()
+}
+
+func historyDiff(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("url is required")
+	}
+	nURL, err := util.ShortifyURL(c.Args().First())
+	if err != nil {
+		return err
+	}
+	_, dbStorage, err := openStorage(c)
+	if err != nil {
+		return err
+	}
+	defer dbStorage.Close()
+
+	t, err := dbStorage.NewTransaction()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	from, to, err := history.Versions(t, nURL, uint32(c.Uint("from")), uint32(c.Uint("to")))
+	if err == dbstorage.ErrDataNotExist {
+		return fmt.Errorf("no version of %s", nURL)
+	} else if err != nil {
+		return err
+	}
+	diff, err := history.Diff(from, to, c.Int("context"))
+	if err != nil {
+		return fmt.Errorf("fail to diff versions, err: %w", err)
+	}
+	if diff == "" {
+		fmt.Printf("version %d and %d of %s are identical\n", from.Version, to.Version, nURL)
+		return nil
+	}
+	fmt.Print(diff)
+	return nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/urfave/cli.v1"
+
+	"github.com/andrewyi/crawler/src/dbstorage"
+	"github.com/andrewyi/crawler/src/dbstorage/schema"
+	"github.com/andrewyi/crawler/src/enum"
+	"github.com/andrewyi/crawler/src/job"
+)
+
+var jobCommand = cli.Command{
+	Name:  "job",
+	Usage: "管理爬取任务（job），运行中的crawl在core.job_sync_period之内生效",
+	Subcommands: []cli.Command{
+		{
+			Name:      "create",
+			Usage:     "创建job并插入seed记录，由运行中的crawl（或者下次运行时）开始抓取",
+			ArgsUsage: "<id> <file|url...>",
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "depth",
+					Usage: "抓取深度，为负数时使用controller.depth",
+					Value: -1,
+				},
+				cli.StringSliceFlag{
+					Name:  "scope",
+					Usage: "允许抓取的域名（包含子域名），可以重复或者逗号分隔，为空时不限制",
+				},
+				cli.UintFlag{
+					Name:  "max-pages",
+					Usage: "抓取成功的页面数量达到后结束，0为不限制",
+				},
+				cli.UintFlag{
+					Name:  "max-duration",
+					Usage: "开始之后运行的最长时间，单位秒，0为不限制",
+				},
+				cli.StringFlag{
+					Name:  "storage",
+					Usage: "网页内容的存放目录，默认为storage.location/<id>",
+				},
+				cli.StringFlag{
+					Name:  "text-storage",
+					Usage: "正文的存放目录，默认为storage.text_location/<id>，storage.text_location为空时不存储",
+				},
+			},
+			Action: jobCreate,
+		},
+		{
+			Name:   "list",
+			Usage:  "列出所有job及其页面数量",
+			Action: jobList,
+		},
+		{
+			Name:      "pause",
+			Usage:     "暂停job，队列中的url保留，恢复后继续",
+			ArgsUsage: "<id>",
+			Action:    jobSetState(enum.JobStatePaused),
+		},
+		{
+			Name:      "resume",
+			Usage:     "恢复暂停的job",
+			ArgsUsage: "<id>",
+			Action:    jobSetState(enum.JobStateRunning),
+		},
+		{
+			Name:      "cancel",
+			Usage:     "取消job，队列中剩余的url被丢弃，不能恢复",
+			ArgsUsage: "<id>",
+			Action:    jobSetState(enum.JobStateCancelled),
+		},
+	},
+}
+
+func jobCreate(c *cli.Context) error {
+	if c.NArg() < 2 {
+		return fmt.Errorf("job id and seed file or url are required")
+	}
+	id := c.Args().First()
+	if err := job.ValidateID(id); err != nil {
+		return err
+	}
+	urls, err := readSeeds(c.Args().Tail())
+	if err != nil {
+		return err
+	}
+	var domains []string
+	for _, s := range c.StringSlice("scope") {
+		for _, d := range strings.Split(s, ",") {
+			if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+				domains = append(domains, d)
+			}
+		}
+	}
+	scope, err := job.EncodeScope(domains)
+	if err != nil {
+		return err
+	}
+
+	cfg, dbStorage, err := openStorage(c)
+	if err != nil {
+		return err
+	}
+	defer dbStorage.Close()
+
+	var j = &schema.Job{
+		ID:              id,
+		State:           enum.JobStateRunning,
+		Depth:           cfg.Controller.Depth,
+		Scope:           scope,
+		MaxPages:        uint32(c.Uint("max-pages")),
+		MaxDuration:     uint32(c.Uint("max-duration")),
+		StorageLocation: c.String("storage"),
+		TextLocation:    c.String("text-storage"),
+	}
+	if depth := c.Int("depth"); depth >= 0 {
+		j.Depth = uint8(depth)
+	}
+	if j.StorageLocation == "" {
+		j.StorageLocation = filepath.Join(cfg.Storage.Location, id)
+	}
+	if j.TextLocation == "" && cfg.Storage.TextLocation != "" {
+		j.TextLocation = filepath.Join(cfg.Storage.TextLocation, id)
+	}
+
+	t, err := dbStorage.NewTransaction()
+	if err != nil {
+		return err
+	}
+	_, err = t.GetJob(id)
+	t.Close()
+	if err == nil {
+		return fmt.Errorf("job %s already exists", id)
+	}
+	if err != dbstorage.ErrDataNotExist {
+		return err
+	}
+
+	// 先插入seed再创建job，job被运行中的crawl载入时seed已经存在
+	if err = addSeeds(dbStorage, id, urls); err != nil {
+		return err
+	}
+	if t, err = dbStorage.NewTransaction(); err != nil {
+		return err
+	}
+	defer t.Close()
+	if _, err = t.InsertJob(j); err != nil {
+		return fmt.Errorf("fail to create job, err: %w", err)
+	}
+	return t.Commit()
+}
+
+func jobList(c *cli.Context) error {
+	_, dbStorage, err := openStorage(c)
+	if err != nil {
+		return err
+	}
+	defer dbStorage.Close()
+
+	t, err := dbStorage.NewTransaction()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	jobs, err := t.GetJobs()
+	if err != nil {
+		return fmt.Errorf("fail to get jobs, err: %w", err)
+	}
+	counts, err := t.GetJobPageCountByState()
+	if err != nil {
+		return fmt.Errorf("fail to count pages by job, err: %w", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "JOB\tSTATE\tDEPTH\tPENDING\tSUCCESS\tFAILED\tMAX_PAGES\tMAX_DURATION\tSTARTED_AT\tREMARK")
+	for _, j := range jobs {
+		var failed int64
+		for state, n := range counts[j.ID] {
+			if enum.IsPageFailState(uint32(state)) {
+				failed += n
+			}
+		}
+		var startedAt = "-"
+		if !j.StartedAt.IsZero() {
+			startedAt = j.StartedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t%s\n",
+			j.ID, enum.JobStateName(int(j.State)), j.Depth,
+			counts[j.ID][enum.PageStatePending], counts[j.ID][enum.PageStateSuccess], failed,
+			j.MaxPages, j.MaxDuration, startedAt, j.Remark)
+	}
+	return w.Flush()
+}
+
+func jobSetState(state uint8) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return fmt.Errorf("job id is required")
+		}
+		_, dbStorage, err := openStorage(c)
+		if err != nil {
+			return err
+		}
+		defer dbStorage.Close()
+
+		id := c.Args().First()
+		if err = job.SetState(dbStorage, id, state); err == dbstorage.ErrDataNotExist {
+			return fmt.Errorf("job %s not found", id)
+		} else if err != nil {
+			return err
+		}
+		fmt.Printf("job %s is %s\n", id, enum.JobStateName(int(state)))
+		return nil
+	}
+}
+
+// 加入seed的job需要存在并且尚未结束
+func checkJobOpen(dbStorage *dbstorage.SimpleDBStorage, id string) error {
+	t, err := dbStorage.NewTransaction()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	j, err := t.GetJob(id)
+	if err == dbstorage.ErrDataNotExist {
+		return fmt.Errorf("job %s not found", id)
+	} else if err != nil {
+		return err
+	}
+	if j.State != enum.JobStateRunning && j.State != enum.JobStatePaused {
+		return fmt.Errorf("job %s is %s", id, enum.JobStateName(int(j.State)))
+	}
+	return nil
+}
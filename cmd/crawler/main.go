@@ -22,10 +22,48 @@ func main() {
 			Usage: "配置文件",
 			Value: "./config.yaml",
 		},
+		cli.StringFlag{
+			Name:  "role,r",
+			Usage: "不指定子命令时运行爬虫的角色，与crawl --role相同",
+		},
 	}
 
 	s := server.NewServer()
+	// 兼容拆分子命令之前的用法，不指定子命令时等同于crawl
 	app.Action = s.Start
+	app.Commands = []cli.Command{
+		{
+			Name:  "crawl",
+			Usage: "运行爬虫",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "role,r",
+					Usage: "运行的角色，逗号分隔：downloader,analyzer,controller,core，默认运行所有角色",
+				},
+			},
+			Action: s.Start,
+		},
+		{
+			Name:  "daemon",
+			Usage: "常驻运行爬虫，按照scheduler.schedules定时开始新的一代，任务完成后不退出",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "role,r",
+					Usage: "运行的角色，逗号分隔：downloader,analyzer,controller,core，默认运行所有角色",
+				},
+			},
+			Action: s.Daemon,
+		},
+		seedCommand,
+		jobCommand,
+		scheduleCommand,
+		statusCommand,
+		retryCommand,
+		exportCommand,
+		graphCommand,
+		searchCommand,
+		historyCommand,
+	}
 
 	err := app.Run(os.Args)
 	if err != nil {
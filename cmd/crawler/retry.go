@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+
+	"gopkg.in/urfave/cli.v1"
+
+	"github.com/andrewyi/crawler/src/enum"
+)
+
+var retryCommand = cli.Command{
+	Name:  "retry",
+	Usage: "将页面重置为pending，下次运行crawl时重新下载",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "state",
+			Usage: "需要重置的页面状态：fail_transient（或fail）/fail_permanent/blocked_by_robots/out_of_scope/too_large/success",
+			Value: "fail_transient",
+		},
+		cli.StringFlag{
+			Name:  "domain",
+			Usage: "仅重置该域名下的页面，为空时不限制；同时清除该域名的熔断状态",
+		},
+		cli.StringFlag{
+			Name:  "job",
+			Usage: "仅重置该job的页面，为空时不限制",
+		},
+	},
+	Action: retry,
+}
+
+func retry(c *cli.Context) error {
+	state, ok := enum.ParsePageState(c.String("state"))
+	if !ok || state == enum.PageStatePending {
+		return fmt.Errorf("invalid state: %s", c.String("state"))
+	}
+
+	_, dbStorage, err := openStorage(c)
+	if err != nil {
+		return err
+	}
+	defer dbStorage.Close()
+
+	t, err := dbStorage.NewTransaction()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	domain := c.String("domain")
+	n, err := t.ResetPages(uint8(state), domain, c.String("job"))
+	if err != nil {
+		return fmt.Errorf("fail to reset pages, err: %w", err)
+	}
+	if domain != "" {
+		if _, err = t.DeleteDomain(domain); err != nil {
+			return fmt.Errorf("fail to reset domain state, err: %w", err)
+		}
+	}
+	if err = t.Commit(); err != nil {
+		return err
+	}
+	fmt.Printf("%d pages reset to pending\n", n)
+	return nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/urfave/cli.v1"
+
+	"github.com/andrewyi/crawler/src/config"
+	"github.com/andrewyi/crawler/src/dbstorage"
+	"github.com/andrewyi/crawler/src/dbstorage/schema"
+	"github.com/andrewyi/crawler/src/enum"
+	"github.com/andrewyi/crawler/src/scheduler"
+)
+
+var scheduleCommand = cli.Command{
+	Name:  "schedule",
+	Usage: "查看定时爬取（scheduler.schedules）及每一代的结果，定时触发由daemon命令负责",
+	Subcommands: []cli.Command{
+		{
+			Name:   "list",
+			Usage:  "列出配置的schedule、下一次运行时间及最近一代",
+			Action: scheduleList,
+		},
+		{
+			Name:      "run",
+			Usage:     "立即开始新的一代，由运行中的daemon（或者下次运行时）开始抓取",
+			ArgsUsage: "<name>",
+			Action:    scheduleRun,
+		},
+		{
+			Name:      "report",
+			Usage:     "展示某一代的统计，以及与上一代相比新增、消失及内容变化的url",
+			ArgsUsage: "<name>",
+			Flags: []cli.Flag{
+				cli.UintFlag{
+					Name:  "generation,g",
+					Usage: "代数，默认为最近一代",
+				},
+				cli.IntFlag{
+					Name:  "limit",
+					Usage: "每种变化最多列出的url数量，0为只展示统计",
+					Value: 100,
+				},
+			},
+			Action: scheduleReport,
+		},
+	},
+}
+
+func newScheduler(cfg *config.Config, dbStorage *dbstorage.SimpleDBStorage) (*scheduler.Scheduler, error) {
+	var schedules = make([]scheduler.Schedule, 0, len(cfg.Scheduler.Schedules))
+	for _, c := range cfg.Scheduler.Schedules {
+		schedules = append(schedules, scheduler.Schedule(c))
+	}
+	defaults := scheduler.Defaults{
+		Depth:        cfg.Controller.Depth,
+		Location:     cfg.Storage.Location,
+		TextLocation: cfg.Storage.TextLocation,
+	}
+	return scheduler.NewScheduler(schedules, defaults, dbStorage, newLogger())
+}
+
+func scheduleList(c *cli.Context) error {
+	cfg, dbStorage, err := openStorage(c)
+	if err != nil {
+		return err
+	}
+	defer dbStorage.Close()
+	sch, err := newScheduler(cfg, dbStorage)
+	if err != nil {
+		return err
+	}
+
+	t, err := dbStorage.NewTransaction()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SCHEDULE\tCRON\tNEXT\tGENERATION\tJOB\tSTATE\tCREATED_AT")
+	for _, name := range sch.Names() {
+		s, _ := sch.Get(name)
+		next := sch.Next(name, now).Format(time.RFC3339)
+		g, err := t.GetLatestGeneration(name)
+		if err == dbstorage.ErrDataNotExist {
+			fmt.Fprintf(w, "%s\t%s\t%s\t-\t-\t-\t-\n", name, s.Cron, next)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("fail to get latest generation, err: %w", err)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n", name, s.Cron, next,
+			g.Generation, g.JobID, enum.GenerationStateName(int(g.State)), g.CreatedAt.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+func scheduleRun(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("schedule name is required")
+	}
+	cfg, dbStorage, err := openStorage(c)
+	if err != nil {
+		return err
+	}
+	defer dbStorage.Close()
+	sch, err := newScheduler(cfg, dbStorage)
+	if err != nil {
+		return err
+	}
+
+	g, err := sch.Trigger(c.Args().First())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("generation %d of %s started as job %s\n", g.Generation, g.Schedule, g.JobID)
+	return nil
+}
+
+func scheduleReport(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("schedule name is required")
+	}
+	name := c.Args().First()
+	_, dbStorage, err := openStorage(c)
+	if err != nil {
+		return err
+	}
+	defer dbStorage.Close()
+
+	t, err := dbStorage.NewTransaction()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	var g *schema.Generation
+	if n := c.Uint("generation"); n > 0 {
+		g, err = t.GetGeneration(name, uint32(n))
+	} else {
+		g, err = t.GetLatestGeneration(name)
+	}
+	if err == dbstorage.ErrDataNotExist {
+		return fmt.Errorf("generation of %s not found", name)
+	} else if err != nil {
+		return err
+	}
+	// 运行中的一代尚未写入统计，按照当前的进度计算
+	if g.State == enum.GenerationStateRunning {
+		if err = scheduler.Summarize(t, g); err != nil {
+			return fmt.Errorf("fail to summarize generation, err: %w", err)
+		}
+	}
+
+	fmt.Printf("schedule:   %s\n", g.Schedule)
+	fmt.Printf("generation: %d\n", g.Generation)
+	fmt.Printf("job:        %s\n", g.JobID)
+	fmt.Printf("state:      %s\n", enum.GenerationStateName(int(g.State)))
+	fmt.Printf("created_at: %s\n", g.CreatedAt.Format(time.RFC3339))
+	if !g.FinishedAt.IsZero() {
+		fmt.Printf("finished_at: %s\n", g.FinishedAt.Format(time.RFC3339))
+	}
+	fmt.Printf("success:    %d\n", g.Success)
+	fmt.Printf("failed:     %d\n", g.Failed)
+	if g.Previous == "" {
+		fmt.Println("previous:   -")
+		return nil
+	}
+	fmt.Printf("previous:   %s\n", g.Previous)
+	fmt.Printf("added:      %d\n", g.Added)
+	fmt.Printf("removed:    %d\n", g.Removed)
+	fmt.Printf("changed:    %d\n", g.Changed)
+
+	limit := c.Int("limit")
+	if limit <= 0 {
+		return nil
+	}
+	for _, kind := range enum.DiffKinds {
+		urls, err := t.GetJobDiff(g.Previous, g.JobID, kind, limit)
+		if err != nil {
+			return fmt.Errorf("fail to get %s pages, err: %w", kind, err)
+		}
+		if len(urls) == 0 {
+			continue
+		}
+		fmt.Printf("\n%s:\n", kind)
+		for _, u := range urls {
+			fmt.Println(u)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/urfave/cli.v1"
+
+	"github.com/andrewyi/crawler/src/config"
+	"github.com/andrewyi/crawler/src/index"
+	"github.com/andrewyi/crawler/src/util"
+)
+
+// 索引被爬虫进程打开时无法获取文件锁，超过此时间后放弃
+const indexOpenTimeout = 3 * time.Second
+
+var searchCommand = cli.Command{
+	Name:      "search",
+	Usage:     "检索全文索引，爬虫运行期间请使用/api/search接口",
+	ArgsUsage: "<query>",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "job",
+			Usage: "仅检索该job的页面，为空时不限制",
+		},
+		cli.StringFlag{
+			Name:  "domain",
+			Usage: "仅检索该域名下的页面，为空时不限制",
+		},
+		cli.StringFlag{
+			Name:  "since",
+			Usage: "仅检索在此时间及之后抓取的页面，格式为2006-01-02或RFC3339",
+		},
+		cli.StringFlag{
+			Name:  "until",
+			Usage: "仅检索在此时间之前抓取的页面，格式同since",
+		},
+		cli.IntFlag{
+			Name:  "limit,n",
+			Usage: "返回的结果数量",
+			Value: 20,
+		},
+	},
+	Action: search,
+}
+
+func search(c *cli.Context) error {
+	var q = index.Query{
+		Text:   strings.Join(c.Args(), " "),
+		Job:    c.String("job"),
+		Domain: c.String("domain"),
+		Limit:  c.Int("limit"),
+	}
+	if q.Text == "" {
+		return errors.New("missing query")
+	}
+	var err error
+	if q.Since, err = util.ParseTime(c.String("since")); err != nil {
+		return err
+	}
+	if q.Until, err = util.ParseTime(c.String("until")); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(c.GlobalString("config"))
+	if err != nil {
+		return err
+	}
+	idx, err := openIndex(cfg.Index.Path)
+	if err != nil {
+		return err
+	}
+	defer idx.Close()
+
+	result, err := idx.Search(q)
+	if err != nil {
+		return fmt.Errorf("fail to search index, err: %w", err)
+	}
+	fmt.Printf("%d results\n", result.Total)
+	for _, h := range result.Hits {
+		fmt.Printf("\n%s  %s  %s  %.3f\n", h.FetchedAt.Format("2006-01-02 15:04"), h.Job, h.URL, h.Score)
+		if h.Title != "" {
+			fmt.Println(h.Title)
+		}
+		for _, f := range h.Fragments {
+			fmt.Println("  " + strings.Join(strings.Fields(f), " "))
+		}
+	}
+	return nil
+}
+
+// 以只读方式打开已存在的索引
+func openIndex(path string) (index.Index, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("fail to open index, err: %w", err)
+	}
+	type opened struct {
+		idx index.Index
+		err error
+	}
+	ch := make(chan opened, 1)
+	go func() {
+		idx, err := index.NewBleveIndex(path, true)
+		ch <- opened{idx, err}
+	}()
+	select {
+	case o := <-ch:
+		if o.err != nil {
+			return nil, fmt.Errorf("fail to open index, err: %w", o.err)
+		}
+		return o.idx, nil
+	case <-time.After(indexOpenTimeout):
+		return nil, errors.New("index is locked by a running crawler, use /api/search instead")
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/urfave/cli.v1"
+
+	"github.com/andrewyi/crawler/src/core"
+	"github.com/andrewyi/crawler/src/dbstorage"
+	"github.com/andrewyi/crawler/src/enum"
+)
+
+var seedCommand = cli.Command{
+	Name:  "seed",
+	Usage: "管理seed url",
+	Subcommands: []cli.Command{
+		{
+			Name:      "add",
+			Usage:     "插入seed记录但不进行抓取，下次运行crawl时提交下载",
+			ArgsUsage: "<file|url...>",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "job",
+					Usage: "加入的job，需要已经通过job create创建",
+					Value: enum.DefaultJob,
+				},
+			},
+			Action: seedAdd,
+		},
+	},
+}
+
+func seedAdd(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return fmt.Errorf("no seed file or url given")
+	}
+	urls, err := readSeeds(c.Args())
+	if err != nil {
+		return err
+	}
+
+	_, dbStorage, err := openStorage(c)
+	if err != nil {
+		return err
+	}
+	defer dbStorage.Close()
+
+	// default job在运行crawl时创建，其他job需要已经存在并且尚未结束
+	jobID := c.String("job")
+	if jobID != enum.DefaultJob {
+		if err = checkJobOpen(dbStorage, jobID); err != nil {
+			return err
+		}
+	}
+	return addSeeds(dbStorage, jobID, urls)
+}
+
+// 包含协议的参数作为url处理，否则作为seed文件逐行读取
+func readSeeds(args []string) ([]string, error) {
+	var urls []string
+	for _, arg := range args {
+		if strings.Contains(arg, "://") {
+			urls = append(urls, arg)
+			continue
+		}
+		lines, err := readLines(arg)
+		if err != nil {
+			return nil, fmt.Errorf("fail to read seed file %s, err: %w", arg, err)
+		}
+		urls = append(urls, lines...)
+	}
+	return urls, nil
+}
+
+// 仅插入记录，由运行中的crawl（或者下次运行时）提交
+func addSeeds(dbStorage *dbstorage.SimpleDBStorage, jobID string, urls []string) error {
+	added, err := core.AddSeeds(context.Background(), newLogger(), nil, dbStorage, jobID, urls, nil)
+	if err != nil {
+		return fmt.Errorf("fail to add seeds, err: %w", err)
+	}
+	for _, u := range added {
+		fmt.Println(u)
+	}
+	fmt.Fprintf(os.Stderr, "%d seeds added, %d skipped\n", len(added), len(urls)-len(added))
+	return nil
+}
+
+func readLines(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"gopkg.in/urfave/cli.v1"
+
+	"github.com/andrewyi/crawler/src/enum"
+)
+
+var statusCommand = cli.Command{
+	Name:  "status",
+	Usage: "按状态、域名、深度统计页面数量",
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "domains",
+			Usage: "展示页面数量最多的前几个域名",
+			Value: 10,
+		},
+	},
+	Action: status,
+}
+
+func status(c *cli.Context) error {
+	_, dbStorage, err := openStorage(c)
+	if err != nil {
+		return err
+	}
+	defer dbStorage.Close()
+
+	t, err := dbStorage.NewTransaction()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	states, err := t.GetPageCountByState()
+	if err != nil {
+		return fmt.Errorf("fail to count pages by state, err: %w", err)
+	}
+	domains, err := t.GetTopDomains(c.Int("domains"))
+	if err != nil {
+		return fmt.Errorf("fail to count pages by domain, err: %w", err)
+	}
+	depths, err := t.GetPageCountByDepth()
+	if err != nil {
+		return fmt.Errorf("fail to count pages by depth, err: %w", err)
+	}
+	circuits, err := t.GetDomains()
+	if err != nil {
+		return fmt.Errorf("fail to get domain states, err: %w", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(w, "STATE\tPAGES")
+	var stateKeys []int
+	for s := range states {
+		stateKeys = append(stateKeys, int(s))
+	}
+	sort.Ints(stateKeys)
+	for _, s := range stateKeys {
+		fmt.Fprintf(w, "%s\t%d\n", enum.PageStateName(s), states[uint8(s)])
+	}
+
+	fmt.Fprintln(w, "\nDOMAIN\tPAGES")
+	for _, d := range domains {
+		fmt.Fprintf(w, "%s\t%d\n", d.Domain, d.Count)
+	}
+
+	fmt.Fprintln(w, "\nDEPTH\tPAGES")
+	var depthKeys []int
+	for d := range depths {
+		depthKeys = append(depthKeys, int(d))
+	}
+	sort.Ints(depthKeys)
+	for _, d := range depthKeys {
+		fmt.Fprintf(w, "%d\t%d\n", d, depths[uint8(d)])
+	}
+
+	if len(circuits) > 0 {
+		fmt.Fprintln(w, "\nCIRCUIT\tSTATE\tPROBES\tOPEN UNTIL\tLAST ERROR")
+		for _, d := range circuits {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", d.Domain, enum.DomainStateName(int(d.State)),
+				d.Probes, d.OpenUntil.Format("2006-01-02 15:04:05"), d.LastError)
+		}
+	}
+	return w.Flush()
+}
@@ -4,9 +4,17 @@ go 1.13
 
 require (
 	github.com/PuerkitoBio/goquery v1.6.0
+	github.com/blevesearch/bleve v1.0.14
+	github.com/chromedp/cdproto v0.0.0-20200116234248-4da64dd111ac
+	github.com/chromedp/chromedp v0.5.3
 	github.com/go-xorm/xorm v0.7.9
 	github.com/lib/pq v1.9.0
+	github.com/pmezard/go-difflib v1.0.0
+	github.com/prometheus/client_golang v1.7.1
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/sirupsen/logrus v1.7.0
 	github.com/spf13/viper v1.7.1
+	github.com/xitongsys/parquet-go v1.5.4
+	golang.org/x/net v0.0.0-20200222125558-5a598a2470a0
 	gopkg.in/urfave/cli.v1 v1.20.0
 )
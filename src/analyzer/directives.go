@@ -0,0 +1,84 @@
+package analyzer
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"github.com/andrewyi/crawler/src/entity"
+)
+
+// 解析页面级别的指令：canonical、hreflang以及robots（meta标签与X-Robots-Tag响应头）
+func parseDirectives(doc *goquery.Document, page entity.PageInfo, parsed *entity.ParsedPageInfo) {
+	base, _ := url.Parse(page.URL)
+
+	if href, ok := doc.Find(`link[rel="canonical"]`).First().Attr("href"); ok {
+		parsed.Canonical = resolve(base, href)
+	}
+
+	doc.Find(`link[rel="alternate"][hreflang]`).Each(func(index int, element *goquery.Selection) {
+		href, ok := element.Attr("href")
+		if !ok {
+			return
+		}
+		lang, _ := element.Attr("hreflang")
+		if u := resolve(base, href); u != "" {
+			parsed.Alternates = append(parsed.Alternates, entity.Alternate{URL: u, Lang: lang})
+		}
+	})
+
+	doc.Find(`meta[name]`).Each(func(index int, element *goquery.Selection) {
+		name, _ := element.Attr("name")
+		if !strings.EqualFold(name, "robots") {
+			return
+		}
+		content, _ := element.Attr("content")
+		applyRobots(&parsed.Robots, content)
+	})
+	applyRobots(&parsed.Robots, page.RobotsTag)
+}
+
+// directives为逗号分隔的指令列表，带有"user-agent:"前缀的指令只针对特定爬虫，忽略
+func applyRobots(r *entity.Robots, directives string) {
+	for _, d := range strings.Split(directives, ",") {
+		d = strings.ToLower(strings.TrimSpace(d))
+		switch d {
+		case "noindex":
+			r.NoIndex = true
+		case "nofollow":
+			r.NoFollow = true
+		case "noarchive":
+			r.NoArchive = true
+		case "none":
+			r.NoIndex = true
+			r.NoFollow = true
+		}
+	}
+}
+
+// 转换为绝对url，无法解析时返回空
+func resolve(base *url.URL, href string) string {
+	href = strings.TrimSpace(href)
+	if href == "" {
+		return ""
+	}
+	u, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+	if base == nil {
+		return u.String()
+	}
+	return base.ResolveReference(u).String()
+}
+
+func hasRel(element *goquery.Selection, rel string) bool {
+	v, _ := element.Attr("rel")
+	for _, r := range strings.Fields(v) {
+		if strings.EqualFold(r, rel) {
+			return true
+		}
+	}
+	return false
+}
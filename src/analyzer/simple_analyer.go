@@ -8,23 +8,28 @@ import (
 
 	"github.com/andrewyi/crawler/src/entity"
 	"github.com/andrewyi/crawler/src/enum"
+	"github.com/andrewyi/crawler/src/extractor"
+	"github.com/andrewyi/crawler/src/fingerprint"
 
 	"github.com/PuerkitoBio/goquery"
 )
 
 type SimpleAnalyzer struct {
-	ctx context.Context
+	ctx       context.Context
+	extractor extractor.Extractor // 为nil时不提取正文
 }
 
-func NewSimpleAnalyzer(ctx context.Context) Analyzer {
+func NewSimpleAnalyzer(ctx context.Context, ext extractor.Extractor) Analyzer {
 
 	return &SimpleAnalyzer{
-		ctx: ctx,
+		ctx:       ctx,
+		extractor: ext,
 	}
 }
 
 func (a *SimpleAnalyzer) Analyze(page entity.PageInfo) entity.ParsedPageInfo {
 	var parsedPageInfo = entity.ParsedPageInfo{
+		JobID:   page.JobID,
 		URL:     page.URL,
 		State:   page.State,
 		Remark:  page.Remark,
@@ -37,23 +42,49 @@ func (a *SimpleAnalyzer) Analyze(page entity.PageInfo) entity.ParsedPageInfo {
 
 	doc, err := goquery.NewDocumentFromReader(strings.NewReader(page.Content))
 	if err != nil {
-		parsedPageInfo.State = enum.PageStateFail
+		parsedPageInfo.State = enum.PageStateFailPermanent
 		parsedPageInfo.Remark = err.Error()
 		return parsedPageInfo
 	}
 
-	var urls map[string]struct{}
+	parsedPageInfo.Title = strings.TrimSpace(doc.Find("title").First().Text())
+
+	parseDirectives(doc, page, &parsedPageInfo)
+
+	// 同一url出现多次时仅保留第一次出现的链接
+	var urls = make(map[string]entity.Link)
+	var subURLs []string
 
 	doc.Find("a").Each(func(index int, element *goquery.Selection) {
 		href, exists := element.Attr("href")
-		if exists {
-			urls[href] = struct{}{}
+		if !exists {
+			return
+		}
+		if _, ok := urls[href]; ok {
+			return
+		}
+		urls[href] = entity.Link{
+			URL:      href,
+			Text:     strings.TrimSpace(element.Text()),
+			NoFollow: hasRel(element, "nofollow"),
 		}
+		subURLs = append(subURLs, href)
 	})
-	var subURLs []string
-	for u := range urls {
-		subURLs = append(subURLs, u)
+	for _, u := range subURLs {
+		parsedPageInfo.Links = append(parsedPageInfo.Links, urls[u])
 	}
 	parsedPageInfo.SubURLs = subURLs
+
+	// 移除脚本、样式后的文本作为页面的可见文本，用于检测近似重复
+	doc.Find("script, style, noscript, template").Remove()
+	text := doc.Find("body").Text()
+	if a.extractor != nil {
+		// 提取过程会修改doc，必须放在最后
+		parsedPageInfo.Extracted = a.extractor.Extract(doc)
+		if parsedPageInfo.Extracted.Text != "" {
+			text = parsedPageInfo.Extracted.Text // 正文不含页面模板部分，更适合检测重复
+		}
+	}
+	parsedPageInfo.SimHash = fingerprint.SimHash(text)
 	return parsedPageInfo
 }
@@ -0,0 +1,322 @@
+// 按域名统计下载失败，连续失败达到阈值时熔断该域名，熔断期间该域名的url不再下载并保持pending
+// 冷却结束后放行一次探测，探测成功则恢复，并重新提交该域名所有pending的url
+// 探测失败时冷却时间翻倍，探测失败次数过多时标记为dead，之后仅按照最大冷却时间探测，新的job开始时重置
+// 非closed状态写入domains表，重启后恢复
+package breaker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/andrewyi/crawler/src/dbstorage"
+	"github.com/andrewyi/crawler/src/dbstorage/schema"
+	"github.com/andrewyi/crawler/src/entity"
+	"github.com/andrewyi/crawler/src/enum"
+	"github.com/andrewyi/crawler/src/event"
+	"github.com/andrewyi/crawler/src/metrics"
+)
+
+// 检查冷却是否结束的间隔
+const probeCheckPeriod = time.Second
+
+type Decision int
+
+const (
+	Allow Decision = iota
+	Park           // 不下载，url保持pending，恢复后重新提交
+)
+
+type Config struct {
+	Threshold   uint32 // 连续失败多少次后熔断
+	Cooldown    time.Duration
+	MaxCooldown time.Duration
+	MaxProbes   uint32 // 连续探测失败多少次后标记为dead，之后按MaxCooldown探测，0为不标记
+}
+
+// Resubmit 重新提交域名下pending的url，limit为0时提交全部
+type Resubmit func(domain string, limit int)
+
+type DomainBreaker struct {
+	mu  sync.Mutex
+	cfg Config
+	// 有失败记录或者处于熔断中的域名，恢复正常后移除
+	domains map[string]*schema.Domain
+	// 冷却结束后已经提交了探测url的时间，避免重复提交
+	probeSubmitted map[string]time.Time
+
+	db       *dbstorage.SimpleDBStorage // 为nil时只在内存中维护状态
+	logger   *log.Logger
+	resubmit Resubmit
+	events   *event.Bus
+}
+
+func NewDomainBreaker(cfg Config, db *dbstorage.SimpleDBStorage, logger *log.Logger, resubmit Resubmit, events *event.Bus) *DomainBreaker {
+	if cfg.MaxCooldown < cfg.Cooldown {
+		cfg.MaxCooldown = cfg.Cooldown
+	}
+	return &DomainBreaker{
+		cfg:            cfg,
+		domains:        make(map[string]*schema.Domain),
+		probeSubmitted: make(map[string]time.Time),
+		db:             db,
+		logger:         logger,
+		resubmit:       resubmit,
+		events:         events,
+	}
+}
+
+// 从数据库恢复上一次运行时的熔断状态
+func (b *DomainBreaker) Load() error {
+	t, err := b.db.NewTransaction()
+	if err != nil {
+		return err
+	}
+	defer t.Rollback()
+	domains, err := t.GetDomains()
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, d := range domains {
+		b.domains[d.Domain] = d
+	}
+	return nil
+}
+
+// 暂时性失败（网络错误、超时、5xx、429）之外的结果都说明域名可用
+func Healthy(page entity.PageInfo) bool {
+	return page.State != enum.PageStateFailTransient
+}
+
+// 认证失败（登录失败、session失效、不支持的认证方式）既不说明域名可用也不说明不可用，不报告给熔断
+// 登录失败时按照登录的重试间隔重新登录，session失效时下一次请求之前重新登录
+func Reportable(page entity.PageInfo) bool {
+	return !page.AuthFailed
+}
+
+func (b *DomainBreaker) Allow(domain string) Decision {
+	b.mu.Lock()
+	d, ok := b.domains[domain]
+	if !ok {
+		b.mu.Unlock()
+		return Allow
+	}
+	switch d.State {
+	case enum.DomainStateOpen, enum.DomainStateHalfOpen, enum.DomainStateDead:
+		// 半开状态下探测超时未返回结果（例如任务丢失）时，允许再次探测
+		now := time.Now()
+		if now.Before(d.OpenUntil) {
+			b.mu.Unlock()
+			return Park
+		}
+		d.State = enum.DomainStateHalfOpen
+		d.OpenUntil = now.Add(b.wait(d.Probes))
+		delete(b.probeSubmitted, domain)
+		saved := *d
+		b.mu.Unlock()
+		b.save(saved)
+		return Allow
+	default:
+		b.mu.Unlock()
+		return Allow
+	}
+}
+
+// 记录一次下载结果，返回true时表示域名处于熔断中，下载结果应当丢弃，url保持pending
+func (b *DomainBreaker) Report(domain string, healthy bool, remark string) bool {
+	b.mu.Lock()
+	d, ok := b.domains[domain]
+	if healthy {
+		if !ok {
+			b.mu.Unlock()
+			return false
+		}
+		delete(b.domains, domain)
+		delete(b.probeSubmitted, domain)
+		b.mu.Unlock()
+		if d.State != enum.DomainStateClosed {
+			b.recover(domain)
+		}
+		return false
+	}
+
+	if !ok {
+		d = &schema.Domain{Domain: domain, State: enum.DomainStateClosed}
+		b.domains[domain] = d
+	}
+	d.LastError = remark
+	now := time.Now()
+	switch d.State {
+	case enum.DomainStateClosed:
+		d.Failures++
+		if d.Failures < b.cfg.Threshold {
+			b.mu.Unlock()
+			return false
+		}
+		d.State = enum.DomainStateOpen
+		d.OpenUntil = now.Add(b.cooldown(0))
+	case enum.DomainStateHalfOpen:
+		// 熔断之前已经开始的下载也可能在此时失败，同样视为探测失败
+		d.Probes++
+		d.OpenUntil = now.Add(b.wait(d.Probes))
+		if b.dead(d.Probes) {
+			d.State = enum.DomainStateDead
+			saved := *d
+			b.mu.Unlock()
+			b.save(saved)
+			b.logger.WithFields(log.Fields{
+				"domain":     domain,
+				"probes":     saved.Probes,
+				"open_until": saved.OpenUntil,
+			}).Warn("domain is dead, probing at max cooldown")
+			return true
+		}
+		d.State = enum.DomainStateOpen
+	case enum.DomainStateOpen, enum.DomainStateDead:
+		// 熔断之前已经开始的下载，不改变状态
+		b.mu.Unlock()
+		return true
+	default:
+		b.mu.Unlock()
+		return false
+	}
+	saved := *d
+	b.mu.Unlock()
+
+	b.save(saved)
+	metrics.CircuitOpened.Inc()
+	b.logger.WithFields(log.Fields{
+		"domain":     domain,
+		"failures":   saved.Failures,
+		"probes":     saved.Probes,
+		"open_until": saved.OpenUntil,
+	}).Warn("domain circuit opened")
+	b.events.Emit(event.Event{
+		Type:   enum.EventCircuitOpened,
+		Domain: domain,
+		Data: map[string]interface{}{
+			"failures":   saved.Failures,
+			"probes":     saved.Probes,
+			"open_until": saved.OpenUntil,
+			"last_error": saved.LastError,
+		},
+	})
+	return true
+}
+
+// 冷却结束后，域名的url可能已经全部被搁置，因此主动提交一个url作为探测，直到ctx结束
+func (b *DomainBreaker) Run(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(probeCheckPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				for _, domain := range b.dueProbes(now) {
+					b.resubmit(domain, 1)
+				}
+			}
+		}
+	}()
+}
+
+func (b *DomainBreaker) dueProbes(now time.Time) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var due []string
+	for domain, d := range b.domains {
+		if (d.State != enum.DomainStateOpen && d.State != enum.DomainStateDead) || now.Before(d.OpenUntil) {
+			continue
+		}
+		// 提交的url可能排在队列很靠后的位置，一个冷却周期内只提交一次
+		if at, ok := b.probeSubmitted[domain]; ok && now.Sub(at) < b.wait(d.Probes) {
+			continue
+		}
+		b.probeSubmitted[domain] = now
+		due = append(due, domain)
+	}
+	return due
+}
+
+// 第n次探测失败后的冷却时间为cooldown*2^n，不超过max_cooldown
+func (b *DomainBreaker) cooldown(probes uint32) time.Duration {
+	d := b.cfg.Cooldown
+	for i := uint32(0); i < probes && d < b.cfg.MaxCooldown; i++ {
+		d *= 2
+	}
+	if d > b.cfg.MaxCooldown {
+		d = b.cfg.MaxCooldown
+	}
+	return d
+}
+
+func (b *DomainBreaker) dead(probes uint32) bool {
+	return b.cfg.MaxProbes > 0 && probes >= b.cfg.MaxProbes
+}
+
+// 第n次探测失败后距离下一次探测的时间，dead之后始终为max_cooldown
+func (b *DomainBreaker) wait(probes uint32) time.Duration {
+	if b.dead(probes) {
+		return b.cfg.MaxCooldown
+	}
+	return b.cooldown(probes)
+}
+
+// 新的job（包括定时爬取的新一代）开始时调用，dead的域名恢复为正常状态并重新提交其搁置的url
+// 域名是否可用可能已经变化，新的job不应当继承之前放弃的结论
+func (b *DomainBreaker) ResetDead() {
+	b.mu.Lock()
+	var dead []string
+	for domain, d := range b.domains {
+		if d.State == enum.DomainStateDead {
+			dead = append(dead, domain)
+			delete(b.domains, domain)
+			delete(b.probeSubmitted, domain)
+		}
+	}
+	b.mu.Unlock()
+	for _, domain := range dead {
+		b.logger.WithField("domain", domain).Info("new job started, resetting dead domain")
+		b.recover(domain)
+	}
+}
+
+func (b *DomainBreaker) recover(domain string) {
+	if b.db != nil {
+		t, err := b.db.NewTransaction()
+		if err == nil {
+			defer t.Rollback()
+			if _, err = t.DeleteDomain(domain); err == nil {
+				err = t.Commit()
+			}
+		}
+		if err != nil {
+			b.logger.WithError(err).WithField("domain", domain).Error("fail to delete domain state")
+		}
+	}
+	b.logger.WithField("domain", domain).Info("domain recovered, resubmitting pending urls")
+	go b.resubmit(domain, 0)
+}
+
+// 写入失败时仅记录日志，内存中的状态仍然生效
+func (b *DomainBreaker) save(d schema.Domain) {
+	if b.db == nil {
+		return
+	}
+	t, err := b.db.NewTransaction()
+	if err == nil {
+		defer t.Rollback()
+		if err = t.SaveDomain(&d); err == nil {
+			err = t.Commit()
+		}
+	}
+	if err != nil {
+		b.logger.WithError(err).WithField("domain", d.Domain).Error("fail to save domain state")
+	}
+}
@@ -0,0 +1,305 @@
+package breaker
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/andrewyi/crawler/src/entity"
+	"github.com/andrewyi/crawler/src/enum"
+)
+
+const testDomain = "example.com"
+
+type resubmitCall struct {
+	domain string
+	limit  int
+}
+
+func newTestBreaker(cfg Config) (*DomainBreaker, chan resubmitCall) {
+	logger := log.New()
+	logger.Out = ioutil.Discard
+	calls := make(chan resubmitCall, 16)
+	resubmit := func(domain string, limit int) {
+		calls <- resubmitCall{domain: domain, limit: limit}
+	}
+	return NewDomainBreaker(cfg, nil, logger, resubmit, nil), calls
+}
+
+// 跳过冷却时间
+func expire(b *DomainBreaker, domain string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.domains[domain].OpenUntil = time.Now().Add(-time.Millisecond)
+}
+
+func state(b *DomainBreaker, domain string) uint8 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d, ok := b.domains[domain]
+	if !ok {
+		return enum.DomainStateClosed
+	}
+	return d.State
+}
+
+func expectResubmit(t *testing.T, calls chan resubmitCall, limit int) {
+	t.Helper()
+	select {
+	case c := <-calls:
+		if c.domain != testDomain || c.limit != limit {
+			t.Fatalf("resubmit(%s, %d), want (%s, %d)", c.domain, c.limit, testDomain, limit)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("resubmit not called")
+	}
+}
+
+func TestOpenAfterThreshold(t *testing.T) {
+	b, _ := newTestBreaker(Config{Threshold: 3, Cooldown: time.Minute})
+
+	for i := 0; i < 2; i++ {
+		if b.Report(testDomain, false, "timeout") {
+			t.Fatalf("failure %d opened the circuit", i+1)
+		}
+	}
+	// 成功之后重新计数
+	b.Report(testDomain, true, "")
+	for i := 0; i < 2; i++ {
+		b.Report(testDomain, false, "timeout")
+	}
+	if got := b.Allow(testDomain); got != Allow {
+		t.Fatalf("Allow before threshold = %v", got)
+	}
+	if !b.Report(testDomain, false, "timeout") {
+		t.Fatal("circuit not opened at threshold")
+	}
+	if got := state(b, testDomain); got != enum.DomainStateOpen {
+		t.Fatalf("state = %d, want open", got)
+	}
+	if got := b.Allow(testDomain); got != Park {
+		t.Fatalf("Allow while open = %v, want Park", got)
+	}
+	// 熔断之前开始的下载失败时不改变状态，结果同样丢弃
+	if !b.Report(testDomain, false, "timeout") {
+		t.Fatal("failure while open not parked")
+	}
+	if got := b.Allow("other.com"); got != Allow {
+		t.Fatalf("Allow of other domain = %v", got)
+	}
+}
+
+func TestHalfOpenRecover(t *testing.T) {
+	b, calls := newTestBreaker(Config{Threshold: 1, Cooldown: time.Minute})
+	b.Report(testDomain, false, "timeout")
+
+	expire(b, testDomain)
+	if got := b.Allow(testDomain); got != Allow {
+		t.Fatalf("Allow after cooldown = %v, want Allow", got)
+	}
+	if got := state(b, testDomain); got != enum.DomainStateHalfOpen {
+		t.Fatalf("state = %d, want half_open", got)
+	}
+	// 探测进行中，其他url继续搁置
+	if got := b.Allow(testDomain); got != Park {
+		t.Fatalf("second Allow while half open = %v, want Park", got)
+	}
+
+	if b.Report(testDomain, true, "") {
+		t.Fatal("successful probe parked")
+	}
+	if got := state(b, testDomain); got != enum.DomainStateClosed {
+		t.Fatalf("state = %d, want closed", got)
+	}
+	expectResubmit(t, calls, 0)
+}
+
+func TestHalfOpenFailureDoublesCooldown(t *testing.T) {
+	cooldown := time.Minute
+	b, _ := newTestBreaker(Config{Threshold: 1, Cooldown: cooldown, MaxCooldown: 3 * cooldown})
+	b.Report(testDomain, false, "timeout")
+
+	for probes, want := range []time.Duration{2 * cooldown, 3 * cooldown} {
+		expire(b, testDomain)
+		b.Allow(testDomain)
+		start := time.Now()
+		if !b.Report(testDomain, false, "timeout") {
+			t.Fatalf("failed probe %d not parked", probes+1)
+		}
+		b.mu.Lock()
+		d := *b.domains[testDomain]
+		b.mu.Unlock()
+		if d.State != enum.DomainStateOpen || d.Probes != uint32(probes+1) {
+			t.Fatalf("state %d probes %d after failed probe %d", d.State, d.Probes, probes+1)
+		}
+		if got := d.OpenUntil.Sub(start); got < want-time.Second || got > want+time.Second {
+			t.Fatalf("cooldown after probe %d = %v, want %v", probes+1, got, want)
+		}
+	}
+}
+
+func TestDeadAfterMaxProbes(t *testing.T) {
+	b, calls := newTestBreaker(Config{Threshold: 1, Cooldown: time.Minute, MaxCooldown: time.Hour, MaxProbes: 2})
+	b.Report(testDomain, false, "timeout")
+
+	expire(b, testDomain)
+	b.Allow(testDomain)
+	b.Report(testDomain, false, "timeout")
+	expire(b, testDomain)
+	b.Allow(testDomain)
+	// dead之后url仍然搁置，不会被标记为失败
+	start := time.Now()
+	if !b.Report(testDomain, false, "timeout") {
+		t.Fatal("last probe not parked")
+	}
+	if got := state(b, testDomain); got != enum.DomainStateDead {
+		t.Fatalf("state = %d, want dead", got)
+	}
+	if got := b.Allow(testDomain); got != Park {
+		t.Fatalf("Allow when dead = %v, want Park", got)
+	}
+	b.mu.Lock()
+	openUntil := b.domains[testDomain].OpenUntil
+	b.mu.Unlock()
+	if got := openUntil.Sub(start); got < time.Hour-time.Second || got > time.Hour+time.Second {
+		t.Fatalf("cooldown when dead = %v, want max cooldown", got)
+	}
+
+	// 按照max_cooldown继续探测，失败时保持dead，成功时恢复
+	expire(b, testDomain)
+	if got := b.Allow(testDomain); got != Allow {
+		t.Fatalf("Allow after max cooldown = %v, want Allow", got)
+	}
+	if !b.Report(testDomain, false, "timeout") || state(b, testDomain) != enum.DomainStateDead {
+		t.Fatalf("failed probe of dead domain: state %d", state(b, testDomain))
+	}
+	expire(b, testDomain)
+	b.Allow(testDomain)
+	if b.Report(testDomain, true, "") || state(b, testDomain) != enum.DomainStateClosed {
+		t.Fatalf("successful probe of dead domain: state %d", state(b, testDomain))
+	}
+	expectResubmit(t, calls, 0)
+}
+
+// max_probes为0时一直按照翻倍的冷却时间探测
+func TestNoMaxProbes(t *testing.T) {
+	b, _ := newTestBreaker(Config{Threshold: 1, Cooldown: time.Minute})
+	b.Report(testDomain, false, "timeout")
+	for i := 0; i < 10; i++ {
+		expire(b, testDomain)
+		b.Allow(testDomain)
+		b.Report(testDomain, false, "timeout")
+		if got := state(b, testDomain); got != enum.DomainStateOpen {
+			t.Fatalf("state after probe %d = %d, want open", i+1, got)
+		}
+	}
+}
+
+func TestResetDead(t *testing.T) {
+	b, calls := newTestBreaker(Config{Threshold: 1, Cooldown: time.Minute, MaxProbes: 1})
+	b.Report(testDomain, false, "timeout")
+	b.Report("open.com", false, "timeout")
+	expire(b, testDomain)
+	b.Allow(testDomain)
+	b.Report(testDomain, false, "timeout")
+	if got := state(b, testDomain); got != enum.DomainStateDead {
+		t.Fatalf("state = %d, want dead", got)
+	}
+
+	b.ResetDead()
+	if got := state(b, testDomain); got != enum.DomainStateClosed {
+		t.Fatalf("state after reset = %d, want closed", got)
+	}
+	if got := b.Allow(testDomain); got != Allow {
+		t.Fatalf("Allow after reset = %v, want Allow", got)
+	}
+	// 仅重置dead的域名
+	if got := state(b, "open.com"); got != enum.DomainStateOpen {
+		t.Fatalf("state of open domain = %d, want open", got)
+	}
+	expectResubmit(t, calls, 0)
+}
+
+func TestDueProbes(t *testing.T) {
+	b, _ := newTestBreaker(Config{Threshold: 1, Cooldown: time.Minute})
+	b.Report(testDomain, false, "timeout")
+
+	now := time.Now()
+	if due := b.dueProbes(now); len(due) != 0 {
+		t.Fatalf("due probes before cooldown: %v", due)
+	}
+	expire(b, testDomain)
+	if due := b.dueProbes(now); len(due) != 1 || due[0] != testDomain {
+		t.Fatalf("due probes after cooldown = %v", due)
+	}
+	// 一个冷却周期内只提交一次
+	if due := b.dueProbes(now.Add(time.Second)); len(due) != 0 {
+		t.Fatalf("probe submitted twice: %v", due)
+	}
+	if due := b.dueProbes(now.Add(time.Minute)); len(due) != 1 {
+		t.Fatalf("probe not submitted again after cooldown: %v", due)
+	}
+}
+
+// dead的域名同样需要主动提交探测，间隔为max_cooldown
+func TestDueProbesDead(t *testing.T) {
+	b, _ := newTestBreaker(Config{Threshold: 1, Cooldown: time.Minute, MaxCooldown: time.Hour, MaxProbes: 1})
+	b.Report(testDomain, false, "timeout")
+	expire(b, testDomain)
+	b.Allow(testDomain)
+	b.Report(testDomain, false, "timeout")
+
+	expire(b, testDomain)
+	now := time.Now()
+	if due := b.dueProbes(now); len(due) != 1 {
+		t.Fatalf("due probes of dead domain = %v", due)
+	}
+	if due := b.dueProbes(now.Add(time.Minute)); len(due) != 0 {
+		t.Fatalf("probe of dead domain submitted again within max cooldown: %v", due)
+	}
+}
+
+func TestCooldown(t *testing.T) {
+	b, _ := newTestBreaker(Config{Cooldown: 10 * time.Second, MaxCooldown: 60 * time.Second})
+	tests := []struct {
+		probes uint32
+		want   time.Duration
+	}{
+		{0, 10 * time.Second},
+		{1, 20 * time.Second},
+		{2, 40 * time.Second},
+		{3, 60 * time.Second},
+		{100, 60 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := b.cooldown(tt.probes); got != tt.want {
+			t.Errorf("cooldown(%d) = %v, want %v", tt.probes, got, tt.want)
+		}
+	}
+}
+
+func TestHealthy(t *testing.T) {
+	tests := []struct {
+		name       string
+		page       entity.PageInfo
+		healthy    bool
+		reportable bool
+	}{
+		{"success", entity.PageInfo{State: enum.PageStateSuccess}, true, true},
+		{"not found", entity.PageInfo{State: enum.PageStateFailPermanent, StatusCode: 404}, true, true},
+		{"timeout", entity.PageInfo{State: enum.PageStateFailTransient}, false, true},
+		// 凭据或配置的问题不影响熔断
+		{"logged out", entity.PageInfo{State: enum.PageStateFailTransient, AuthFailed: true}, false, false},
+		{"unsupported auth", entity.PageInfo{State: enum.PageStateFailPermanent, AuthFailed: true}, true, false},
+	}
+	for _, tt := range tests {
+		if got := Healthy(tt.page); got != tt.healthy {
+			t.Errorf("%s: Healthy = %v, want %v", tt.name, got, tt.healthy)
+		}
+		if got := Reportable(tt.page); got != tt.reportable {
+			t.Errorf("%s: Reportable = %v, want %v", tt.name, got, tt.reportable)
+		}
+	}
+}
@@ -1,5 +1,12 @@
 package config
 
+import (
+	"fmt"
+
+	"github.com/andrewyi/crawler/src/frontier"
+	"github.com/andrewyi/crawler/src/util"
+)
+
 type Config struct {
 	Log struct {
 		Context bool   `mapstructure:"context"`
@@ -11,31 +18,177 @@ type Config struct {
 		PageInfoQueueSize       uint32 `mapstructure:"page_info_queue_size"`
 		ParsedPageInfoQueueSize uint32 `mapstructure:"parsed_page_info_queue_size"`
 		SeedFilePath            string `mapstructure:"seed_file_path"`
-		RetryTaskScanPeriod     uint32 `mapstructure:"retry_task_scan_period"`
-		TaskTimeout             uint32 `mapstructure:"task_timeout"`
-		CheckCompletedPeriod    uint32 `mapstructure:"check_completed_period"`
-	} `mapstructure:"log"`
+		RetryTaskScanPeriod     uint32 `mapstructure:"retry_task_scan_period"` // 回收过期租约、重试暂时性失败的间隔，单位秒
+		TaskTimeout             uint32 `mapstructure:"task_timeout"`           // 页面租约的有效期，单位秒
+		CheckCompletedPeriod    uint32 `mapstructure:"check_completed_period"` // postgres队列时通过数据库判断任务是否完成的间隔，单位秒
+		JobSyncPeriod           uint32 `mapstructure:"job_sync_period"`        // 重新载入job以及检查job是否完成的间隔，单位秒
+		Queue                   string `mapstructure:"queue"`                  // memory/disk/postgres，多进程部署时必须使用postgres
+		QueuePollInterval       uint32 `mapstructure:"queue_poll_interval"`    // postgres队列为空时的轮询间隔，单位毫秒
+		QueueSpillDir           string `mapstructure:"queue_spill_dir"`        // disk队列溢出文件的存放目录
+		QueueReportPeriod       uint32 `mapstructure:"queue_report_period"`    // 每隔多久打印一次队列长度，单位秒，0为不打印
+		ShutdownGracePeriod     uint32 `mapstructure:"shutdown_grace_period"`  // 关闭时等待进行中的任务完成的最长时间，单位秒
+
+		// 暂时性失败（fail_transient）的重试，由重试扫描在退避时间之后重新提交
+		FailureRetry struct {
+			MaxRetries uint32 `mapstructure:"max_retries"` // 最多重试次数，0为不重试
+			Backoff    uint32 `mapstructure:"backoff"`     // 首次退避时间，之后每次翻倍，单位秒
+			MaxBackoff uint32 `mapstructure:"max_backoff"` // 退避时间上限，单位秒
+		} `mapstructure:"failure_retry"`
+
+		// 爬取任务完成后（收到信号退出时不执行）、进程退出前依次执行的命令，例如导出数据、生成报告
+		PostCrawl struct {
+			Commands []string `mapstructure:"commands"` // 通过sh -c执行
+			Timeout  uint32   `mapstructure:"timeout"`  // 所有命令的总执行时间上限，单位秒，0为不限制
+		} `mapstructure:"post_crawl"`
+	} `mapstructure:"core"`
+
+	// url的下载顺序，多个scorer的分数相加，分数越高越先下载
+	Frontier struct {
+		Scorers []frontier.ScorerConfig `mapstructure:"scorers"`
+	} `mapstructure:"frontier"`
+
+	// 内置http服务，提供/metrics等接口，listen为空时不启动
+	HTTP struct {
+		Listen string `mapstructure:"listen"`
+		Token  string `mapstructure:"token"` // 管理接口(/api)的访问token，为空时不校验
+	} `mapstructure:"http"`
 
 	Database struct {
 		URL string `mapstructure:"url"`
 	} `mapstructure:"database"`
 
 	Storage struct {
-		Location string `mapstructure:"location"`
+		Location     string `mapstructure:"location"`
+		TextLocation string `mapstructure:"text_location"` // 提取出的正文纯文本的存放目录，为空时不存储
+		History      struct {
+			Location string `mapstructure:"location"` // 页面每个版本的存放目录，所有job共用，为空时不保存历史
+			Monitor  struct {
+				URLs     []string `mapstructure:"urls"`     // 正则表达式，匹配的url产生新版本时执行commands
+				Commands []string `mapstructure:"commands"` // 通过sh -c执行
+				Timeout  uint32   `mapstructure:"timeout"`  // 每个命令的执行时间上限，单位秒，0为不限制
+			} `mapstructure:"monitor"`
+		} `mapstructure:"history"`
 	} `mapstructure:"storage"`
 
 	Downloader struct {
 		Worker  uint32 `mapstructure:"worker"`
 		Timeout uint32 `mapstructure:"timeout"`
-		Retry   uint32 `mapstructure:"retry"`
+		Retry   uint32 `mapstructure:"retry"`    // 单次下载中暂时性失败的尝试次数
+		MaxSize int64  `mapstructure:"max_size"` // 内容大小上限，超过时标记为too_large，单位字节，0为不限制
+
+		HostRate float64 `mapstructure:"host_rate"` // 每个host每秒最多请求次数，0为不限制
+
+		// 域名熔断，连续失败达到threshold后该域名的url保持pending，冷却结束后探测恢复
+		Breaker struct {
+			Enabled     bool   `mapstructure:"enabled"`
+			Threshold   uint32 `mapstructure:"threshold"`    // 连续失败次数
+			Cooldown    uint32 `mapstructure:"cooldown"`     // 首次冷却时间，探测失败后翻倍，单位秒
+			MaxCooldown uint32 `mapstructure:"max_cooldown"` // 冷却时间上限，单位秒
+			MaxProbes   uint32 `mapstructure:"max_probes"`   // 连续探测失败达到此次数后标记为dead，之后按照max_cooldown探测，0为不标记
+		} `mapstructure:"breaker"`
+
+		// 根据url队列长度及下载耗时在[min, max]之间自动调整worker数量，启用时worker为初始数量
+		Autoscale struct {
+			Enabled       bool   `mapstructure:"enabled"`
+			Min           uint32 `mapstructure:"min"`
+			Max           uint32 `mapstructure:"max"`
+			Period        uint32 `mapstructure:"period"`         // 调整间隔，单位秒
+			TargetLatency uint32 `mapstructure:"target_latency"` // 平均下载耗时超过此值时减少worker，单位毫秒，0为不考虑耗时
+		} `mapstructure:"autoscale"`
+
+		// 使用headless chromium渲染页面，与普通下载共用downloader的worker池
+		Render struct {
+			Enabled  bool   `mapstructure:"enabled"`
+			ExecPath string `mapstructure:"exec_path"`
+			Worker   uint32 `mapstructure:"worker"`    // 同时渲染的页面数量上限
+			Timeout  uint32 `mapstructure:"timeout"`   // 单次渲染超时，单位秒
+			IdleTime uint32 `mapstructure:"idle_time"` // 未配置wait_selector时，等待network idle的最长时间，单位毫秒
+			Rules    []struct {
+				Domain       string `mapstructure:"domain"`        // 匹配域名及其子域名
+				Pattern      string `mapstructure:"pattern"`       // 匹配完整url的正则
+				WaitSelector string `mapstructure:"wait_selector"` // 等待此css selector出现后再提取DOM
+			} `mapstructure:"rules"`
+		} `mapstructure:"render"`
+
+		// 按域名配置的认证，按照配置顺序匹配域名及其子域名，render下载不使用
+		Auth []struct {
+			Domain   string `mapstructure:"domain"`
+			Type     string `mapstructure:"type"` // basic、digest、bearer、form
+			Username string `mapstructure:"username"`
+			Password string `mapstructure:"password"`
+			Token    string `mapstructure:"token"` // bearer
+			Form     struct {
+				LoginURL     string            `mapstructure:"login_url"`
+				FormSelector string            `mapstructure:"form_selector"` // 为空时使用包含密码输入框的表单
+				Fields       map[string]string `mapstructure:"fields"`        // 覆盖登录表单中的同名字段
+			} `mapstructure:"form"`
+			LoggedOutPattern string `mapstructure:"logged_out_pattern"` // 响应内容匹配此正则时认为session失效，重新登录
+		} `mapstructure:"auth"`
 	} `mapstructure:"downloader"`
 
 	Analyzer struct {
-		Worker uint32 `mapstructure:"worker"`
+		Worker  uint32 `mapstructure:"worker"`
+		Extract bool   `mapstructure:"extract"` // 提取正文、描述、语言、标题等信息
 	} `mapstructure:"analyzer"`
 
 	Controller struct {
 		Worker uint32 `mapstructure:"worker"`
 		Depth  uint8  `mapstructure:"depth"`
+		// 近似重复检测，重复的页面不再展开其中的sub url
+		Dedup struct {
+			Enabled     bool `mapstructure:"enabled"`
+			MaxDistance int  `mapstructure:"max_distance"` // 指纹汉明距离不超过此值时认为重复，最大为3
+		} `mapstructure:"dedup"`
+		// 是否遵守页面中的指令
+		Directives struct {
+			Canonical bool `mapstructure:"canonical"` // 记录canonical，指向其他url时不展开sub url，仅抓取canonical url
+			Hreflang  bool `mapstructure:"hreflang"`  // 抓取hreflang指向的其他语言版本
+			NoFollow  bool `mapstructure:"nofollow"`  // 页面级别nofollow时不展开sub url，跳过rel="nofollow"的链接
+			NoArchive bool `mapstructure:"noarchive"` // noarchive的页面不存储内容
+			NoIndex   bool `mapstructure:"noindex"`   // noindex的页面不写入全文索引
+		} `mapstructure:"directives"`
 	} `mapstructure:"controller"`
+
+	// 全文索引，由controller写入，同一个索引目录只能被一个进程打开
+	Index struct {
+		Enabled bool   `mapstructure:"enabled"`
+		Path    string `mapstructure:"path"`
+	} `mapstructure:"index"`
+
+	// 定时爬取，仅在daemon模式下由core角色运行，每次运行创建一个新的job（一代）
+	Events struct {
+		Buffer       uint32 `mapstructure:"buffer"`        // 每个sink的缓冲大小，已满时丢弃事件
+		FlushTimeout uint32 `mapstructure:"flush_timeout"` // 关闭时等待缓冲中的事件发送完成的最长时间，单位秒
+		Sinks        []struct {
+			Type       string   `mapstructure:"type"`        // stdout/file/webhook
+			Types      []string `mapstructure:"types"`       // 只发送这些类型的事件，为空时发送所有事件
+			Path       string   `mapstructure:"path"`        // file：jsonl文件路径，追加写入
+			URL        string   `mapstructure:"url"`         // webhook：以POST方式发送事件的json
+			Secret     string   `mapstructure:"secret"`      // webhook：不为空时使用HMAC-SHA256签名
+			Timeout    uint32   `mapstructure:"timeout"`     // webhook：单次请求的超时时间，单位秒
+			MaxRetries uint32   `mapstructure:"max_retries"` // webhook：失败后的最多重试次数
+			Backoff    uint32   `mapstructure:"backoff"`     // webhook：首次重试的等待时间，之后每次翻倍，单位秒
+		} `mapstructure:"sinks"`
+	} `mapstructure:"events"`
+
+	Scheduler struct {
+		Schedules []struct {
+			Name         string   `mapstructure:"name"` // 同时作为job id的前缀，只允许小写字母、数字、下划线及中划线
+			Cron         string   `mapstructure:"cron"` // 5段cron表达式，或者@daily、@every 6h等
+			SeedFilePath string   `mapstructure:"seed_file_path"`
+			Depth        uint8    `mapstructure:"depth"`        // 0时使用controller.depth
+			Scope        []string `mapstructure:"scope"`        // 允许抓取的域名（包含子域名），为空时不限制
+			MaxPages     uint32   `mapstructure:"max_pages"`    // 抓取成功的页面数量上限，0为不限制
+			MaxDuration  uint32   `mapstructure:"max_duration"` // 运行时间上限，单位秒，0为不限制
+		} `mapstructure:"schedules"`
+	} `mapstructure:"scheduler"`
+}
+
+// 所有子命令共用的配置加载
+func Load(path string) (*Config, error) {
+	var cfg = &Config{}
+	if err := util.ReadConfig(path, cfg); err != nil {
+		return nil, fmt.Errorf("fail to load config, err: %w", err)
+	}
+	return cfg, nil
 }
@@ -5,5 +5,5 @@ import (
 )
 
 type Controller interface {
-	Process(entity.ParsedPageInfo) []string
+	Process(entity.ParsedPageInfo) []entity.URLTask
 }
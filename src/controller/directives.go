@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"github.com/andrewyi/crawler/src/dbstorage/schema"
+	"github.com/andrewyi/crawler/src/entity"
+	"github.com/andrewyi/crawler/src/util"
+)
+
+// Directives 是否遵守页面中的各类指令，字段与配置controller.directives一一对应
+type Directives struct {
+	Canonical bool
+	Hreflang  bool
+	NoFollow  bool
+	NoArchive bool
+	NoIndex   bool
+}
+
+// 记录canonical，指向其他url时当前页面视为canonical页面的重复
+func (c *SimpleController) markCanonical(page *schema.Page, canonical string) {
+	if !c.directives.Canonical || canonical == "" {
+		return
+	}
+	nCanonical, err := util.ShortifyURL(canonical)
+	if err != nil {
+		c.logger.WithError(err).WithField("url", canonical).Error("fail to shortify canonical url")
+		return
+	}
+	page.Canonical = nCanonical
+	if nCanonical != page.URL {
+		page.DuplicateOf = nCanonical
+	}
+}
+
+// 需要继续抓取的url到链接文本的映射，hreflang链接的文本为语言代码
+func (c *SimpleController) followURLs(parsedPage entity.ParsedPageInfo) map[string]string {
+	var urls = make(map[string]string)
+	if c.directives.NoFollow && parsedPage.Robots.NoFollow {
+		return urls
+	}
+	// Links与SubURLs一一对应
+	for _, l := range parsedPage.Links {
+		if c.directives.NoFollow && l.NoFollow {
+			continue
+		}
+		urls[l.URL] = l.Text
+	}
+	if c.directives.Hreflang {
+		for _, a := range parsedPage.Alternates {
+			if _, ok := urls[a.URL]; !ok {
+				urls[a.URL] = a.Lang
+			}
+		}
+	}
+	return urls
+}
@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"github.com/andrewyi/crawler/src/dbstorage/schema"
+	"github.com/andrewyi/crawler/src/enum"
+	"github.com/andrewyi/crawler/src/event"
+)
+
+// 在数据库提交之后调用，url为原始url
+func (c *SimpleController) emitStored(jobID string, domain string, url string, page *schema.Page) {
+	var data = map[string]interface{}{
+		"depth":        page.Depth,
+		"title":        page.Title,
+		"storage_path": page.StoragePath,
+		"fetched_at":   page.FetchedAt,
+	}
+	if page.TextPath != "" {
+		data["text_path"] = page.TextPath
+	}
+	if page.DuplicateOf != "" {
+		data["duplicate_of"] = page.DuplicateOf
+	}
+	c.events.Emit(event.Event{
+		Type:   enum.EventPageStored,
+		Job:    jobID,
+		URL:    url,
+		Domain: domain,
+		Data:   data,
+	})
+}
+
+// retry为true时页面会在退避之后被重新下载，之后可能再次产生事件
+func (c *SimpleController) emitFailed(jobID string, domain string, url string, page *schema.Page) {
+	c.events.Emit(event.Event{
+		Type:   enum.EventPageFailed,
+		Job:    jobID,
+		URL:    url,
+		Domain: domain,
+		Data: map[string]interface{}{
+			"state":       enum.PageStateName(int(page.State)),
+			"remark":      page.Remark,
+			"retry_count": page.RetryCount,
+			"retry":       page.State == enum.PageStateFailTransient && page.RetryCount < c.retry.MaxRetries,
+		},
+	})
+}
@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/andrewyi/crawler/src/dbstorage"
+	"github.com/andrewyi/crawler/src/dbstorage/schema"
+	"github.com/andrewyi/crawler/src/entity"
+	"github.com/andrewyi/crawler/src/filestorage"
+	"github.com/andrewyi/crawler/src/history"
+)
+
+// History 页面内容的版本历史，所有job共用
+type History struct {
+	Location string // 为空时不保存历史
+	// 页面产生新的版本（不包括第一个版本）时调用，在数据库提交之后，可以为nil
+	OnChanged func(change history.Change)
+}
+
+func (h History) changed(change *history.Change) {
+	if change != nil && h.OnChanged != nil {
+		h.OnChanged(*change)
+	}
+}
+
+// 数据库中已经记录的版本，内容文件在提交之后由finishVersion写入
+// 文件先于数据库写入时，回滚会留下没有被任何版本引用的文件
+type versionUpdate struct {
+	domain string
+	files  []entity.ParsedPageInfo
+	change *history.Change
+}
+
+// 内容与url最近的版本不同时保存为新的版本，相同时只更新seen_at
+// 有正文时按正文判断，避免网页中的时间戳等无关内容产生新的版本
+// 不保存历史时返回nil，返回值需要在数据库提交之后交给finishVersion
+func (c *SimpleController) storeVersion(t *dbstorage.Transaction, jobID string, domain string, nURL string,
+	parsedPage entity.ParsedPageInfo, fetchedAt time.Time) (*versionUpdate, error) {
+
+	if c.history.Location == "" {
+		return nil, nil
+	}
+	text := parsedPage.Extracted.Text
+	hash := filestorage.ContentHash(parsedPage.Content)
+	var u = &versionUpdate{domain: domain, files: []entity.ParsedPageInfo{parsedPage}}
+	if text != "" {
+		hash = filestorage.ContentHash(text)
+		u.files = append(u.files, entity.ParsedPageInfo{URL: parsedPage.URL, Content: text})
+	}
+
+	latest, err := t.GetLatestPageVersionWithLock(nURL)
+	if err == dbstorage.ErrDataNotExist {
+		latest = nil
+	} else if err != nil {
+		return nil, err
+	} else if latest.ContentHash == hash {
+		// 内容相同的文件已经存在时不会重复写入，之前提交后写入失败的文件在此补写
+		latest.SeenAt = fetchedAt
+		_, err = t.UpdatePageVersion(latest, "seen_at")
+		return u, err
+	}
+
+	var v = &schema.PageVersion{
+		URL:         nURL,
+		Version:     1,
+		JobID:       jobID,
+		ContentHash: hash,
+		SimHash:     int64(parsedPage.SimHash),
+		FetchedAt:   fetchedAt,
+		SeenAt:      fetchedAt,
+	}
+	if latest != nil {
+		v.Version = latest.Version + 1
+	}
+	if v.StoragePath, err = c.versions.Path(domain, u.files[0]); err != nil {
+		return nil, err
+	}
+	if text != "" {
+		if v.TextPath, err = c.versions.Path(domain, u.files[1]); err != nil {
+			return nil, err
+		}
+	}
+	if _, err = t.InsertPageVersion(v); err != nil {
+		return nil, err
+	}
+
+	if latest != nil {
+		u.change = &history.Change{
+			JobID:     jobID,
+			URL:       parsedPage.URL,
+			Version:   v.Version,
+			Previous:  latest.Version,
+			FetchedAt: fetchedAt,
+		}
+	}
+	return u, nil
+}
+
+// 在数据库提交之后写入版本的内容文件，成功后通知变化
+// 写入失败时版本记录已经存在，下一次抓取到相同的内容时补写
+func (c *SimpleController) finishVersion(u *versionUpdate) {
+	if u == nil {
+		return
+	}
+	for _, f := range u.files {
+		if _, err := c.versions.Store(u.domain, f); err != nil {
+			c.logger.WithError(err).WithField("url", f.URL).Error("fail to store url version content")
+			return
+		}
+	}
+	c.history.changed(u.change)
+}
@@ -0,0 +1,28 @@
+package controller
+
+import (
+	"github.com/andrewyi/crawler/src/dbstorage/schema"
+	"github.com/andrewyi/crawler/src/entity"
+	"github.com/andrewyi/crawler/src/index"
+)
+
+// 重复页面（包括指向其他url的canonical）及noindex页面不建立索引
+func (c *SimpleController) indexPage(page *schema.Page, parsedPage entity.ParsedPageInfo) {
+	if c.index == nil || page.DuplicateOf != "" {
+		return
+	}
+	if c.directives.NoIndex && parsedPage.Robots.NoIndex {
+		return
+	}
+	doc := index.Document{
+		Job:       page.JobID,
+		URL:       page.URL,
+		Title:     page.Title,
+		Text:      parsedPage.Extracted.Text,
+		Domain:    page.Domain,
+		FetchedAt: page.FetchedAt,
+	}
+	if err := c.index.Index(doc); err != nil {
+		c.logger.WithError(err).WithField("url", page.URL).Error("fail to index page")
+	}
+}
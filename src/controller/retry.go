@@ -0,0 +1,32 @@
+package controller
+
+import (
+	"time"
+)
+
+// RetryPolicy 暂时性失败的退避时间，第n次重试之后失败时等待Backoff*2^n，不超过MaxBackoff
+type RetryPolicy struct {
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	MaxRetries uint32
+	// 页面之后会被重试扫描重新提交时调用，参数为所属的job及数据库中的url，可以为nil
+	OnScheduled func(jobID string, url string)
+}
+
+// 与重试扫描的条件一致，retry_count小于max_retries的页面才会被重新提交
+func (r RetryPolicy) scheduled(jobID string, url string, retryCount uint32) {
+	if r.OnScheduled != nil && retryCount < r.MaxRetries {
+		r.OnScheduled(jobID, url)
+	}
+}
+
+func (r RetryPolicy) backoff(retryCount uint32) time.Duration {
+	d := r.Backoff
+	for i := uint32(0); i < retryCount && d < r.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > r.MaxBackoff {
+		d = r.MaxBackoff
+	}
+	return d
+}
@@ -11,36 +11,66 @@ import (
 	"github.com/andrewyi/crawler/src/dbstorage/schema"
 	"github.com/andrewyi/crawler/src/entity"
 	"github.com/andrewyi/crawler/src/enum"
+	"github.com/andrewyi/crawler/src/event"
 	"github.com/andrewyi/crawler/src/filestorage"
+	"github.com/andrewyi/crawler/src/fingerprint"
+	"github.com/andrewyi/crawler/src/index"
+	"github.com/andrewyi/crawler/src/job"
+	"github.com/andrewyi/crawler/src/metrics"
 	"github.com/andrewyi/crawler/src/util"
 )
 
 type SimpleController struct {
-	ctx      context.Context
-	logger   *log.Logger
-	location string
-	depth    uint8
-
-	file filestorage.FileStorage
-	db   *dbstorage.SimpleDBStorage
+	ctx    context.Context
+	logger *log.Logger
+	// 深度、范围及存储位置由页面所属的job决定
+	jobs *job.Registry
+	// 小于0时不检测近似重复
+	dedupDistance int
+	directives    Directives
+	retry         RetryPolicy
+	history       History
+	events        *event.Bus // 为nil时不产生事件
+
+	// 按存储位置缓存，每个controller只在一个worker中使用，不需要加锁
+	files map[string]filestorage.FileStorage
+	// 版本历史的存储，仅在保存历史时创建
+	versions *filestorage.VersionedFileStorage
+	index    index.Index // 为nil时不建立索引
+	db       *dbstorage.SimpleDBStorage
 }
 
-func NewSimpleController(ctx context.Context, depth uint8, location string, dbStorage *dbstorage.SimpleDBStorage, logger *log.Logger) Controller {
+func NewSimpleController(ctx context.Context, jobs *job.Registry, dedupDistance int, directives Directives, retry RetryPolicy, hist History, events *event.Bus, idx index.Index, dbStorage *dbstorage.SimpleDBStorage, logger *log.Logger) Controller {
 
 	var c = &SimpleController{
-		ctx:      ctx,
-		logger:   logger,
-		location: location,
-		depth:    depth,
+		ctx:           ctx,
+		logger:        logger,
+		jobs:          jobs,
+		dedupDistance: dedupDistance,
+		directives:    directives,
+		retry:         retry,
+		history:       hist,
+		events:        events,
+		files:         make(map[string]filestorage.FileStorage),
+		index:         idx,
+	}
+	if hist.Location != "" {
+		c.versions = filestorage.NewVersionedFileStorage(ctx, hist.Location)
 	}
-
-	f := filestorage.NewSimpleFileStorage(ctx, c.location)
-	c.file = f
 	c.db = dbStorage
 	return c
 }
 
-func (c *SimpleController) Process(parsedPage entity.ParsedPageInfo) []string {
+func (c *SimpleController) storage(location string) filestorage.FileStorage {
+	f, ok := c.files[location]
+	if !ok {
+		f = filestorage.NewSimpleFileStorage(c.ctx, location)
+		c.files[location] = f
+	}
+	return f
+}
+
+func (c *SimpleController) Process(parsedPage entity.ParsedPageInfo) []entity.URLTask {
 
 	domain, err := util.GetDomain(parsedPage.URL)
 	if err != nil {
@@ -56,6 +86,14 @@ func (c *SimpleController) Process(parsedPage entity.ParsedPageInfo) []string {
 		return nil
 	}
 
+	jobID := job.Normalize(parsedPage.JobID)
+	j, err := c.jobs.Get(jobID)
+	if err != nil {
+		// 非致命错误，直接返回。job不存在时页面记录同样不存在，连接错误则交给重试机制
+		c.logger.WithError(err).WithField("job", jobID).Error("fail to get job")
+		return nil
+	}
+
 	t, err := c.db.NewTransaction()
 	if err != nil {
 		// 非致命错误，直接返回。后续操作交给重试机制
@@ -65,7 +103,7 @@ func (c *SimpleController) Process(parsedPage entity.ParsedPageInfo) []string {
 	defer t.Rollback()
 
 	// 获取url在数据库中对应的记录，记录必须存在
-	page, err := t.GetPageWithLock(nURL) // 当前记录被锁定，简单避免并发问题，但少许影响性能
+	page, err := t.GetPageWithLock(jobID, nURL) // 当前记录被锁定，简单避免并发问题，但少许影响性能
 	if err != nil {
 		// 非致命错误，直接返回。打印错误日志，后续需要人工介入分析
 		// 逻辑上不存在数据库中找不到记录的情况（都会事前创建），如果是连接错误则直接重试即可
@@ -73,23 +111,46 @@ func (c *SimpleController) Process(parsedPage entity.ParsedPageInfo) []string {
 		return nil
 	}
 
-	// 如果数据库中记录已经为成功处理，则直接返回即可
+	// 如果数据库中记录已经为成功处理（例如队列中的重复任务），页面记录不再更新
+	// 但重新抓取到的内容仍然计入版本历史，被监控的页面发生变化时同样需要通知
 	if page.State == enum.PageStateSuccess {
 		c.logger.WithField("url", nURL).Info("url has been processed")
+		if enum.IsPageFailState(parsedPage.State) || !c.archivable(parsedPage) {
+			return nil
+		}
+		version, err := c.storeVersion(t, jobID, domain, nURL, parsedPage, time.Now())
+		if err == nil {
+			err = t.Commit()
+		}
+		if err != nil {
+			c.logger.WithError(err).WithField("url", nURL).Error("fail to store url version")
+			return nil
+		}
+		c.finishVersion(version)
 		return nil
 	}
 
-	if parsedPage.State == enum.PageStateFail {
-		// 更新为失败状态
-		page.State = enum.PageStateFail
+	if enum.IsPageFailState(parsedPage.State) {
+		// 更新为失败状态，暂时性失败在退避时间之后由重试扫描重新下载
+		page.State = uint8(parsedPage.State)
 		page.Remark = parsedPage.Remark
+		if parsedPage.State == enum.PageStateFailTransient {
+			page.NextRetryAt = time.Now().Add(c.retry.backoff(page.RetryCount))
+		}
 		_, err = t.UpdatePage(page)
 		if err != nil {
 			c.logger.WithError(err).WithField("url", nURL).Info("update failed")
 			return nil
 		}
 
-		t.Commit()
+		if err = t.Commit(); err != nil {
+			c.logger.WithError(err).WithField("url", nURL).Error("fail to commit")
+			return nil
+		}
+		if parsedPage.State == enum.PageStateFailTransient {
+			c.retry.scheduled(jobID, nURL, page.RetryCount)
+		}
+		c.emitFailed(jobID, domain, parsedPage.URL, page)
 		return nil
 	}
 
@@ -103,41 +164,93 @@ func (c *SimpleController) Process(parsedPage entity.ParsedPageInfo) []string {
 		return nil
 	}
 	page.SubURLs = string(subURLsString)
-	_, err = t.UpdatePage(page)
-	if err != nil {
-		c.logger.WithError(err).WithField("url", nURL).Info("update failed")
+	page.Title = parsedPage.Title
+	if err = c.setExtracted(page, parsedPage.Extracted); err != nil {
+		c.logger.WithError(err).WithField("url", nURL).Error("fail to marshal headings")
 		return nil
 	}
+	page.Robots = parsedPage.Robots.String()
+	c.markCanonical(page, parsedPage.Canonical)
+	if err = c.markDuplicate(t, page, parsedPage.SimHash); err != nil {
+		c.logger.WithError(err).WithField("url", nURL).Error("fail to find near duplicate")
+		return nil
+	}
+
+	// 执行文件系统存储，需要先于数据库更新以记录存储路径
+	var version *versionUpdate
+	if c.archivable(parsedPage) {
+		page.StoragePath, err = c.storage(j.StorageLocation).Store(domain, parsedPage) // 可以安全重试
+		if err != nil {
+			// TODO: 区分文件存储的致命错误（例如磁盘空间不足、权限问题）
+			// 当前视为非致命错误，返回并继续
+			c.logger.WithError(err).WithField("domain", domain).Error("fail to store url content")
+			return nil
+		}
+		if j.TextLocation != "" && parsedPage.Extracted.Text != "" {
+			textPage := entity.ParsedPageInfo{URL: parsedPage.URL, Content: parsedPage.Extracted.Text}
+			if page.TextPath, err = c.storage(j.TextLocation).Store(domain, textPage); err != nil {
+				c.logger.WithError(err).WithField("domain", domain).Error("fail to store url text")
+				return nil
+			}
+		}
+		// 与当前job的存储不同，历史中的每个版本都会保留
+		if version, err = c.storeVersion(t, jobID, domain, nURL, parsedPage, page.FetchedAt); err != nil {
+			c.logger.WithError(err).WithField("url", nURL).Error("fail to store url version")
+			return nil
+		}
+	}
 
-	// 执行文件系统存储
-	err = c.file.Store(domain, parsedPage) // 可以安全重试
+	_, err = t.UpdatePage(page)
 	if err != nil {
-		// TODO: 区分文件存储的致命错误（例如磁盘空间不足、权限问题）
-		// 当前视为非致命错误，返回并继续
-		c.logger.WithError(err).WithField("domain", domain).Error("fail to store url content")
+		c.logger.WithError(err).WithField("url", nURL).Info("update failed")
 		return nil
 	}
 
 	// 继续处理后续任务（subURLs），注意如果爬取失败则直接忽略
-	// 转SubURLs为set
-	var toCheckSubURLs = make(map[string]struct{})
-	for _, s := range parsedPage.SubURLs {
-		toCheckSubURLs[s] = struct{}{}
+	var toCheckSubURLs map[string]string
+	switch {
+	case page.Canonical != "" && page.DuplicateOf == page.Canonical:
+		// 非canonical页面不展开sub url，仅确保canonical页面被抓取
+		toCheckSubURLs = map[string]string{parsedPage.Canonical: ""}
+	case page.DuplicateOf != "":
+		// 近似重复的页面不再展开sub url
+		if err = t.Commit(); err != nil {
+			c.logger.WithError(err).WithField("url", nURL).Error("fail to commit")
+			return nil
+		}
+		metrics.DuplicatePages.Inc()
+		c.finishVersion(version)
+		c.emitStored(jobID, domain, parsedPage.URL, page)
+		return nil
+	default:
+		toCheckSubURLs = c.followURLs(parsedPage)
 	}
-	subURLs, err := c.ProcessSubURLs(t, page, toCheckSubURLs)
+	subURLs, err := c.ProcessSubURLs(t, j, page, toCheckSubURLs)
 	if err != nil {
 		c.logger.WithError(err).WithField("url", nURL).Error("fail to process sub url records")
 		return nil
 
-	} else {
-		t.Commit() // 最终无误后commit
+	} else if err = t.Commit(); err != nil { // 最终无误后commit
+		c.logger.WithError(err).WithField("url", nURL).Error("fail to commit")
+		return nil
 	}
 
+	// 索引在数据库更新之后写入，失败时仅记录日志，页面不会因此被重新抓取
+	c.indexPage(page, parsedPage)
+	c.finishVersion(version)
+	c.emitStored(jobID, domain, parsedPage.URL, page)
+
 	return subURLs
 }
 
+// 页面声明noarchive且配置了遵守时不保存内容及版本历史
+func (c *SimpleController) archivable(parsedPage entity.ParsedPageInfo) bool {
+	return !(c.directives.NoArchive && parsedPage.Robots.NoArchive)
+}
+
+// subURLs为sub url到链接文本的映射，返回需要继续下载的url，j为page所属的job
 func (c *SimpleController) ProcessSubURLs(
-	t *dbstorage.Transaction, page *schema.Page, subURLs map[string]struct{}) ([]string, error) {
+	t *dbstorage.Transaction, j *schema.Job, page *schema.Page, subURLs map[string]string) ([]entity.URLTask, error) {
 
 	// 提取page的path中所有url
 	var URLPaths [][]string
@@ -150,7 +263,7 @@ func (c *SimpleController) ProcessSubURLs(
 	var shortPathDepth bool
 
 	for _, p := range URLPaths {
-		if len(p) < int(c.depth) {
+		if len(p) < int(j.Depth) {
 			shortPathDepth = true
 			break
 		}
@@ -166,16 +279,20 @@ func (c *SimpleController) ProcessSubURLs(
 		}
 	}
 
-	var toProcessSubURLs = make(map[string]struct{})
+	var toProcessSubURLs = make(map[string]entity.URLTask)
 
 	// 开始针对每一个subURL判断是否需要进行爬取
-	for subURL := range subURLs {
+	for subURL, anchor := range subURLs {
 		nURL, err := util.ShortifyURL(subURL)
 		if err != nil {
 			c.logger.WithError(err).WithField("url", subURL).Error("fail to shortify url")
 			return nil, nil
 		}
-		nPage, err := t.GetPageWithLock(nURL) // 当前记录被锁定，简单避免并发问题，但少许影响性能
+		nDomain, _ := util.GetDomain(subURL)
+		if !c.jobs.InScope(j.ID, nDomain) { // 超出job的抓取范围，不记录
+			continue
+		}
+		nPage, err := t.GetPageWithLock(j.ID, nURL) // 当前记录被锁定，简单避免并发问题，但少许影响性能
 		if err != nil {
 			if err == dbstorage.ErrDataNotExist { // nURL不存在，直接插入
 				var nPageURLPaths [][]string
@@ -190,8 +307,11 @@ func (c *SimpleController) ProcessSubURLs(
 					return nil, err
 				}
 				nPage = &schema.Page{
-					URL:   nURL,
-					Paths: string(pathsStr),
+					JobID:  j.ID,
+					URL:    nURL,
+					Domain: nDomain,
+					Depth:  page.Depth + 1,
+					Paths:  string(pathsStr),
 				}
 				_, err = t.InsertPage(nPage)
 				if err != nil {
@@ -199,7 +319,13 @@ func (c *SimpleController) ProcessSubURLs(
 					return nil, err
 				}
 
-				toProcessSubURLs[subURL] = struct{}{}
+				toProcessSubURLs[subURL] = entity.URLTask{
+					JobID:  j.ID,
+					URL:    subURL,
+					Depth:  nPage.Depth,
+					Parent: page.URL,
+					Anchor: anchor,
+				}
 				continue
 
 			} else {
@@ -238,6 +364,9 @@ func (c *SimpleController) ProcessSubURLs(
 			return nil, err
 		}
 		nPage.Paths = string(nPagePathsStr)
+		if nPage.Depth > page.Depth+1 { // 找到了更短的路径
+			nPage.Depth = page.Depth + 1
+		}
 		_, err = t.UpdatePage(nPage)
 		if err != nil {
 			c.logger.WithError(err).WithField("url", nURL).Info("update failed")
@@ -253,27 +382,65 @@ func (c *SimpleController) ProcessSubURLs(
 				c.logger.WithError(err).WithField("sub_urls", page.Paths).Error("fail to unmarshal sub urls")
 				return nil, err
 			}
-			var nSubURLs = make(map[string]struct{})
+			var nSubURLs = make(map[string]string)
 			for _, u := range nPageSubURLs {
-				nSubURLs[u] = struct{}{}
+				nSubURLs[u] = ""
 			}
 
-			us, err := c.ProcessSubURLs(t, nPage, nSubURLs)
+			us, err := c.ProcessSubURLs(t, j, nPage, nSubURLs)
 			if err != nil {
 				c.logger.WithError(err).WithField("url", nPage.URL).Error("fail to process sub url records")
 				return nil, err
 			}
 			for _, k := range us {
-				toProcessSubURLs[k] = struct{}{}
+				toProcessSubURLs[k.URL] = k
 			}
 		}
 
 	}
 
-	var toReturn []string
-	for k := range toProcessSubURLs {
+	var toReturn []entity.URLTask
+	for _, k := range toProcessSubURLs {
 		toReturn = append(toReturn, k)
 	}
 
 	return toReturn, nil
 }
+
+// 记录页面指纹，存在近似重复的页面时将DuplicateOf指向它
+func (c *SimpleController) markDuplicate(t *dbstorage.Transaction, page *schema.Page, hash uint64) error {
+	page.SimHash = int64(hash)
+	bands := fingerprint.SplitBands(hash)
+	page.SimBand0, page.SimBand1, page.SimBand2, page.SimBand3 = bands[0], bands[1], bands[2], bands[3]
+	if c.dedupDistance < 0 || hash == 0 || page.DuplicateOf != "" {
+		return nil
+	}
+
+	canonical, err := t.FindNearDuplicate(page.JobID, page.URL, hash, c.dedupDistance)
+	if err == dbstorage.ErrDataNotExist {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	page.DuplicateOf = canonical.URL
+	return nil
+}
+
+// 记录提取出的元信息，提取出的标题优先于title标签
+func (c *SimpleController) setExtracted(page *schema.Page, e entity.Extracted) error {
+	if e.Title != "" {
+		page.Title = e.Title
+	}
+	page.Description = e.Description
+	page.Language = e.Language
+	if len(e.Headings) == 0 {
+		return nil
+	}
+	headings, err := json.Marshal(e.Headings)
+	if err != nil {
+		return err
+	}
+	page.Headings = string(headings)
+	return nil
+}
@@ -5,23 +5,48 @@ import (
 	"context"
 	"encoding/json"
 	"os"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/andrewyi/crawler/src/dbstorage"
 	"github.com/andrewyi/crawler/src/dbstorage/schema"
+	"github.com/andrewyi/crawler/src/entity"
 	"github.com/andrewyi/crawler/src/enum"
+	"github.com/andrewyi/crawler/src/frontier"
+	"github.com/andrewyi/crawler/src/tracker"
 	"github.com/andrewyi/crawler/src/util"
 )
 
+const (
+	restoreBatchSize = 500
+	// 每批回收的过期租约数量
+	reapBatchSize = 500
+	// 每次重试扫描最多重新提交的失败页面数量
+	retryFailedBatchSize = 500
+)
+
 // 导入seed文件数据，从而启动整个程序运转流程
-func CreateSeedRecord(logger *log.Logger, urlQueue chan string, dbStorage *dbstorage.SimpleDBStorage, seedFilePath string) {
+func CreateSeedRecord(ctx context.Context, logger *log.Logger, urlFrontier frontier.Frontier, dbStorage *dbstorage.SimpleDBStorage, jobID string, seedFilePath string, work *tracker.Tracker) {
 
-	file, err := os.Open(seedFilePath)
+	URLs, err := ReadSeedFile(seedFilePath)
 	if err != nil {
 		logger.WithError(err).Fatal("fail to read seed file")
 	}
+
+	// 当前处于启动阶段，可以直接报错
+	if _, err = AddSeeds(ctx, logger, urlFrontier, dbStorage, jobID, URLs, work); err != nil {
+		logger.WithError(err).Fatal("fail to add seeds")
+	}
+}
+
+// 每行一个url，空行在AddSeeds中被忽略
+func ReadSeedFile(seedFilePath string) ([]string, error) {
+	file, err := os.Open(seedFilePath)
+	if err != nil {
+		return nil, err
+	}
 	defer file.Close()
 	scanner := bufio.NewScanner(file)
 	scanner.Split(bufio.ScanLines)
@@ -30,78 +55,192 @@ func CreateSeedRecord(logger *log.Logger, urlQueue chan string, dbStorage *dbsto
 	for scanner.Scan() {
 		URLs = append(URLs, scanner.Text())
 	}
+	return URLs, scanner.Err()
+}
 
+// 插入seed记录并提交下载任务，已经存在于该job中的url将被忽略，返回新加入的url
+// urlFrontier为nil时仅插入记录，work不为nil时，发送完成之前不会认为任务已经结束
+func AddSeeds(ctx context.Context, logger *log.Logger, urlFrontier frontier.Frontier, dbStorage *dbstorage.SimpleDBStorage, jobID string, URLs []string, work *tracker.Tracker) ([]string, error) {
 	t, err := dbStorage.NewTransaction()
 	if err != nil {
-		logger.WithError(err).Fatal("fail to start transaction")
+		return nil, err
 	}
 	defer t.Rollback()
 
 	var toSendURLs []string
 
 	for _, URL := range URLs {
-		_, err := t.GetPageWithLock(URL)
+		URL = strings.TrimSpace(URL)
+		if URL == "" {
+			continue
+		}
+		nURL, err := util.ShortifyURL(URL)
 		if err != nil {
-			if err == dbstorage.ErrDataNotExist { // URL不存在，直接插入
-				nURL, err := util.ShortifyURL(URL)
-				if err != nil {
-					logger.WithError(err).WithField("url", URL).Error("fail to shortify url")
-				}
-				var paths [][]string = [][]string{
-					{nURL},
-				}
-				pathsStr, err := json.Marshal(paths)
-				if err != nil {
-					logger.WithError(err).WithField(
-						"paths", paths).Fatal("fail to marshal paths into string")
-				}
-				page := &schema.Page{
-					URL:   nURL,
-					Paths: string(pathsStr),
-				}
-				_, err = t.InsertPage(page)
-				if err != nil {
-					logger.WithError(err).WithField("url", page.URL).Fatal("fail to insert page")
-				}
-
-				toSendURLs = append(toSendURLs, URL)
-
-			} else { // query错误，当前处于启动阶段，可以直接报错
-				logger.WithError(err).WithField("url", URL).Fatal("fail to get page")
+			logger.WithError(err).WithField("url", URL).Error("fail to shortify url")
+			continue
+		}
+		_, err = t.GetPageWithLock(jobID, nURL)
+		if err == nil { // 已经存在
+			continue
+		}
+		if err != dbstorage.ErrDataNotExist {
+			return nil, err
+		}
 
-			}
+		// URL不存在，直接插入
+		var paths [][]string = [][]string{
+			{nURL},
+		}
+		pathsStr, err := json.Marshal(paths)
+		if err != nil {
+			return nil, err
+		}
+		domain, _ := util.GetDomain(URL)
+		page := &schema.Page{
+			JobID:  jobID,
+			URL:    nURL,
+			Domain: domain,
+			Paths:  string(pathsStr),
 		}
+		if _, err = t.InsertPage(page); err != nil {
+			return nil, err
+		}
+
+		toSendURLs = append(toSendURLs, URL)
+	}
+	if err = t.Commit(); err != nil {
+		return nil, err
 	}
-	t.Commit()
 
+	if urlFrontier == nil { // 仅插入记录，由crawl启动时提交
+		return toSendURLs, nil
+	}
+	release := work.Hold()
 	go func() { // 启动新协程发送，防止阻塞主任务
+		defer release()
 		for _, u := range toSendURLs {
-			urlQueue <- u
+			if err := urlFrontier.Push(ctx, entity.URLTask{JobID: jobID, URL: u, Seed: true}); err != nil {
+				return
+			}
+		}
+	}()
+	return toSendURLs, nil
+}
+
+// 提交调用时数据库中已有的所有pending url，按id顺序分批读取
+// 仅用于单进程部署，上一次运行遗留的租约会被清除，否则这些url在租约到期之前无法下载
+// work不为nil时，提交完成之前不会认为任务已经结束
+func RestorePending(ctx context.Context, logger *log.Logger, urlFrontier frontier.Frontier, dbStorage *dbstorage.SimpleDBStorage, work *tracker.Tracker) {
+	t, err := dbStorage.NewTransaction()
+	if err != nil {
+		logger.WithError(err).Fatal("fail to start transaction")
+	}
+	maxID, err := t.GetMaxPageID()
+	if err != nil {
+		logger.WithError(err).Fatal("fail to get max page id")
+	}
+	if _, err = t.ClearAllLeases(); err != nil {
+		logger.WithError(err).Fatal("fail to clear leases")
+	}
+	if err = t.Commit(); err != nil {
+		logger.WithError(err).Fatal("fail to commit cleared leases")
+	}
+
+	var filter = dbstorage.PageFilter{State: enum.PageStatePending, MaxID: maxID}
+	release := work.Hold()
+	go func() {
+		defer release()
+		var restored int
+		err := dbStorage.ScanPages(filter, restoreBatchSize, func(p *schema.Page) error {
+			restored++
+			return urlFrontier.Push(ctx, entity.URLTask{JobID: p.JobID, URL: p.URL, Depth: p.Depth, Seed: p.Depth == 0})
+		})
+		if err != nil && ctx.Err() == nil {
+			logger.WithError(err).Error("fail to restore pending urls")
+			return
 		}
+		logger.WithField("count", restored).Info("pending urls restored")
 	}()
 }
 
-// 当前仅仅分析超过一定时候仍然处于pending的任务（即没有成功或者失败）
-// 并没有重试失败的任务，如果需要重试失败任务，则需要更加清晰定义state，即表明哪些错误是可以重试的，哪些又不可以
-func CreateRetryTask(ctx context.Context, logger *log.Logger, urlQueue chan string, dbStorage *dbstorage.SimpleDBStorage, scanPeriod uint32, taskTimeout uint32) {
+// 定时回收租约过期的pending任务（持有租约的进程退出或者卡住），以及到达重试时间的暂时性失败任务
+// 其余失败状态（fail_permanent、too_large等）重试不会成功，不做处理
+func CreateRetryTask(ctx context.Context, logger *log.Logger, urlFrontier frontier.Frontier, dbStorage *dbstorage.SimpleDBStorage, scanPeriod uint32, maxRetries uint32) {
 
-	timer := time.NewTimer(time.Second * time.Duration(scanPeriod))
+	ticker := time.NewTicker(time.Second * time.Duration(scanPeriod))
 	go func() {
+		defer ticker.Stop()
 		for {
 			select {
 			case <-ctx.Done():
-				break
-			case <-timer.C:
-				//dowork
-				RetryTask(logger, urlQueue, dbStorage, taskTimeout)
+				return
+			case <-ticker.C:
+				RetryTask(ctx, logger, urlFrontier, dbStorage)
+				RetryFailedTask(ctx, logger, urlFrontier, dbStorage, maxRetries)
 			}
 		}
 	}()
 
 }
 
-func RetryTask(logger *log.Logger, urlQueue chan string, dbStorage *dbstorage.SimpleDBStorage, taskTimeout uint32) {
+// 清除过期的租约并重新提交下载，多个进程同时回收时通过行锁避免重复提交
+// 原持有者如果仍在运行，其结果仍然可以正常写入（controller会忽略已经成功的页面）
+func RetryTask(ctx context.Context, logger *log.Logger, urlFrontier frontier.Frontier, dbStorage *dbstorage.SimpleDBStorage) {
+	var pages []*schema.Page
+	for {
+		batch, err := reapExpiredLeases(dbStorage)
+		if err != nil {
+			logger.WithError(err).Error("fail to reap expired leases")
+			break
+		}
+		pages = append(pages, batch...)
+		if len(batch) < reapBatchSize {
+			break
+		}
+	}
+	if len(pages) == 0 {
+		return
+	}
+	logger.WithField("count", len(pages)).Info("expired leases reaped")
+
+	go func() {
+		for _, p := range pages {
+			if err := urlFrontier.Push(ctx, entity.URLTask{JobID: p.JobID, URL: p.URL, Depth: p.Depth, Seed: p.Depth == 0}); err != nil {
+				return
+			}
+		}
+	}()
+}
 
+func reapExpiredLeases(dbStorage *dbstorage.SimpleDBStorage) ([]*schema.Page, error) {
+	t, err := dbStorage.NewTransaction()
+	if err != nil {
+		return nil, err
+	}
+	defer t.Rollback()
+
+	pages, err := t.GetExpiredLeasesWithLock(time.Now(), reapBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	var ids = make([]uint64, 0, len(pages))
+	for _, p := range pages {
+		ids = append(ids, p.ID)
+	}
+	if err = t.ClearLeases(ids); err != nil {
+		return nil, err
+	}
+	if err = t.Commit(); err != nil {
+		return nil, err
+	}
+	return pages, nil
+}
+
+// 将到达重试时间的暂时性失败页面重新置为pending并提交下载，重试次数达到maxRetries后不再重试
+func RetryFailedTask(ctx context.Context, logger *log.Logger, urlFrontier frontier.Frontier, dbStorage *dbstorage.SimpleDBStorage, maxRetries uint32) {
+	if maxRetries == 0 {
+		return
+	}
 	t, err := dbStorage.NewTransaction()
 	if err != nil {
 		logger.WithError(err).Error("fail to start transaction")
@@ -109,41 +248,57 @@ func RetryTask(logger *log.Logger, urlQueue chan string, dbStorage *dbstorage.Si
 	}
 	defer t.Rollback()
 
-	flagTime := time.Now().Add(-1 * time.Second * time.Duration(taskTimeout))
-
-	pages, err := t.GetPendingPageWithTimeoutAndLimit(flagTime, enum.MaxRetryTaskNum)
+	pages, err := t.GetRetryablePagesWithLock(time.Now(), maxRetries, retryFailedBatchSize)
 	if err != nil {
-		logger.WithError(err).Error("fail to get pages")
+		logger.WithError(err).Error("fail to get retryable pages")
 		return
 	}
+	var ids = make([]uint64, 0, len(pages))
+	for _, p := range pages {
+		ids = append(ids, p.ID)
+	}
+	if err = t.RequeuePages(ids); err != nil {
+		logger.WithError(err).Error("fail to requeue pages")
+		return
+	}
+	if err = t.Commit(); err != nil {
+		logger.WithError(err).Error("fail to commit requeued pages")
+		return
+	}
+	if len(pages) > 0 {
+		logger.WithField("count", len(pages)).Info("failed pages requeued")
+	}
 
 	go func() {
 		for _, p := range pages {
-			urlQueue <- p.URL
+			if err := urlFrontier.Push(ctx, entity.URLTask{JobID: p.JobID, URL: p.URL, Depth: p.Depth, Seed: p.Depth == 0}); err != nil {
+				return
+			}
 		}
 	}()
 }
 
-// 当前判断程序运行结束的方式为：
-// 定时扫描所有的url信息，判断如果已经没有pending的url，则任务运行结束
+// 多进程部署（postgres队列）时判断程序运行结束的方式为：
+// 定时扫描所有的url信息，判断如果已经没有pending以及等待重试的url，则任务运行结束
+// 单进程部署时由进程内的工作量统计判断，见tracker
 // TODO: 事实上当存储发生sharding时，这个查询就变得非常困难，因此还需要持续优化，但是目前没有想到优化方式
-func CreateCheckCompletedTask(ctx context.Context, logger *log.Logger, dbStorage *dbstorage.SimpleDBStorage, checkPeriod uint32, finished chan struct{}) {
+func CreateCheckCompletedTask(ctx context.Context, logger *log.Logger, dbStorage *dbstorage.SimpleDBStorage, checkPeriod uint32, maxRetries uint32, finished chan struct{}) {
 
-	timer := time.NewTimer(time.Second * time.Duration(checkPeriod))
+	ticker := time.NewTicker(time.Second * time.Duration(checkPeriod))
 	go func() {
+		defer ticker.Stop()
 		for {
 			select {
 			case <-ctx.Done():
-				break
-			case <-timer.C:
-				//dowork
-				CheckTask(logger, dbStorage, finished)
+				return
+			case <-ticker.C:
+				CheckTask(logger, dbStorage, maxRetries, finished)
 			}
 		}
 	}()
 }
 
-func CheckTask(logger *log.Logger, dbStorage *dbstorage.SimpleDBStorage, finished chan struct{}) {
+func CheckTask(logger *log.Logger, dbStorage *dbstorage.SimpleDBStorage, maxRetries uint32, finished chan struct{}) {
 	t, err := dbStorage.NewTransaction()
 	if err != nil {
 		logger.WithError(err).Error("fail to start transaction")
@@ -155,9 +310,18 @@ func CheckTask(logger *log.Logger, dbStorage *dbstorage.SimpleDBStorage, finishe
 		logger.WithError(err).Error("fail to get pending page count")
 		return
 	}
+	retryable, err := t.GetRetryablePageCount(maxRetries)
+	if err != nil {
+		logger.WithError(err).Error("fail to get retryable page count")
+		return
+	}
 
-	if count == 0 {
-		finished <- struct{}{}
+	if count+retryable == 0 {
+		// 已经通知过时不再阻塞
+		select {
+		case finished <- struct{}{}:
+		default:
+		}
 	}
 
 }
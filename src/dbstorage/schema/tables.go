@@ -7,17 +7,152 @@ import (
 
 type Page struct {
 	ID        uint64    `xorm:"bigint pk autoincr 'id'"`
-	URL       string    `xorm:"varchar(2048) notnull unique(uk_url) 'url'"`
+	JobID     string    `xorm:"varchar(48) notnull default 'default' unique(uk_job_url) 'job_id'"` // 不同job中的同一个url为不同的记录
+	URL       string    `xorm:"varchar(2048) notnull unique(uk_job_url) 'url'"`
 	Domain    string    `xorm:"varchar(256) notnull 'domain'"`
 	State     uint8     `xorm:"int 'state'"`
+	Depth     uint8     `xorm:"int notnull default 0 'depth'"` // 距离seed的最短深度，seed为0
 	Remark    string    `xorm:"text 'remark'"`
 	Paths     string    `xorm:"text 'paths'"`
 	SubURLs   string    `xorm:"text 'sub_urls'"`
 	FetchedAt time.Time `xorm:"datetime 'fetched_at'"`
 	CreatedAt time.Time `xorm:"created notnull 'created_at'"`
-	UpdatedAt time.Time `xorm:"updated notnull 'updated_at'"`
+	UpdatedAt time.Time `xorm:"updated notnull index 'updated_at'"` // 增量导出按此字段遍历
+
+	// 暂时性失败的重试次数，以及下一次允许重试的时间
+	RetryCount  uint32    `xorm:"int notnull default 0 'retry_count'"`
+	NextRetryAt time.Time `xorm:"datetime index 'next_retry_at'"`
+
+	// 正在处理该页面的进程及租约到期时间，仅在pending状态下有效，到期后由回收任务重新提交
+	LeaseOwner  string    `xorm:"varchar(128) notnull default '' 'lease_owner'"`
+	LeasedUntil time.Time `xorm:"datetime index 'leased_until'"`
+	// 因域名熔断被搁置的pending页面，不在任何队列中，域名恢复（或放弃探测）后只重新提交这些页面
+	Parked bool `xorm:"bool notnull default false 'parked'"`
+
+	StoragePath string `xorm:"text 'storage_path'"` // 内容在文件存储中的路径
+	Title       string `xorm:"text 'title'"`
+
+	// 由analyzer提取的信息，正文纯文本存储在文件中
+	Description string `xorm:"text 'description'"`
+	Language    string `xorm:"varchar(32) 'language'"`
+	Headings    string `xorm:"text 'headings'"` // json数组
+	TextPath    string `xorm:"text 'text_path'"`
+
+	// 内容指纹及其band，band上建有索引用于查找近似重复的页面，sim_hash为0表示没有指纹
+	SimHash  int64 `xorm:"bigint notnull default 0 'sim_hash'"`
+	SimBand0 int32 `xorm:"int notnull default 0 index 'sim_band0'"`
+	SimBand1 int32 `xorm:"int notnull default 0 index 'sim_band1'"`
+	SimBand2 int32 `xorm:"int notnull default 0 index 'sim_band2'"`
+	SimBand3 int32 `xorm:"int notnull default 0 index 'sim_band3'"`
+	// 页面声明的canonical url，以及meta robots/X-Robots-Tag中的指令（逗号分隔）
+	Canonical string `xorm:"varchar(2048) notnull default '' 'canonical'"`
+	Robots    string `xorm:"varchar(64) notnull default '' 'robots'"`
+	// 重复页面指向的url：canonical指向其他页面时为canonical url，近似重复时为最早抓取的相似页面，为空表示不是重复页面
+	DuplicateOf string `xorm:"varchar(2048) notnull default '' 'duplicate_of'"`
+
+	// 链接分析结果，由graph命令写入
+	InDegree  int32   `xorm:"int notnull default 0 'in_degree'"`
+	OutDegree int32   `xorm:"int notnull default 0 'out_degree'"`
+	PageRank  float64 `xorm:"double notnull default 0 'page_rank'"`
+	Component int32   `xorm:"int notnull default 0 'component'"` // 所属强连通分量的编号
 }
 
 func (p *Page) TableName() string {
 	return "pages"
 }
+
+// 跨进程部署时各个stage之间的队列，所有队列共用一张表，通过queue字段区分
+type QueueItem struct {
+	ID        uint64    `xorm:"bigint pk autoincr 'id'"`
+	Queue     string    `xorm:"varchar(64) notnull index(idx_queue_priority) 'queue'"`
+	Priority  float64   `xorm:"double notnull index(idx_queue_priority) 'priority'"`
+	Body      []byte    `xorm:"bytea 'body'"`
+	CreatedAt time.Time `xorm:"created notnull 'created_at'"`
+}
+
+func (q *QueueItem) TableName() string {
+	return "queue_items"
+}
+
+// 域名的熔断状态，仅保存非closed状态的域名，closed时删除记录
+type Domain struct {
+	Domain    string    `xorm:"varchar(256) pk 'domain'"`
+	State     uint8     `xorm:"int notnull 'state'"`
+	Failures  uint32    `xorm:"int notnull default 0 'failures'"` // 熔断前连续失败的次数
+	Probes    uint32    `xorm:"int notnull default 0 'probes'"`   // 熔断后连续失败的探测次数
+	LastError string    `xorm:"text 'last_error'"`
+	OpenUntil time.Time `xorm:"datetime 'open_until'"` // 在此之后允许一次探测
+	UpdatedAt time.Time `xorm:"updated notnull 'updated_at'"`
+}
+
+func (d *Domain) TableName() string {
+	return "domains"
+}
+
+// 爬取任务，每个job有单独的seed、深度、范围、预算以及存储位置，页面通过job_id区分
+type Job struct {
+	ID    string `xorm:"varchar(48) pk 'id'"`
+	State uint8  `xorm:"int notnull default 0 'state'"`
+	Depth uint8  `xorm:"int notnull default 0 'depth'"`
+	// json数组，允许抓取的域名（包含子域名），为空时不限制
+	Scope string `xorm:"text 'scope'"`
+	// 预算，0为不限制：抓取成功的页面数量，以及开始之后的运行时间（秒）
+	MaxPages    uint32 `xorm:"int notnull default 0 'max_pages'"`
+	MaxDuration uint32 `xorm:"int notnull default 0 'max_duration'"`
+	// 网页内容及正文的存放目录，正文目录为空时不存储
+	StorageLocation string `xorm:"text 'storage_location'"`
+	TextLocation    string `xorm:"text 'text_location'"`
+	Remark          string `xorm:"text 'remark'"` // 结束的原因
+
+	StartedAt  time.Time `xorm:"datetime 'started_at'"` // seed第一次被提交的时间，为空表示尚未开始
+	FinishedAt time.Time `xorm:"datetime 'finished_at'"`
+	CreatedAt  time.Time `xorm:"created notnull 'created_at'"`
+	UpdatedAt  time.Time `xorm:"updated notnull 'updated_at'"`
+}
+
+func (j *Job) TableName() string {
+	return "jobs"
+}
+
+// 定时爬取（schedule）的每一次运行为一代（generation），对应一个job
+type Generation struct {
+	ID         uint64 `xorm:"pk autoincr 'id'"`
+	Schedule   string `xorm:"varchar(32) notnull unique(uk_schedule_generation) 'schedule'"`
+	Generation uint32 `xorm:"int notnull unique(uk_schedule_generation) 'generation'"` // 从1开始递增
+	JobID      string `xorm:"varchar(48) notnull unique 'job_id'"`
+	State      uint8  `xorm:"int notnull default 0 'state'"`
+	// job结束后统计：抓取成功及失败的页面数量，以及与上一代相比新增、消失及内容变化的页面数量
+	Success    int64     `xorm:"bigint notnull default 0 'success'"`
+	Failed     int64     `xorm:"bigint notnull default 0 'failed'"`
+	Added      int64     `xorm:"bigint notnull default 0 'added'"`
+	Removed    int64     `xorm:"bigint notnull default 0 'removed'"`
+	Changed    int64     `xorm:"bigint notnull default 0 'changed'"`
+	Previous   string    `xorm:"varchar(48) 'previous'"` // 比较的上一代的job，为空表示没有可以比较的上一代
+	FinishedAt time.Time `xorm:"datetime 'finished_at'"`
+	CreatedAt  time.Time `xorm:"created notnull 'created_at'"`
+	UpdatedAt  time.Time `xorm:"updated notnull 'updated_at'"`
+}
+
+func (g *Generation) TableName() string {
+	return "generations"
+}
+
+// 页面内容的版本历史，同一url在所有job中共用，内容与最近的版本不同时才增加新的版本
+type PageVersion struct {
+	ID          uint64 `xorm:"bigint pk autoincr 'id'"`
+	URL         string `xorm:"varchar(2048) notnull unique(uk_url_version) 'url'"`
+	Version     uint32 `xorm:"int notnull unique(uk_url_version) 'version'"` // 从1开始递增
+	JobID       string `xorm:"varchar(48) notnull 'job_id'"`                 // 抓取到此版本的job
+	ContentHash string `xorm:"varchar(40) notnull 'content_hash'"`           // 用于判断内容是否变化，有正文时为正文的sha1，否则为网页内容的sha1
+	SimHash     int64  `xorm:"bigint notnull default 0 'sim_hash'"`
+	StoragePath string `xorm:"text 'storage_path'"`
+	TextPath    string `xorm:"text 'text_path'"`
+	// 第一次及最近一次抓取到此版本的时间
+	FetchedAt time.Time `xorm:"datetime notnull 'fetched_at'"`
+	SeenAt    time.Time `xorm:"datetime notnull 'seen_at'"`
+	CreatedAt time.Time `xorm:"created notnull 'created_at'"`
+}
+
+func (v *PageVersion) TableName() string {
+	return "page_versions"
+}
@@ -7,6 +7,8 @@ package dbstorage
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/go-xorm/xorm"
@@ -14,6 +16,8 @@ import (
 
 	"github.com/andrewyi/crawler/src/dbstorage/schema"
 	"github.com/andrewyi/crawler/src/enum"
+	"github.com/andrewyi/crawler/src/fingerprint"
+	"github.com/andrewyi/crawler/src/metrics"
 )
 
 var (
@@ -35,9 +39,19 @@ func NewSimpleDBStorage(dbURL string) (*SimpleDBStorage, error) {
 	s.engine = engine
 	s.engine.SetMaxIdleConns(3)
 	// d.engine.ShowSQL(true) // debug
-	return &SimpleDBStorage{}, nil
+	return s, nil
 }
 
+// 根据schema创建或补齐数据库表结构：增加缺少的表、字段和索引，不会删除字段
+// NOTE: 数据库中存在而schema中没有定义的索引（包括手工创建的索引）会被删除，类型不一致的索引会被重建
+func (s *SimpleDBStorage) Sync() error {
+	return s.engine.Sync2(new(schema.Page), new(schema.QueueItem), new(schema.Domain), new(schema.Job), new(schema.Generation), new(schema.PageVersion))
+}
+
+// 等待自动重试的暂时性失败页面：controller写入的fail_transient总是带有next_retry_at
+// 区分失败原因之前写入的fail状态（值为2）没有next_retry_at，不会被自动重试，也不计为仍会重试，只能通过retry命令重新下载
+const awaitingRetryCond = "next_retry_at is not null"
+
 func (s *SimpleDBStorage) Close() error {
 	return s.engine.Close()
 }
@@ -45,37 +59,65 @@ func (s *SimpleDBStorage) Close() error {
 // Transaction 数据库事务
 type Transaction struct {
 	sess *xorm.Session
+
+	// 用于统计事务耗时，finished之后的rollback（通常为defer调用）不再重复统计
+	start    time.Time
+	finished bool
 }
 
 // NewTransaction open a transaction with engine
 // all dao functions should use transaction to crud
 func (s *SimpleDBStorage) NewTransaction() (*Transaction, error) {
 	t := &Transaction{
-		sess: s.engine.NewSession(),
+		sess:  s.engine.NewSession(),
+		start: time.Now(),
 	}
 	err := t.sess.Begin()
+	if err != nil {
+		metrics.DBTransactionErrors.WithLabelValues("begin").Inc()
+	}
 	return t, err
 }
 
 func (t *Transaction) Commit() error {
-	return t.sess.Commit()
+	err := t.sess.Commit()
+	if err != nil {
+		metrics.DBTransactionErrors.WithLabelValues("commit").Inc()
+		t.observe("error")
+	} else {
+		t.observe("commit")
+	}
+	return err
 }
 
 func (t *Transaction) Rollback() error {
-	return t.sess.Rollback()
+	err := t.sess.Rollback()
+	if err != nil {
+		metrics.DBTransactionErrors.WithLabelValues("rollback").Inc()
+	}
+	t.observe("rollback")
+	return err
 }
 
 func (t *Transaction) Close() {
-	_ = t.sess.Rollback()
+	_ = t.Rollback()
 	t.sess.Close()
 }
 
-func (t *Transaction) GetPageWithLock(url string) (*schema.Page, error) {
+func (t *Transaction) observe(result string) {
+	if t.finished {
+		return
+	}
+	t.finished = true
+	metrics.DBTransactionDuration.WithLabelValues(result).Observe(time.Since(t.start).Seconds())
+}
+
+func (t *Transaction) GetPageWithLock(jobID string, url string) (*schema.Page, error) {
 
 	pages := make([]*schema.Page, 0)
 	if err := t.sess.SQL(
-		"select * from pages where url = ? for update skip locked",
-		url).Find(&pages); err != nil {
+		"select * from pages where job_id = ? and url = ? for update skip locked",
+		jobID, url).Find(&pages); err != nil {
 
 		return nil, err
 	}
@@ -87,6 +129,218 @@ func (t *Transaction) GetPageWithLock(url string) (*schema.Page, error) {
 	return pages[0], nil
 }
 
+// 不加锁，仅用于查询展示
+func (t *Transaction) GetPage(jobID string, url string) (*schema.Page, error) {
+	var page schema.Page
+	has, err := t.sess.Where("job_id = ? and url = ?", jobID, url).Get(&page)
+	if err != nil {
+		return nil, err
+	}
+	if !has {
+		return nil, ErrDataNotExist
+	}
+	return &page, nil
+}
+
+func (t *Transaction) GetPagesByState(state uint8, offset int, limit int) ([]*schema.Page, error) {
+	var pages []*schema.Page
+	err := t.sess.Where("state = ?", state).OrderBy("updated_at desc").Limit(limit, offset).Find(&pages)
+	return pages, err
+}
+
+// 所有失败状态的页面，最近更新的在前
+func (t *Transaction) GetFailedPages(offset int, limit int) ([]*schema.Page, error) {
+	var pages []*schema.Page
+	err := t.sess.In("state", enum.PageFailStates).OrderBy("updated_at desc").Limit(limit, offset).Find(&pages)
+	return pages, err
+}
+
+var failStatePlaceholders = placeholders(len(enum.PageFailStates))
+
+// n个以逗号分隔的sql参数占位符
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// enum.PageFailStates作为sql参数，args追加在其后
+func failStateArgs(args ...interface{}) []interface{} {
+	var all = make([]interface{}, 0, len(enum.PageFailStates)+len(args))
+	for _, s := range enum.PageFailStates {
+		all = append(all, s)
+	}
+	return append(all, args...)
+}
+
+func (t *Transaction) GetMaxPageID() (uint64, error) {
+	var ids []uint64
+	if err := t.sess.SQL("select coalesce(max(id), 0) from pages").Find(&ids); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	return ids[0], nil
+}
+
+// 遍历pages时的过滤条件，零值字段不过滤
+type PageFilter struct {
+	State         int    // 小于0时不过滤
+	Domain        string // 为空时不过滤
+	JobID         string // 为空时不过滤
+	MaxID         uint64
+	FetchedAfter  time.Time
+	FetchedBefore time.Time
+	// 大于0时只包含retry_count小于该值并且等待自动重试的记录
+	RetryCountBelow uint32
+	// 为true时只包含被熔断搁置的记录
+	Parked bool
+}
+
+func (f PageFilter) apply(sess *xorm.Session) *xorm.Session {
+	if f.State >= 0 {
+		sess = sess.And("state = ?", f.State)
+	}
+	if f.Domain != "" {
+		sess = sess.And("domain = ?", f.Domain)
+	}
+	if f.JobID != "" {
+		sess = sess.And("job_id = ?", f.JobID)
+	}
+	if f.MaxID > 0 {
+		sess = sess.And("id <= ?", f.MaxID)
+	}
+	if !f.FetchedAfter.IsZero() {
+		sess = sess.And("fetched_at >= ?", f.FetchedAfter)
+	}
+	if !f.FetchedBefore.IsZero() {
+		sess = sess.And("fetched_at < ?", f.FetchedBefore)
+	}
+	if f.RetryCountBelow > 0 {
+		sess = sess.And("retry_count < ?", f.RetryCountBelow).And(awaitingRetryCond)
+	}
+	if f.Parked {
+		sess = sess.And("parked = ?", true)
+	}
+	return sess
+}
+
+// 返回id大于afterID的最多limit条记录，按id升序排列
+func (t *Transaction) GetPagesAfterID(afterID uint64, filter PageFilter, limit int) ([]*schema.Page, error) {
+	var pages []*schema.Page
+	sess := filter.apply(t.sess.Where("id > ?", afterID))
+	err := sess.OrderBy("id asc").Limit(limit).Find(&pages)
+	return pages, err
+}
+
+// 返回(updated_at, id)大于给定值且updated_at不晚于until的最多limit条记录，按(updated_at, id)升序排列
+// 用于增量遍历，记录被修改后会重新出现在遍历结果中
+func (t *Transaction) GetPagesUpdatedAfter(updatedAt time.Time, id uint64, until time.Time, filter PageFilter, limit int) ([]*schema.Page, error) {
+	var pages []*schema.Page
+	sess := t.sess.Where("(updated_at > ? or (updated_at = ? and id > ?))", updatedAt, updatedAt, id).
+		And("updated_at <= ?", until)
+	err := filter.apply(sess).OrderBy("updated_at asc, id asc").Limit(limit).Find(&pages)
+	return pages, err
+}
+
+// 分批遍历满足条件的pages，每批使用单独的事务，fn返回错误时停止遍历
+func (s *SimpleDBStorage) ScanPages(filter PageFilter, batchSize int, fn func(*schema.Page) error) error {
+	var afterID uint64
+	for {
+		t, err := s.NewTransaction()
+		if err != nil {
+			return err
+		}
+		pages, err := t.GetPagesAfterID(afterID, filter, batchSize)
+		t.Close()
+		if err != nil {
+			return err
+		}
+		for _, p := range pages {
+			if err = fn(p); err != nil {
+				return err
+			}
+			afterID = p.ID
+		}
+		if len(pages) < batchSize {
+			return nil
+		}
+	}
+}
+
+// 将满足条件的页面重置为pending并清空重试次数，domain、jobID为空时不过滤，返回修改的记录数
+func (t *Transaction) ResetPages(state uint8, domain string, jobID string) (int64, error) {
+	sess := t.sess.Table(new(schema.Page)).Where("state = ?", state)
+	if domain != "" {
+		sess = sess.And("domain = ?", domain)
+	}
+	if jobID != "" {
+		sess = sess.And("job_id = ?", jobID)
+	}
+	return sess.Update(map[string]interface{}{
+		"state":       enum.PageStatePending,
+		"remark":      "",
+		"retry_count": 0,
+		"lease_owner": "",
+		"parked":      false,
+		"updated_at":  time.Now(),
+	})
+}
+
+// 近似重复检测时返回的候选数量上限
+const maxDuplicateCandidates = 100
+
+// 查找与hash汉明距离不超过maxDistance的页面，返回其中最早插入的一个
+// 仅比较同一个job中抓取成功且本身不是重复页面的记录，url为当前页面，不参与比较
+func (t *Transaction) FindNearDuplicate(jobID string, url string, hash uint64, maxDistance int) (*schema.Page, error) {
+	bands := fingerprint.SplitBands(hash)
+	var pages []*schema.Page
+	err := t.sess.Cols("id", "url", "sim_hash").
+		Where("(sim_band0 = ? or sim_band1 = ? or sim_band2 = ? or sim_band3 = ?)", bands[0], bands[1], bands[2], bands[3]).
+		And("sim_hash <> 0").
+		And("state = ?", enum.PageStateSuccess).
+		And("duplicate_of = ''").
+		And("job_id = ?", jobID).
+		And("url <> ?", url).
+		OrderBy("id asc").Limit(maxDuplicateCandidates).Find(&pages)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range pages {
+		if fingerprint.Near(uint64(p.SimHash), hash, maxDistance) {
+			return p, nil
+		}
+	}
+	return nil, ErrDataNotExist
+}
+
+type PageScore struct {
+	ID        uint64
+	InDegree  int32
+	OutDegree int32
+	PageRank  float64
+	Component int32
+}
+
+// 批量写入链接分析结果，不修改updated_at（避免所有页面被增量导出）
+func (t *Transaction) UpdatePageScores(scores []PageScore) error {
+	if len(scores) == 0 {
+		return nil
+	}
+	var values = make([]string, 0, len(scores))
+	var args = make([]interface{}, 0, len(scores)*5+1)
+	args = append(args, "")
+	for _, s := range scores {
+		values = append(values, "(?::bigint, ?::int, ?::int, ?::double precision, ?::int)")
+		args = append(args, s.ID, s.InDegree, s.OutDegree, s.PageRank, s.Component)
+	}
+	args[0] = `update pages as p set in_degree = v.in_degree, out_degree = v.out_degree,
+		page_rank = v.page_rank, component = v.component
+		from (values ` + strings.Join(values, ", ") + `) as v(id, in_degree, out_degree, page_rank, component)
+		where p.id = v.id`
+	_, err := t.sess.Exec(args...)
+	return err
+}
+
 func (t *Transaction) UpdatePage(page *schema.Page) (int64, error) {
 	return t.sess.Update(page)
 }
@@ -95,12 +349,502 @@ func (t *Transaction) InsertPage(page *schema.Page) (int64, error) {
 	return t.sess.Insert(page)
 }
 
-func (t *Transaction) GetPendingPageWithTimeoutAndLimit(latestUpdatedAt time.Time, maxNum uint32) ([]*schema.Page, error) {
+// 获取pending页面的租约：页面没有租约、租约已经过期或者本身持有租约时成功
+// 返回false表示页面已经处理完成，或者正在被其他进程处理
+func (t *Transaction) AcquireLease(jobID string, url string, owner string, now time.Time, until time.Time) (bool, error) {
+	res, err := t.sess.Exec(
+		`update pages set lease_owner = ?, leased_until = ?
+		where job_id = ? and url = ? and state = ? and (lease_owner = '' or lease_owner = ? or leased_until < ?)`,
+		owner, until, jobID, url, enum.PageStatePending, owner, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// 下游stage从共享队列中取出页面时接管租约，不检查原有的租约
+func (t *Transaction) ClaimLease(jobID string, url string, owner string, until time.Time) error {
+	_, err := t.sess.Exec(
+		"update pages set lease_owner = ?, leased_until = ? where job_id = ? and url = ? and state = ?",
+		owner, until, jobID, url, enum.PageStatePending)
+	return err
+}
+
+// 放弃单个页面的租约，页面不会被回收任务重新提交
+func (t *Transaction) ReleaseLease(jobID string, url string, owner string) error {
+	_, err := t.sess.Exec(
+		"update pages set lease_owner = '' where job_id = ? and url = ? and lease_owner = ? and state = ?",
+		jobID, url, owner, enum.PageStatePending)
+	return err
+}
+
+// 域名熔断时搁置页面并放弃租约，租约已经被其他进程接管时不做修改
+func (t *Transaction) ParkPage(jobID string, url string, owner string) error {
+	_, err := t.sess.Exec(
+		"update pages set parked = ?, lease_owner = '' where job_id = ? and url = ? and lease_owner = ? and state = ?",
+		true, jobID, url, owner, enum.PageStatePending)
+	return err
+}
+
+// 搁置的页面重新提交之前调用，之后再次被搁置时重新标记
+func (t *Transaction) UnparkPage(id uint64) error {
+	_, err := t.sess.Exec("update pages set parked = ? where id = ?", false, id)
+	return err
+}
+
+// 页面的唯一标识，URL为ShortifyURL之后的结果
+type PageKey struct {
+	JobID string
+	URL   string
+}
+
+// 续期owner持有的指定页面的租约，已经被其他进程接管或者不再是pending的页面不做修改，返回续期的数量
+func (t *Transaction) RenewLeases(owner string, until time.Time, pages []PageKey) (int64, error) {
+	if len(pages) == 0 {
+		return 0, nil
+	}
+	var args = make([]interface{}, 0, 2*len(pages)+4)
+	args = append(args, "", until, owner, enum.PageStatePending)
+	for _, p := range pages {
+		args = append(args, p.JobID, p.URL)
+	}
+	args[0] = `update pages set leased_until = ? where lease_owner = ? and state = ?
+		and (job_id, url) in (` + strings.TrimSuffix(strings.Repeat("(?, ?), ", len(pages)), ", ") + `)`
+	res, err := t.sess.Exec(args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// 使租约立即过期，下一次回收任务扫描时重新提交，租约已经被其他进程接管或者页面不再是pending时不做修改
+func (t *Transaction) ExpireLease(jobID string, url string, owner string, now time.Time) error {
+	_, err := t.sess.Exec(
+		"update pages set leased_until = ? where job_id = ? and url = ? and lease_owner = ? and state = ?",
+		now, jobID, url, owner, enum.PageStatePending)
+	return err
+}
+
+// 清除所有pending页面的租约及搁置标记，仅用于单进程部署启动时（此时的租约均来自上一次运行，所有pending页面都会被重新提交）
+func (t *Transaction) ClearAllLeases() (int64, error) {
+	res, err := t.sess.Exec(
+		"update pages set lease_owner = '', parked = ? where state = ? and (lease_owner <> '' or parked = ?)",
+		false, enum.PageStatePending, true)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// 租约已经过期的pending页面，已经被其他事务锁定的记录将被跳过
+func (t *Transaction) GetExpiredLeasesWithLock(now time.Time, limit int) ([]*schema.Page, error) {
 	var pages []*schema.Page
-	err := t.sess.Where("state = ?", enum.PageStatePending).Where("updated_at > ?", latestUpdatedAt).Limit(int(maxNum)).Find(&pages)
+	err := t.sess.SQL(
+		`select * from pages where state = ? and lease_owner <> '' and leased_until < ?
+		order by leased_until asc limit ? for update skip locked`,
+		enum.PageStatePending, now, limit).Find(&pages)
 	return pages, err
 }
 
+// 清除租约，回收任务重新提交之前调用
+func (t *Transaction) ClearLeases(ids []uint64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	var args = make([]interface{}, 0, len(ids)+2)
+	args = append(args, "", time.Now())
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	args[0] = `update pages set lease_owner = '', updated_at = ?
+		where id in (` + placeholders(len(ids)) + `)`
+	_, err := t.sess.Exec(args...)
+	return err
+}
+
+// 仅统计运行中及暂停的job，取消或者已经结束的job中剩余的pending页面不影响任务是否结束
+const activeJobCond = "job_id in (select id from jobs where state in (?, ?))"
+
 func (t *Transaction) GetPendingPageCount() (int64, error) {
-	return t.sess.Where("state = ?", enum.PageStatePending).Count()
+	return t.sess.Where("state = ?", enum.PageStatePending).
+		And(activeJobCond, enum.JobStateRunning, enum.JobStatePaused).Count(new(schema.Page))
+}
+
+// 到达重试时间且重试次数未超过maxRetries的暂时性失败页面，已经被其他事务锁定的记录将被跳过
+func (t *Transaction) GetRetryablePagesWithLock(now time.Time, maxRetries uint32, limit int) ([]*schema.Page, error) {
+	var pages []*schema.Page
+	err := t.sess.SQL(
+		`select * from pages where state = ? and retry_count < ? and next_retry_at <= ?
+		order by next_retry_at asc limit ? for update skip locked`,
+		enum.PageStateFailTransient, maxRetries, now, limit).Find(&pages)
+	return pages, err
+}
+
+// 重新置为pending并增加重试次数
+func (t *Transaction) RequeuePages(ids []uint64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	var args = make([]interface{}, 0, len(ids)+3)
+	args = append(args, "", enum.PageStatePending, time.Now())
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	args[0] = `update pages set state = ?, retry_count = retry_count + 1, lease_owner = '', updated_at = ?
+		where id in (` + placeholders(len(ids)) + `)`
+	_, err := t.sess.Exec(args...)
+	return err
+}
+
+// 仍然会被重试的暂时性失败页面数量，用于判断任务是否结束
+func (t *Transaction) GetRetryablePageCount(maxRetries uint32) (int64, error) {
+	return t.sess.Where("state = ?", enum.PageStateFailTransient).
+		And("retry_count < ?", maxRetries).
+		And(awaitingRetryCond).
+		And(activeJobCond, enum.JobStateRunning, enum.JobStatePaused).Count(new(schema.Page))
+}
+
+func (t *Transaction) InsertQueueItem(item *schema.QueueItem) (int64, error) {
+	return t.sess.Insert(item)
+}
+
+// 取出并删除优先级最高的一条消息，已经被其他事务锁定的消息将被跳过
+func (t *Transaction) PopQueueItem(queue string) (*schema.QueueItem, error) {
+	var item schema.QueueItem
+	has, err := t.sess.SQL(
+		`delete from queue_items where id = (
+			select id from queue_items where queue = ? order by priority desc, id asc limit 1 for update skip locked
+		) returning *`, queue).Get(&item)
+	if err != nil {
+		return nil, err
+	}
+	if !has {
+		return nil, ErrDataNotExist
+	}
+	return &item, nil
+}
+
+func (t *Transaction) GetQueueItemCount(queue string) (int64, error) {
+	return t.sess.Where("queue = ?", queue).Count(new(schema.QueueItem))
+}
+
+type stateCount struct {
+	State uint8 `xorm:"'state'"`
+	Count int64 `xorm:"'count'"`
+}
+
+func (t *Transaction) GetPageCountByState() (map[uint8]int64, error) {
+	var rows []stateCount
+	if err := t.sess.SQL("select state, count(*) as count from pages group by state").Find(&rows); err != nil {
+		return nil, err
+	}
+	var counts = make(map[uint8]int64)
+	for _, r := range rows {
+		counts[r.State] = r.Count
+	}
+	return counts, nil
+}
+
+type depthCount struct {
+	Depth uint8 `xorm:"'depth'"`
+	Count int64 `xorm:"'count'"`
+}
+
+func (t *Transaction) GetPageCountByDepth() (map[uint8]int64, error) {
+	var rows []depthCount
+	if err := t.sess.SQL("select depth, count(*) as count from pages group by depth").Find(&rows); err != nil {
+		return nil, err
+	}
+	var counts = make(map[uint8]int64)
+	for _, r := range rows {
+		counts[r.Depth] = r.Count
+	}
+	return counts, nil
+}
+
+func (t *Transaction) GetFetchedPageCountSince(since time.Time) (int64, error) {
+	return t.sess.Where("fetched_at > ?", since).Count(new(schema.Page))
+}
+
+type DomainCount struct {
+	Domain string `xorm:"'domain'" json:"domain"`
+	Count  int64  `xorm:"'count'" json:"count"`
+}
+
+func (t *Transaction) GetTopDomains(limit int) ([]DomainCount, error) {
+	var rows []DomainCount
+	err := t.sess.SQL(
+		"select domain, count(*) as count from pages group by domain order by count desc limit ?", limit).Find(&rows)
+	return rows, err
+}
+
+type RemarkCount struct {
+	Remark string    `xorm:"'remark'" json:"remark"`
+	Count  int64     `xorm:"'count'" json:"count"`
+	Latest time.Time `xorm:"'latest'" json:"latest"`
+}
+
+// 按remark聚合的失败页面，最近出现的错误在前
+func (t *Transaction) GetRecentErrors(limit int) ([]RemarkCount, error) {
+	var rows []RemarkCount
+	err := t.sess.SQL(
+		`select remark, count(*) as count, max(updated_at) as latest from pages
+		where state in (`+failStatePlaceholders+`) group by remark order by latest desc limit ?`, failStateArgs(limit)...).Find(&rows)
+	return rows, err
+}
+
+// keyword为url中包含的子串，state小于0时不过滤状态
+func (t *Transaction) SearchPages(keyword string, state int, offset int, limit int) ([]*schema.Page, error) {
+	var pages []*schema.Page
+	sess := t.sess.Where("url like ?", "%"+escapeLike(keyword)+"%")
+	if state >= 0 {
+		sess = sess.And("state = ?", state)
+	}
+	err := sess.OrderBy("id desc").Limit(limit, offset).Find(&pages)
+	return pages, err
+}
+
+func (t *Transaction) GetDomains() ([]*schema.Domain, error) {
+	var domains []*schema.Domain
+	err := t.sess.OrderBy("updated_at desc").Find(&domains)
+	return domains, err
+}
+
+// 不存在时插入，存在时覆盖
+func (t *Transaction) SaveDomain(d *schema.Domain) error {
+	_, err := t.sess.Exec(
+		`insert into domains (domain, state, failures, probes, last_error, open_until, updated_at)
+		values (?, ?, ?, ?, ?, ?, ?)
+		on conflict (domain) do update set state = excluded.state, failures = excluded.failures,
+		probes = excluded.probes, last_error = excluded.last_error, open_until = excluded.open_until,
+		updated_at = excluded.updated_at`,
+		d.Domain, d.State, d.Failures, d.Probes, d.LastError, d.OpenUntil, time.Now())
+	return err
+}
+
+func (t *Transaction) DeleteDomain(domain string) (int64, error) {
+	return t.sess.Where("domain = ?", domain).Delete(new(schema.Domain))
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+func (t *Transaction) GetJobs() ([]*schema.Job, error) {
+	var jobs []*schema.Job
+	err := t.sess.OrderBy("created_at asc").Find(&jobs)
+	return jobs, err
+}
+
+func (t *Transaction) GetJob(id string) (*schema.Job, error) {
+	var job schema.Job
+	has, err := t.sess.ID(id).Get(&job)
+	if err != nil {
+		return nil, err
+	}
+	if !has {
+		return nil, ErrDataNotExist
+	}
+	return &job, nil
+}
+
+func (t *Transaction) InsertJob(job *schema.Job) (int64, error) {
+	return t.sess.Insert(job)
+}
+
+// 只更新cols中的字段，零值（例如running状态）同样会被写入
+func (t *Transaction) UpdateJob(job *schema.Job, cols ...string) (int64, error) {
+	return t.sess.ID(job.ID).Cols(cols...).Update(job)
+}
+
+func (t *Transaction) GetJobPageCount(jobID string, state uint8) (int64, error) {
+	return t.sess.Where("job_id = ? and state = ?", jobID, state).Count(new(schema.Page))
+}
+
+// job中pending以及仍会被重试的页面数量，为0时job结束
+func (t *Transaction) GetJobOutstandingCount(jobID string, maxRetries uint32) (int64, error) {
+	return t.sess.Where("job_id = ?", jobID).
+		And("(state = ? or (state = ? and retry_count < ? and "+awaitingRetryCond+"))",
+			enum.PageStatePending, enum.PageStateFailTransient, maxRetries).
+		Count(new(schema.Page))
+}
+
+type jobStateCount struct {
+	JobID string `xorm:"'job_id'"`
+	State uint8  `xorm:"'state'"`
+	Count int64  `xorm:"'count'"`
+}
+
+// 按job及状态统计页面数量
+func (t *Transaction) GetJobPageCountByState() (map[string]map[uint8]int64, error) {
+	var rows []jobStateCount
+	if err := t.sess.SQL("select job_id, state, count(*) as count from pages group by job_id, state").Find(&rows); err != nil {
+		return nil, err
+	}
+	var counts = make(map[string]map[uint8]int64)
+	for _, r := range rows {
+		if counts[r.JobID] == nil {
+			counts[r.JobID] = make(map[uint8]int64)
+		}
+		counts[r.JobID][r.State] = r.Count
+	}
+	return counts, nil
+}
+
+func (t *Transaction) GetJobFailedCount(jobID string) (int64, error) {
+	return t.sess.Where("job_id = ?", jobID).
+		And("state in ("+failStatePlaceholders+")", failStateArgs()...).
+		Count(new(schema.Page))
+}
+
+// schedule最新的一代，不存在时返回ErrDataNotExist
+func (t *Transaction) GetLatestGeneration(schedule string) (*schema.Generation, error) {
+	var g schema.Generation
+	has, err := t.sess.Where("schedule = ?", schedule).Desc("generation").Get(&g)
+	if err != nil {
+		return nil, err
+	}
+	if !has {
+		return nil, ErrDataNotExist
+	}
+	return &g, nil
+}
+
+func (t *Transaction) GetGeneration(schedule string, generation uint32) (*schema.Generation, error) {
+	var g schema.Generation
+	has, err := t.sess.Where("schedule = ? and generation = ?", schedule, generation).Get(&g)
+	if err != nil {
+		return nil, err
+	}
+	if !has {
+		return nil, ErrDataNotExist
+	}
+	return &g, nil
+}
+
+// 在generation之前最近一代已经结束的，用于比较
+func (t *Transaction) GetPreviousGeneration(schedule string, generation uint32) (*schema.Generation, error) {
+	var g schema.Generation
+	has, err := t.sess.Where("schedule = ? and generation < ? and state = ?",
+		schedule, generation, enum.GenerationStateFinished).Desc("generation").Get(&g)
+	if err != nil {
+		return nil, err
+	}
+	if !has {
+		return nil, ErrDataNotExist
+	}
+	return &g, nil
+}
+
+// schedule为空时不过滤，最近创建的在前
+func (t *Transaction) GetGenerations(schedule string, limit int) ([]*schema.Generation, error) {
+	var gens []*schema.Generation
+	sess := t.sess.Desc("id").Limit(limit)
+	if schedule != "" {
+		sess = sess.Where("schedule = ?", schedule)
+	}
+	err := sess.Find(&gens)
+	return gens, err
+}
+
+func (t *Transaction) GetRunningGenerations() ([]*schema.Generation, error) {
+	var gens []*schema.Generation
+	err := t.sess.Where("state = ?", enum.GenerationStateRunning).Asc("id").Find(&gens)
+	return gens, err
+}
+
+func (t *Transaction) InsertGeneration(g *schema.Generation) (int64, error) {
+	return t.sess.Insert(g)
+}
+
+func (t *Transaction) UpdateGeneration(g *schema.Generation, cols ...string) (int64, error) {
+	return t.sess.ID(g.ID).Cols(cols...).Update(g)
+}
+
+// 两个job之间的页面变化，kind见enum.DiffKinds，只比较抓取成功的页面
+func diffQuery(prevJob string, curJob string, kind string) (string, []interface{}, error) {
+	switch kind {
+	case enum.DiffAdded, enum.DiffRemoved:
+		a, b := curJob, prevJob
+		if kind == enum.DiffRemoved {
+			a, b = prevJob, curJob
+		}
+		return `from pages c where c.job_id = ? and c.state = ?
+			and not exists (select 1 from pages p where p.job_id = ? and p.url = c.url and p.state = ?)`,
+			[]interface{}{a, enum.PageStateSuccess, b, enum.PageStateSuccess}, nil
+	case enum.DiffChanged:
+		return `from pages c join pages p on p.url = c.url and p.job_id = ?
+			where c.job_id = ? and c.state = ? and p.state = ? and c.sim_hash <> p.sim_hash`,
+			[]interface{}{prevJob, curJob, enum.PageStateSuccess, enum.PageStateSuccess}, nil
+	default:
+		return "", nil, fmt.Errorf("unknown diff kind: %s", kind)
+	}
+}
+
+func (t *Transaction) CountJobDiff(prevJob string, curJob string, kind string) (int64, error) {
+	from, args, err := diffQuery(prevJob, curJob, kind)
+	if err != nil {
+		return 0, err
+	}
+	var counts []int64
+	if err = t.sess.SQL("select count(*) "+from, args...).Find(&counts); err != nil {
+		return 0, err
+	}
+	if len(counts) == 0 {
+		return 0, nil
+	}
+	return counts[0], nil
+}
+
+// 按url排序，最多返回limit个url
+func (t *Transaction) GetJobDiff(prevJob string, curJob string, kind string, limit int) ([]string, error) {
+	from, args, err := diffQuery(prevJob, curJob, kind)
+	if err != nil {
+		return nil, err
+	}
+	var urls []string
+	err = t.sess.SQL("select c.url "+from+" order by c.url limit ?", append(args, limit)...).Find(&urls)
+	return urls, err
+}
+
+// url最新的版本，并锁定该记录，避免不同job同时抓取时基于同一个版本写入
+func (t *Transaction) GetLatestPageVersionWithLock(url string) (*schema.PageVersion, error) {
+	versions := make([]*schema.PageVersion, 0)
+	if err := t.sess.SQL(
+		"select * from page_versions where url = ? order by version desc limit 1 for update",
+		url).Find(&versions); err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, ErrDataNotExist
+	}
+	return versions[0], nil
+}
+
+func (t *Transaction) GetPageVersion(url string, version uint32) (*schema.PageVersion, error) {
+	var v schema.PageVersion
+	has, err := t.sess.Where("url = ? and version = ?", url, version).Get(&v)
+	if err != nil {
+		return nil, err
+	}
+	if !has {
+		return nil, ErrDataNotExist
+	}
+	return &v, nil
+}
+
+// 按版本号顺序
+func (t *Transaction) GetPageVersions(url string) ([]*schema.PageVersion, error) {
+	var versions []*schema.PageVersion
+	err := t.sess.Where("url = ?", url).Asc("version").Find(&versions)
+	return versions, err
+}
+
+func (t *Transaction) InsertPageVersion(v *schema.PageVersion) (int64, error) {
+	return t.sess.Insert(v)
+}
+
+func (t *Transaction) UpdatePageVersion(v *schema.PageVersion, cols ...string) (int64, error) {
+	return t.sess.ID(v.ID).Cols(cols...).Update(v)
 }
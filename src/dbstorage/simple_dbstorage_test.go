@@ -0,0 +1,34 @@
+package dbstorage
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/andrewyi/crawler/src/enum"
+)
+
+// 新增及消失的比较方向相反，c为被统计的一侧
+func TestDiffQueryArgs(t *testing.T) {
+	const prev, cur = "news-1", "news-2"
+	success := enum.PageStateSuccess
+	tests := []struct {
+		kind string
+		args []interface{}
+	}{
+		{enum.DiffAdded, []interface{}{cur, success, prev, success}},
+		{enum.DiffRemoved, []interface{}{prev, success, cur, success}},
+		{enum.DiffChanged, []interface{}{prev, cur, success, success}},
+	}
+	for _, tt := range tests {
+		_, args, err := diffQuery(prev, cur, tt.kind)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.kind, err)
+		}
+		if !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("%s: args = %v, want %v", tt.kind, args, tt.args)
+		}
+	}
+	if _, _, err := diffQuery(prev, cur, "moved"); err == nil {
+		t.Fatal("unknown diff kind accepted")
+	}
+}
@@ -0,0 +1,267 @@
+// 按域名配置的认证：HTTP Basic/Digest、bearer token，以及模拟表单登录
+// 所有downloader worker共用同一个cookie jar，表单登录得到的session cookie对之后的所有请求生效
+// 响应内容匹配logged_out_pattern时认为session已经失效，下一次请求之前重新登录
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+
+	"github.com/andrewyi/crawler/src/entity"
+	"github.com/andrewyi/crawler/src/enum"
+)
+
+// 登录失败之后，在此时间之内不再尝试，避免每个请求都触发登录
+const loginRetryInterval = 30 * time.Second
+
+var (
+	// 登录失败，按暂时性失败处理，重试时重新登录
+	ErrLoginFailed = errors.New("login failed")
+	// 服务端要求的认证方式不受支持（例如digest的算法或qop），重试不会改变结果
+	ErrAuthUnsupported = errors.New("unsupported authentication")
+)
+
+// AuthConfig 与配置文件中的downloader.auth一致
+type AuthConfig struct {
+	Domain   string `mapstructure:"domain"`
+	Type     string `mapstructure:"type"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	Token    string `mapstructure:"token"`
+	Form     struct {
+		LoginURL     string            `mapstructure:"login_url"`
+		FormSelector string            `mapstructure:"form_selector"`
+		Fields       map[string]string `mapstructure:"fields"`
+	} `mapstructure:"form"`
+	LoggedOutPattern string `mapstructure:"logged_out_pattern"`
+}
+
+type authRule struct {
+	cfg       AuthConfig
+	rule      *Rule
+	loggedOut *regexp.Regexp // 为nil时不检测
+
+	mu sync.Mutex
+	// 当前session的编号，0表示尚未登录或者已经失效（仅表单登录使用）
+	session   uint64
+	logins    uint64 // 登录成功的次数，作为session编号
+	lastError error
+	lastLogin time.Time
+	// 最近一次digest质询，之后的请求直接附带认证信息，nonce失效时服务端重新质询
+	digest *digestChallenge
+}
+
+// Authenticator 由所有downloader worker共享
+type Authenticator struct {
+	rules []*authRule
+	jar   http.CookieJar
+	// 登录使用的client，与下载共用cookie jar
+	client *http.Client
+}
+
+func NewAuthenticator(configs []AuthConfig, timeout time.Duration) (*Authenticator, error) {
+	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	if err != nil {
+		return nil, err
+	}
+	var a = &Authenticator{
+		jar:    jar,
+		client: &http.Client{Timeout: timeout, Jar: jar},
+	}
+	for _, cfg := range configs {
+		if cfg.Domain == "" {
+			return nil, fmt.Errorf("domain of auth is required")
+		}
+		r := &authRule{cfg: cfg}
+		if r.rule, err = NewRule(cfg.Domain, ""); err != nil {
+			return nil, err
+		}
+		switch cfg.Type {
+		case enum.AuthTypeBasic, enum.AuthTypeDigest:
+			if cfg.Username == "" {
+				return nil, fmt.Errorf("username of %s auth for %s is required", cfg.Type, cfg.Domain)
+			}
+		case enum.AuthTypeBearer:
+			if cfg.Token == "" {
+				return nil, fmt.Errorf("token of bearer auth for %s is required", cfg.Domain)
+			}
+		case enum.AuthTypeForm:
+			if cfg.Form.LoginURL == "" {
+				return nil, fmt.Errorf("form.login_url of form auth for %s is required", cfg.Domain)
+			}
+		default:
+			return nil, fmt.Errorf("unknown auth type: %s", cfg.Type)
+		}
+		if cfg.LoggedOutPattern != "" {
+			if r.loggedOut, err = regexp.Compile(cfg.LoggedOutPattern); err != nil {
+				return nil, fmt.Errorf("invalid logged_out_pattern for %s, err: %w", cfg.Domain, err)
+			}
+		}
+		a.rules = append(a.rules, r)
+	}
+	return a, nil
+}
+
+func (a *Authenticator) Jar() http.CookieJar {
+	return a.jar
+}
+
+// url配置了认证时返回true
+func (a *Authenticator) Match(url string) bool {
+	return a.match(url) != nil
+}
+
+// rule限定的域名与某个认证配置的域名重叠（相同或者互为子域名）时返回该认证配置的域名
+// rule没有限定域名时无法判断，返回false
+func (a *Authenticator) Overlap(rule *Rule) (string, bool) {
+	if rule.domain == "" {
+		return "", false
+	}
+	for _, r := range a.rules {
+		d := r.rule.domain
+		if d == rule.domain || strings.HasSuffix(d, "."+rule.domain) || strings.HasSuffix(rule.domain, "."+d) {
+			return r.cfg.Domain, true
+		}
+	}
+	return "", false
+}
+
+// 请求失败时的下载结果，认证失败单独标记，不计入域名熔断
+func requestFailed(url string, err error) entity.PageInfo {
+	var page = entity.PageInfo{
+		URL:    url,
+		State:  enum.PageStateFailTransient,
+		Remark: err.Error(),
+	}
+	switch {
+	case errors.Is(err, ErrAuthUnsupported):
+		page.State = enum.PageStateFailPermanent
+		page.AuthFailed = true
+	case errors.Is(err, ErrLoginFailed):
+		page.AuthFailed = true
+	}
+	return page
+}
+
+// 按照配置顺序匹配，未配置认证的url返回nil
+func (a *Authenticator) match(url string) *authRule {
+	for _, r := range a.rules {
+		if r.rule.Match(url) {
+			return r
+		}
+	}
+	return nil
+}
+
+// Do 附带认证信息发送请求：表单登录时确保已经登录，digest认证时应答服务端的质询
+// 返回本次请求使用的session编号，用于之后的LoggedOut判断
+func (a *Authenticator) Do(client *http.Client, req *http.Request) (*http.Response, uint64, error) {
+	r := a.match(req.URL.String())
+	if r == nil {
+		resp, err := client.Do(req)
+		return resp, 0, err
+	}
+
+	switch r.cfg.Type {
+	case enum.AuthTypeBasic:
+		req.SetBasicAuth(r.cfg.Username, r.cfg.Password)
+	case enum.AuthTypeBearer:
+		req.Header.Set("Authorization", "Bearer "+r.cfg.Token)
+	case enum.AuthTypeForm:
+		session, err := a.ensureLogin(r)
+		if err != nil {
+			return nil, 0, err
+		}
+		resp, err := client.Do(req)
+		return resp, session, err
+	case enum.AuthTypeDigest:
+		return a.doDigest(client, req, r)
+	}
+	resp, err := client.Do(req)
+	return resp, 0, err
+}
+
+// 响应内容匹配logged_out_pattern时返回true，并使session失效，下一次请求之前重新登录
+// session为Do返回的编号，多个worker同时发现时只会重新登录一次
+func (a *Authenticator) LoggedOut(url string, session uint64, content string) bool {
+	r := a.match(url)
+	if r == nil || r.loggedOut == nil || !r.loggedOut.MatchString(content) {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cfg.Type == enum.AuthTypeForm && r.session == session {
+		r.session = 0
+	}
+	if r.cfg.Type == enum.AuthTypeDigest {
+		r.digest = nil
+	}
+	return true
+}
+
+// 尚未登录时登录，登录过程中其他worker等待，返回当前的session编号
+func (a *Authenticator) ensureLogin(r *authRule) (uint64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.session > 0 {
+		return r.session, nil
+	}
+	if r.lastError != nil && time.Since(r.lastLogin) < loginRetryInterval {
+		return 0, r.lastError
+	}
+	r.lastLogin = time.Now()
+	if err := a.login(r); err != nil {
+		r.lastError = fmt.Errorf("%w: %s, err: %v", ErrLoginFailed, r.cfg.Domain, err)
+		return 0, r.lastError
+	}
+	r.lastError = nil
+	r.logins++
+	r.session = r.logins
+	return r.session, nil
+}
+
+func (a *Authenticator) doDigest(client *http.Client, req *http.Request, r *authRule) (*http.Response, uint64, error) {
+	r.mu.Lock()
+	if r.digest != nil {
+		req.Header.Set("Authorization", r.digest.authorize(req, r.cfg.Username, r.cfg.Password))
+	}
+	r.mu.Unlock()
+
+	resp, err := client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, 0, err
+	}
+	challenge, err := selectDigestChallenge(resp.Header[http.CanonicalHeaderKey("WWW-Authenticate")])
+	if challenge == nil {
+		if err != nil {
+			resp.Body.Close()
+			return nil, 0, fmt.Errorf("%w: %s, err: %v", ErrAuthUnsupported, r.cfg.Domain, err)
+		}
+		return resp, 0, nil
+	}
+	resp.Body.Close()
+
+	r.mu.Lock()
+	r.digest = challenge
+	auth := challenge.authorize(req, r.cfg.Username, r.cfg.Password)
+	r.mu.Unlock()
+
+	// GET请求没有body，可以直接重新发送
+	retry := req.Clone(req.Context())
+	retry.Header.Set("Authorization", auth)
+	resp, err = client.Do(retry)
+	return resp, 0, err
+}
+
+// 去掉首尾空白及引号
+func unquote(s string) string {
+	return strings.Trim(strings.TrimSpace(s), `"`)
+}
@@ -0,0 +1,167 @@
+package downloader
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// HTTP Digest认证（RFC 7616），支持MD5、SHA-256及其-sess变体，qop仅支持auth
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string // 为空时使用RFC 2069的兼容方式
+	nc        uint32 // 同一nonce的请求计数
+	hash      func(string) string
+	sess      bool
+}
+
+// 生成客户端随机数，测试时替换为固定值
+var newCnonce = func() string {
+	var b = make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// 服务端可能同时提供多个质询（例如SHA-256及MD5），按顺序使用第一个支持的
+// 没有Digest质询时返回nil，只有不支持的质询时返回错误
+func selectDigestChallenge(headers []string) (*digestChallenge, error) {
+	var unsupported error
+	for _, h := range headers {
+		c, err := parseDigestChallenge(h)
+		if err != nil {
+			unsupported = err
+			continue
+		}
+		if c != nil {
+			return c, nil
+		}
+	}
+	return nil, unsupported
+}
+
+// 解析WWW-Authenticate，不是Digest质询时返回nil
+func parseDigestChallenge(header string) (*digestChallenge, error) {
+	const prefix = "digest "
+	if len(header) < len(prefix) || strings.ToLower(header[:len(prefix)]) != prefix {
+		return nil, nil
+	}
+	var c = &digestChallenge{}
+	var qops []string
+	for _, part := range splitDigestParams(header[len(prefix):]) {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		v := unquote(kv[1])
+		switch strings.ToLower(strings.TrimSpace(kv[0])) {
+		case "realm":
+			c.realm = v
+		case "nonce":
+			c.nonce = v
+		case "opaque":
+			c.opaque = v
+		case "algorithm":
+			c.algorithm = v
+		case "qop":
+			// 可能同时提供auth及auth-int
+			for _, q := range strings.Split(v, ",") {
+				if q = strings.TrimSpace(q); q != "" {
+					qops = append(qops, q)
+				}
+			}
+		}
+	}
+	if c.nonce == "" {
+		return nil, nil
+	}
+
+	alg := strings.ToUpper(c.algorithm)
+	if c.sess = strings.HasSuffix(alg, "-SESS"); c.sess {
+		alg = strings.TrimSuffix(alg, "-SESS")
+	}
+	switch alg {
+	case "", "MD5":
+		c.hash = md5Hex
+	case "SHA-256":
+		c.hash = sha256Hex
+	default:
+		return nil, fmt.Errorf("unsupported digest algorithm: %s", c.algorithm)
+	}
+	for _, q := range qops {
+		if strings.ToLower(q) == "auth" {
+			c.qop = "auth"
+		}
+	}
+	if len(qops) > 0 && c.qop == "" {
+		return nil, fmt.Errorf("unsupported digest qop: %s", strings.Join(qops, ","))
+	}
+	if c.sess && c.qop == "" {
+		return nil, fmt.Errorf("digest algorithm %s requires qop", c.algorithm)
+	}
+	return c, nil
+}
+
+// 按逗号分隔，引号中的逗号不分隔
+func splitDigestParams(s string) []string {
+	var parts []string
+	var quoted bool
+	var start int
+	for i, ch := range s {
+		switch ch {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// 生成Authorization请求头，调用方需要持有锁（nc递增）
+func (c *digestChallenge) authorize(req *http.Request, username string, password string) string {
+	uri := req.URL.RequestURI()
+	ha1 := c.hash(username + ":" + c.realm + ":" + password)
+	ha2 := c.hash(req.Method + ":" + uri)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `Digest username="%s", realm="%s", nonce="%s", uri="%s"`, username, c.realm, c.nonce, uri)
+	if c.qop == "" {
+		fmt.Fprintf(&b, `, response="%s"`, c.hash(ha1+":"+c.nonce+":"+ha2))
+	} else {
+		c.nc++
+		nc := fmt.Sprintf("%08x", c.nc)
+		cnonce := newCnonce()
+		if c.sess {
+			ha1 = c.hash(ha1 + ":" + c.nonce + ":" + cnonce)
+		}
+		response := c.hash(ha1 + ":" + c.nonce + ":" + nc + ":" + cnonce + ":" + c.qop + ":" + ha2)
+		fmt.Fprintf(&b, `, qop=%s, nc=%s, cnonce="%s", response="%s"`, c.qop, nc, cnonce, response)
+	}
+	if c.opaque != "" {
+		fmt.Fprintf(&b, `, opaque="%s"`, c.opaque)
+	}
+	if c.algorithm != "" {
+		fmt.Fprintf(&b, `, algorithm=%s`, c.algorithm)
+	}
+	return b.String()
+}
@@ -0,0 +1,163 @@
+package downloader
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// RFC 7616 3.9.1中的示例
+const (
+	rfcUsername = "Mufasa"
+	rfcPassword = "Circle of Life"
+	rfcRealm    = "http-auth@example.org"
+	rfcNonce    = "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v"
+	rfcCnonce   = "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ"
+	rfcOpaque   = "FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"
+	rfcURL      = "http://www.example.org/dir/index.html"
+)
+
+func rfcChallenge(algorithm string) string {
+	return `Digest realm="` + rfcRealm + `", qop="auth, auth-int", algorithm=` + algorithm +
+		`, nonce="` + rfcNonce + `", opaque="` + rfcOpaque + `"`
+}
+
+// 返回的函数恢复原来的实现
+func fixedCnonce() func() {
+	orig := newCnonce
+	newCnonce = func() string { return rfcCnonce }
+	return func() { newCnonce = orig }
+}
+
+// Authorization请求头中的参数，值去掉引号
+func digestParams(t *testing.T, header string) map[string]string {
+	t.Helper()
+	if !strings.HasPrefix(header, "Digest ") {
+		t.Fatalf("not a digest header: %s", header)
+	}
+	var params = make(map[string]string)
+	for _, part := range splitDigestParams(strings.TrimPrefix(header, "Digest ")) {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			t.Fatalf("malformed param %q in %s", part, header)
+		}
+		params[strings.TrimSpace(kv[0])] = unquote(kv[1])
+	}
+	return params
+}
+
+func TestDigestRFC7616(t *testing.T) {
+	defer fixedCnonce()()
+	tests := []struct {
+		algorithm string
+		response  string
+	}{
+		{"MD5", "8ca523f5e9506fed4657c9700eebdbec"},
+		{"SHA-256", "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			c, err := parseDigestChallenge(rfcChallenge(tt.algorithm))
+			if err != nil || c == nil {
+				t.Fatalf("parse: %v, %v", c, err)
+			}
+			req, err := http.NewRequest(http.MethodGet, rfcURL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			params := digestParams(t, c.authorize(req, rfcUsername, rfcPassword))
+			want := map[string]string{
+				"username":  rfcUsername,
+				"realm":     rfcRealm,
+				"uri":       "/dir/index.html",
+				"algorithm": tt.algorithm,
+				"nonce":     rfcNonce,
+				"nc":        "00000001",
+				"cnonce":    rfcCnonce,
+				"qop":       "auth",
+				"response":  tt.response,
+				"opaque":    rfcOpaque,
+			}
+			for k, v := range want {
+				if params[k] != v {
+					t.Errorf("%s = %q, want %q", k, params[k], v)
+				}
+			}
+
+			// 同一nonce之后的请求递增nc
+			params = digestParams(t, c.authorize(req, rfcUsername, rfcPassword))
+			if params["nc"] != "00000002" {
+				t.Fatalf("nc of second request = %s", params["nc"])
+			}
+		})
+	}
+}
+
+// -sess变体：HA1 = H(H(username:realm:password):nonce:cnonce)
+func TestDigestSess(t *testing.T) {
+	defer fixedCnonce()()
+	for alg, hash := range map[string]func(string) string{"MD5-sess": md5Hex, "SHA-256-sess": sha256Hex} {
+		c, err := parseDigestChallenge(rfcChallenge(alg))
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+		req, _ := http.NewRequest(http.MethodGet, rfcURL, nil)
+		params := digestParams(t, c.authorize(req, rfcUsername, rfcPassword))
+
+		ha1 := hash(hash(rfcUsername+":"+rfcRealm+":"+rfcPassword) + ":" + rfcNonce + ":" + rfcCnonce)
+		ha2 := hash("GET:/dir/index.html")
+		want := hash(ha1 + ":" + rfcNonce + ":00000001:" + rfcCnonce + ":auth:" + ha2)
+		if params["response"] != want || params["algorithm"] != alg {
+			t.Fatalf("%s: response %s algorithm %s, want %s", alg, params["response"], params["algorithm"], want)
+		}
+	}
+}
+
+func TestParseDigestChallenge(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		qop     string
+		nilOK   bool // 不是digest质询
+		wantErr bool
+	}{
+		{name: "basic challenge", header: `Basic realm="x"`, nilOK: true},
+		{name: "missing nonce", header: `Digest realm="x"`, nilOK: true},
+		{name: "rfc 2069 without qop", header: `Digest realm="x", nonce="n"`},
+		{name: "auth among options", header: `Digest realm="x", nonce="n", qop="auth-int,auth"`, qop: "auth"},
+		{name: "auth-int only", header: `Digest realm="x", nonce="n", qop="auth-int"`, wantErr: true},
+		{name: "unsupported algorithm", header: `Digest realm="x", nonce="n", qop="auth", algorithm=SHA-512-256`, wantErr: true},
+		{name: "sess without qop", header: `Digest realm="x", nonce="n", algorithm=MD5-sess`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseDigestChallenge(tt.header)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if (c == nil) != tt.nilOK {
+				t.Fatalf("challenge = %+v", c)
+			}
+			if c != nil && c.qop != tt.qop {
+				t.Fatalf("qop = %q, want %q", c.qop, tt.qop)
+			}
+		})
+	}
+}
+
+// 服务端同时提供多个质询时使用第一个支持的，全部不支持时返回错误
+func TestSelectDigestChallenge(t *testing.T) {
+	c, err := selectDigestChallenge([]string{rfcChallenge("SHA-512-256"), rfcChallenge("SHA-256"), rfcChallenge("MD5")})
+	if err != nil || c == nil || c.algorithm != "SHA-256" {
+		t.Fatalf("selected %+v, err %v", c, err)
+	}
+	if c, err = selectDigestChallenge([]string{`Bearer realm="x"`}); c != nil || err != nil {
+		t.Fatalf("non-digest challenge: %+v, %v", c, err)
+	}
+	if c, err = selectDigestChallenge([]string{`Bearer realm="x"`, rfcChallenge("SHA-512-256")}); c != nil || err == nil {
+		t.Fatalf("only unsupported challenges: %+v, %v", c, err)
+	}
+}
@@ -1,9 +1,55 @@
 package downloader
 
 import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
 	"github.com/andrewyi/crawler/src/entity"
+	"github.com/andrewyi/crawler/src/enum"
 )
 
 type Downloader interface {
 	Download(string) entity.PageInfo
 }
+
+// 数据库中的url已经移除了协议（见util.ShortifyURL），从数据库重新提交的url默认使用http，https站点一般会跳转
+func withScheme(u string) string {
+	if strings.HasPrefix(u, "//") {
+		return "http:" + u
+	}
+	return u
+}
+
+// 非http(s)协议的url（例如mailto:、javascript:）不在抓取范围内，返回true时无需下载
+func outOfScope(u string) (entity.PageInfo, bool) {
+	parsed, err := url.Parse(u)
+	if err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") {
+		return entity.PageInfo{}, false
+	}
+	remark := "unsupported url scheme"
+	if err != nil {
+		remark = err.Error()
+	}
+	return entity.PageInfo{URL: u, State: enum.PageStateOutOfScope, Remark: remark}, true
+}
+
+// 5xx及429为暂时性失败，其余4xx为永久性失败，跳转已经由http client处理
+func classifyStatus(code int) uint32 {
+	switch {
+	case code == http.StatusTooManyRequests || code >= http.StatusInternalServerError:
+		return enum.PageStateFailTransient
+	case code >= http.StatusBadRequest:
+		return enum.PageStateFailPermanent
+	default:
+		return enum.PageStateSuccess
+	}
+}
+
+func tooLarge(page entity.PageInfo, size int64, maxSize int64) entity.PageInfo {
+	page.State = enum.PageStateTooLarge
+	page.Remark = fmt.Sprintf("content size %d exceeds %d bytes", size, maxSize)
+	page.Content = ""
+	return page
+}
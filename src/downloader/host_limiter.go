@@ -0,0 +1,86 @@
+// 按host限制下载频率，所有downloader worker共享同一个HostLimiter
+// 每个host的请求按照 1/rate 的间隔依次放行，rate为0时不限制
+// 限制可以在运行期间修改，对之后的请求生效
+package downloader
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type HostLimiter struct {
+	mu          sync.Mutex
+	defaultRate float64
+	rates       map[string]float64   // 针对特定host的限制，覆盖defaultRate
+	next        map[string]time.Time // 每个host下一次可以放行的时间
+}
+
+func NewHostLimiter(defaultRate float64) *HostLimiter {
+	return &HostLimiter{
+		defaultRate: defaultRate,
+		rates:       make(map[string]float64),
+		next:        make(map[string]time.Time),
+	}
+}
+
+// 阻塞直到此host可以发起下一次请求，仅在ctx结束时返回错误
+func (h *HostLimiter) Wait(ctx context.Context, host string) error {
+	h.mu.Lock()
+	rate, ok := h.rates[host]
+	if !ok {
+		rate = h.defaultRate
+	}
+	if rate <= 0 {
+		h.mu.Unlock()
+		return nil
+	}
+
+	now := time.Now()
+	at := h.next[host]
+	if at.Before(now) {
+		at = now
+	}
+	h.next[host] = at.Add(time.Duration(float64(time.Second) / rate))
+	h.mu.Unlock()
+
+	delay := at.Sub(now)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (h *HostLimiter) SetDefault(rate float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.defaultRate = rate
+}
+
+// rate小于0时移除此host的单独限制，恢复使用默认值
+func (h *HostLimiter) SetHost(host string, rate float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if rate < 0 {
+		delete(h.rates, host)
+		return
+	}
+	h.rates[host] = rate
+}
+
+func (h *HostLimiter) Limits() (float64, map[string]float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	rates := make(map[string]float64, len(h.rates))
+	for k, v := range h.rates {
+		rates[k] = v
+	}
+	return h.defaultRate, rates
+}
@@ -0,0 +1,126 @@
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// 模拟表单登录：GET登录页，填写表单后POST，响应中的session cookie由cookie jar保存
+// 登录页中表单原有的字段（例如csrf token等隐藏字段）会被保留，配置的fields覆盖同名字段
+func (a *Authenticator) login(r *authRule) error {
+	cfg := r.cfg
+	resp, err := a.client.Get(cfg.Form.LoginURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("login page responded with %s", resp.Status)
+	}
+	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	form := findLoginForm(doc, cfg.Form.FormSelector)
+	if form == nil {
+		return fmt.Errorf("login form not found in %s", cfg.Form.LoginURL)
+	}
+	values := formValues(form)
+	for k, v := range cfg.Form.Fields {
+		values.Set(k, v)
+	}
+	// 跳转之后的登录页，action相对于最终的url
+	action := resp.Request.URL
+	if href, ok := form.Attr("action"); ok && strings.TrimSpace(href) != "" {
+		if action, err = action.Parse(strings.TrimSpace(href)); err != nil {
+			return err
+		}
+	}
+
+	post, err := a.client.PostForm(action.String(), values)
+	if err != nil {
+		return err
+	}
+	defer post.Body.Close()
+	if post.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("login responded with %s", post.Status)
+	}
+	// 登录之后仍然出现登出特征（例如再次返回登录表单）时认为登录失败
+	if r.loggedOut != nil {
+		body, err := ioutil.ReadAll(io.LimitReader(post.Body, 1<<20))
+		if err != nil {
+			return err
+		}
+		if r.loggedOut.Match(body) {
+			return fmt.Errorf("still logged out after login, check the credentials")
+		}
+	}
+	return nil
+}
+
+// 指定selector时使用第一个匹配的表单，否则使用第一个包含密码输入框的表单，都没有时使用第一个表单
+func findLoginForm(doc *goquery.Document, selector string) *goquery.Selection {
+	if selector != "" {
+		if s := doc.Find(selector).First(); s.Length() > 0 {
+			return s
+		}
+		return nil
+	}
+	forms := doc.Find("form")
+	if forms.Length() == 0 {
+		return nil
+	}
+	if s := forms.FilterFunction(func(_ int, f *goquery.Selection) bool {
+		return f.Find(`input[type="password"]`).Length() > 0
+	}).First(); s.Length() > 0 {
+		return s
+	}
+	return forms.First()
+}
+
+// 表单中input（不包括未选中的checkbox/radio及按钮）、select、textarea的默认值
+func formValues(form *goquery.Selection) url.Values {
+	var values = url.Values{}
+	form.Find("input").Each(func(_ int, s *goquery.Selection) {
+		name, ok := s.Attr("name")
+		if !ok || name == "" {
+			return
+		}
+		typ := strings.ToLower(s.AttrOr("type", "text"))
+		switch typ {
+		case "submit", "button", "image", "reset", "file":
+			return
+		case "checkbox", "radio":
+			if _, checked := s.Attr("checked"); !checked {
+				return
+			}
+		}
+		values.Add(name, s.AttrOr("value", ""))
+	})
+	form.Find("select").Each(func(_ int, s *goquery.Selection) {
+		name, ok := s.Attr("name")
+		if !ok || name == "" {
+			return
+		}
+		opt := s.Find("option[selected]").First()
+		if opt.Length() == 0 {
+			opt = s.Find("option").First()
+		}
+		if opt.Length() > 0 {
+			values.Add(name, opt.AttrOr("value", opt.Text()))
+		}
+	})
+	form.Find("textarea").Each(func(_ int, s *goquery.Selection) {
+		if name, ok := s.Attr("name"); ok && name != "" {
+			values.Add(name, s.Text())
+		}
+	})
+	return values
+}
@@ -0,0 +1,235 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andrewyi/crawler/src/enum"
+)
+
+const loggedOutPage = "please log in"
+
+// 模拟表单登录的站点：登录页带有csrf token，登录成功后下发session cookie
+type loginSite struct {
+	mu       sync.Mutex
+	fail     bool // 为true时登录返回500
+	attempts int  // 提交登录表单的次数
+	sessions map[string]bool
+}
+
+func newLoginSite() (*loginSite, *httptest.Server) {
+	site := &loginSite{sessions: make(map[string]bool)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<form id="search" action="/search"><input name="q"></form>
+<form action="/session" method="post">
+<input type="hidden" name="csrf" value="token">
+<input name="username"><input type="password" name="password">
+<input type="checkbox" name="remember"><input type="submit" name="go" value="login">
+</form>`)
+	})
+	mux.HandleFunc("/session", func(w http.ResponseWriter, r *http.Request) {
+		site.mu.Lock()
+		defer site.mu.Unlock()
+		site.attempts++
+		if site.fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_ = r.ParseForm()
+		if r.PostForm.Get("csrf") != "token" || r.PostForm.Get("username") != "user" || r.PostForm.Get("password") != "pass" ||
+			r.PostForm.Get("remember") != "" || r.PostForm.Get("go") != "" {
+			fmt.Fprint(w, loggedOutPage)
+			return
+		}
+		sid := strconv.Itoa(site.attempts)
+		site.sessions[sid] = true
+		http.SetCookie(w, &http.Cookie{Name: "sid", Value: sid, Path: "/"})
+		fmt.Fprint(w, "welcome")
+	})
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		site.mu.Lock()
+		defer site.mu.Unlock()
+		if c, err := r.Cookie("sid"); err == nil && site.sessions[c.Value] {
+			fmt.Fprint(w, "members only")
+			return
+		}
+		fmt.Fprint(w, loggedOutPage)
+	})
+	return site, httptest.NewServer(mux)
+}
+
+func (s *loginSite) set(fail bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail = fail
+}
+
+func (s *loginSite) loginAttempts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.attempts
+}
+
+// 服务端使所有session失效
+func (s *loginSite) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions = make(map[string]bool)
+}
+
+func newFormAuthenticator(t *testing.T, srv *httptest.Server, password string) *Authenticator {
+	t.Helper()
+	cfg := AuthConfig{
+		Domain:           "127.0.0.1",
+		Type:             enum.AuthTypeForm,
+		LoggedOutPattern: loggedOutPage,
+	}
+	cfg.Form.LoginURL = srv.URL + "/login"
+	cfg.Form.Fields = map[string]string{"username": "user", "password": password}
+	a, err := NewAuthenticator([]AuthConfig{cfg}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// 返回响应内容及session编号
+func fetch(t *testing.T, a *Authenticator, url string) (string, uint64, error) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, session, err := a.Do(&http.Client{Jar: a.Jar()}, req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body), session, nil
+}
+
+func TestFormLogin(t *testing.T) {
+	site, srv := newLoginSite()
+	defer srv.Close()
+	a := newFormAuthenticator(t, srv, "pass")
+
+	for i := 0; i < 3; i++ {
+		body, session, err := fetch(t, a, srv.URL+"/page")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body != "members only" || session != 1 {
+			t.Fatalf("request %d: body %q session %d", i+1, body, session)
+		}
+	}
+	// 只登录一次，之后的请求复用session cookie
+	if got := site.loginAttempts(); got != 1 {
+		t.Fatalf("login attempts = %d, want 1", got)
+	}
+}
+
+func TestLoggedOut(t *testing.T) {
+	site, srv := newLoginSite()
+	defer srv.Close()
+	a := newFormAuthenticator(t, srv, "pass")
+	url := srv.URL + "/page"
+
+	_, first, err := fetch(t, a, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.LoggedOut(url, first, "members only") {
+		t.Fatal("logged out without matching content")
+	}
+
+	site.expire()
+	body, session, err := fetch(t, a, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session != first || !a.LoggedOut(url, session, body) {
+		t.Fatalf("expired session not detected: body %q session %d", body, session)
+	}
+
+	// 失效之后下一次请求重新登录
+	body, second, err := fetch(t, a, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "members only" || second == first {
+		t.Fatalf("after relogin: body %q session %d", body, second)
+	}
+	if got := site.loginAttempts(); got != 2 {
+		t.Fatalf("login attempts = %d, want 2", got)
+	}
+
+	// 使用旧session的请求晚到时不会使新的session失效
+	if !a.LoggedOut(url, first, loggedOutPage) {
+		t.Fatal("content matching the pattern not reported")
+	}
+	if _, session, _ = fetch(t, a, url); session != second {
+		t.Fatalf("session = %d after stale logout, want %d", session, second)
+	}
+	if got := site.loginAttempts(); got != 2 {
+		t.Fatalf("login attempts = %d after stale logout, want 2", got)
+	}
+}
+
+func TestLoginRetryInterval(t *testing.T) {
+	site, srv := newLoginSite()
+	defer srv.Close()
+	site.set(true)
+	a := newFormAuthenticator(t, srv, "pass")
+	url := srv.URL + "/page"
+
+	if _, _, err := fetch(t, a, url); !errors.Is(err, ErrLoginFailed) {
+		t.Fatalf("err = %v, want ErrLoginFailed", err)
+	}
+	page := requestFailed(url, fmt.Errorf("wrapped: %w", ErrLoginFailed))
+	if page.State != enum.PageStateFailTransient || !page.AuthFailed {
+		t.Fatalf("page of login failure = %+v", page)
+	}
+
+	// 重试间隔之内直接返回上一次的错误，不再请求登录
+	site.set(false)
+	if _, _, err := fetch(t, a, url); !errors.Is(err, ErrLoginFailed) {
+		t.Fatalf("err within retry interval = %v, want ErrLoginFailed", err)
+	}
+	if got := site.loginAttempts(); got != 1 {
+		t.Fatalf("login attempts = %d, want 1", got)
+	}
+
+	r := a.match(url)
+	r.mu.Lock()
+	r.lastLogin = time.Now().Add(-loginRetryInterval)
+	r.mu.Unlock()
+	body, session, err := fetch(t, a, url)
+	if err != nil || body != "members only" || session == 0 {
+		t.Fatalf("after retry interval: body %q session %d err %v", body, session, err)
+	}
+	if got := site.loginAttempts(); got != 2 {
+		t.Fatalf("login attempts = %d, want 2", got)
+	}
+}
+
+// 登录之后仍然返回登出特征（例如凭据错误）时视为登录失败
+func TestLoginWrongCredentials(t *testing.T) {
+	_, srv := newLoginSite()
+	defer srv.Close()
+	a := newFormAuthenticator(t, srv, "wrong")
+	if _, _, err := fetch(t, a, srv.URL+"/page"); !errors.Is(err, ErrLoginFailed) {
+		t.Fatalf("err = %v, want ErrLoginFailed", err)
+	}
+}
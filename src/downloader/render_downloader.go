@@ -0,0 +1,282 @@
+// 通过Chrome DevTools Protocol驱动本地headless chromium渲染页面
+// 适用于在客户端通过javascript构建内容（包括链接）的页面，返回渲染完成后的DOM
+// NOTE: 所有RenderDownloader共享同一个浏览器进程，每次下载打开一个新的tab
+// 渲染的并发数量由Browser统一控制，与downloader的worker数量相互独立
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+
+	"github.com/andrewyi/crawler/src/entity"
+	"github.com/andrewyi/crawler/src/enum"
+)
+
+type Browser struct {
+	ctx         context.Context
+	cancelAlloc context.CancelFunc
+	cancel      context.CancelFunc
+
+	// 渲染并发控制
+	sem chan struct{}
+}
+
+// execPath为空时由chromedp自行查找本地的chromium/chrome
+func NewBrowser(ctx context.Context, execPath string, concurrency uint32) (*Browser, error) {
+	opts := chromedp.DefaultExecAllocatorOptions[:]
+	if execPath != "" {
+		opts = append(opts, chromedp.ExecPath(execPath))
+	}
+	allocCtx, cancelAlloc := chromedp.NewExecAllocator(ctx, opts...)
+	browserCtx, cancel := chromedp.NewContext(allocCtx)
+
+	// 不执行任何action，仅仅启动浏览器进程
+	if err := chromedp.Run(browserCtx); err != nil {
+		cancel()
+		cancelAlloc()
+		return nil, err
+	}
+
+	if concurrency == 0 {
+		concurrency = 1
+	}
+	return &Browser{
+		ctx:         browserCtx,
+		cancelAlloc: cancelAlloc,
+		cancel:      cancel,
+		sem:         make(chan struct{}, concurrency),
+	}, nil
+}
+
+func (b *Browser) Close() {
+	b.cancel()
+	b.cancelAlloc()
+}
+
+type RenderDownloader struct {
+	ctx          context.Context
+	browser      *Browser
+	timeout      uint32
+	retry        uint32
+	maxSize      int64
+	idleTime     time.Duration
+	waitSelector string
+	auth         *Authenticator // 为nil时没有配置认证
+}
+
+func NewRenderDownloader(
+	ctx context.Context, browser *Browser, timeout uint32, retry uint32, maxSize int64, idleTime uint32, waitSelector string,
+	auth *Authenticator) Downloader {
+
+	return &RenderDownloader{
+		ctx:          ctx,
+		browser:      browser,
+		timeout:      timeout,
+		retry:        retry,
+		maxSize:      maxSize,
+		idleTime:     time.Duration(idleTime) * time.Millisecond,
+		waitSelector: waitSelector,
+		auth:         auth,
+	}
+}
+
+func (r *RenderDownloader) Download(url string) entity.PageInfo {
+	url = withScheme(url)
+	if page, ok := outOfScope(url); ok {
+		return page
+	}
+	// 浏览器不使用认证信息，不带认证抓取的结果没有意义（通常为登录页）
+	// 域名重叠的配置在启动时已经被拒绝，这里处理只通过pattern匹配的规则
+	if r.auth != nil && r.auth.Match(url) {
+		return requestFailed(url, fmt.Errorf("%w: render downloader does not support auth", ErrAuthUnsupported))
+	}
+	// 等待渲染名额，期间如果程序终止则直接返回
+	select {
+	case r.browser.sem <- struct{}{}:
+	case <-r.ctx.Done():
+		return entity.PageInfo{
+			URL:    url,
+			State:  enum.PageStateFailTransient,
+			Remark: r.ctx.Err().Error(),
+		}
+	}
+	defer func() { <-r.browser.sem }()
+
+	// 与SimpleDownloader相同，仅在暂时性失败时重试，至少尝试一次
+	page := r.renderPage(url)
+	for retryCount := uint32(1); retryCount < r.retry && page.State == enum.PageStateFailTransient; retryCount++ {
+		page = r.renderPage(url)
+	}
+	return page
+}
+
+// 渲染结果，状态码及响应头来自主文档的http响应
+type rendered struct {
+	html       string
+	statusCode int
+	status     string
+	robotsTag  string
+}
+
+func (r *RenderDownloader) renderPage(url string) entity.PageInfo {
+	res, err := r.render(url)
+	if err != nil {
+		return entity.PageInfo{
+			URL:    url,
+			State:  enum.PageStateFailTransient,
+			Remark: err.Error(),
+		}
+	}
+
+	var page = entity.PageInfo{
+		URL:        url,
+		StatusCode: res.statusCode,
+		RobotsTag:  res.robotsTag,
+	}
+	// 未能获取主文档的响应（例如file://或者被缓存）时按成功处理
+	if res.statusCode > 0 {
+		if state := classifyStatus(res.statusCode); state != enum.PageStateSuccess {
+			page.State = state
+			page.Remark = res.status
+			return page
+		}
+	}
+	if r.maxSize > 0 && int64(len(res.html)) > r.maxSize {
+		return tooLarge(page, int64(len(res.html)), r.maxSize)
+	}
+	page.State = enum.PageStateSuccess
+	page.Content = res.html
+	return page
+}
+
+func (r *RenderDownloader) render(url string) (rendered, error) {
+	tabCtx, cancelTab := chromedp.NewContext(r.browser.ctx)
+	defer cancelTab()
+	tabCtx, cancel := context.WithTimeout(tabCtx, time.Duration(r.timeout)*time.Second)
+	defer cancel()
+
+	// 记录已经到达network idle的loader，以及每个loader的文档响应，导航完成前事件就可能已经到达
+	var (
+		mu        sync.Mutex
+		idleSet   = make(map[cdp.LoaderID]struct{})
+		idleChan  = make(chan struct{}, 1)
+		responses = make(map[cdp.LoaderID]*network.Response)
+		loader    cdp.LoaderID
+	)
+	chromedp.ListenTarget(tabCtx, func(ev interface{}) {
+		switch e := ev.(type) {
+		case *page.EventLifecycleEvent:
+			if e.Name != "networkIdle" {
+				return
+			}
+			mu.Lock()
+			idleSet[e.LoaderID] = struct{}{}
+			mu.Unlock()
+			select {
+			case idleChan <- struct{}{}:
+			default:
+			}
+		case *network.EventResponseReceived:
+			// 跳转时只有最终的响应会触发此事件
+			if e.Type != network.ResourceTypeDocument || e.Response == nil {
+				return
+			}
+			mu.Lock()
+			responses[e.LoaderID] = e.Response
+			mu.Unlock()
+		}
+	})
+
+	var res rendered
+	err := chromedp.Run(tabCtx,
+		network.Enable(),
+		page.SetLifecycleEventsEnabled(true),
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			_, loaderID, errText, err := page.Navigate(url).Do(ctx)
+			if err != nil {
+				return err
+			}
+			if errText != "" {
+				return errors.New(errText)
+			}
+			mu.Lock()
+			loader = loaderID
+			mu.Unlock()
+			if r.waitSelector != "" {
+				return nil
+			}
+			return r.waitIdle(ctx, loaderID, &mu, idleSet, idleChan)
+		}),
+		r.waitSelectorAction(),
+		chromedp.OuterHTML("html", &res.html, chromedp.ByQuery),
+	)
+	if err != nil {
+		return res, err
+	}
+
+	mu.Lock()
+	resp := responses[loader]
+	mu.Unlock()
+	if resp != nil {
+		res.statusCode = int(resp.Status)
+		res.status = strings.TrimSpace(fmt.Sprintf("%d %s", resp.Status, resp.StatusText))
+		res.robotsTag = strings.Join(headerValues(resp.Headers, "X-Robots-Tag"), ",")
+	}
+	return res, nil
+}
+
+// devtools中的响应头为json对象，名称的大小写取决于协议（http2为小写），同名的多个值以换行分隔
+func headerValues(headers network.Headers, name string) []string {
+	var m map[string]interface{}
+	if err := json.Unmarshal(headers, &m); err != nil {
+		return nil
+	}
+	var values []string
+	for k, v := range m {
+		if s, ok := v.(string); ok && strings.EqualFold(k, name) {
+			values = append(values, strings.Split(s, "\n")...)
+		}
+	}
+	return values
+}
+
+// 等待network idle，超过idleTime仍未到达时直接使用当前DOM
+func (r *RenderDownloader) waitIdle(
+	ctx context.Context, loaderID cdp.LoaderID, mu *sync.Mutex, idleSet map[cdp.LoaderID]struct{}, idleChan chan struct{}) error {
+
+	timer := time.NewTimer(r.idleTime)
+	defer timer.Stop()
+	for {
+		mu.Lock()
+		_, idle := idleSet[loaderID]
+		mu.Unlock()
+		if idle {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return nil
+		case <-idleChan:
+		}
+	}
+}
+
+func (r *RenderDownloader) waitSelectorAction() chromedp.Action {
+	if r.waitSelector == "" {
+		return chromedp.ActionFunc(func(context.Context) error { return nil })
+	}
+	return chromedp.WaitReady(r.waitSelector, chromedp.ByQuery)
+}
@@ -0,0 +1,77 @@
+// 根据url规则选择具体的Downloader，规则按照配置顺序匹配，均未匹配时使用默认Downloader
+package downloader
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/andrewyi/crawler/src/entity"
+	"github.com/andrewyi/crawler/src/util"
+)
+
+type Rule struct {
+	domain  string
+	pattern *regexp.Regexp
+}
+
+// domain与pattern均可为空，均为空时匹配所有url
+func NewRule(domain string, pattern string) (*Rule, error) {
+	r := &Rule{
+		domain: strings.ToLower(domain),
+	}
+	if pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		r.pattern = re
+	}
+	return r, nil
+}
+
+// domain匹配自身以及所有子域名
+func (r *Rule) Match(url string) bool {
+	if r.domain != "" {
+		domain, err := util.GetDomain(url)
+		if err != nil {
+			return false
+		}
+		domain = strings.ToLower(domain)
+		if domain != r.domain && !strings.HasSuffix(domain, "."+r.domain) {
+			return false
+		}
+	}
+	if r.pattern != nil && !r.pattern.MatchString(url) {
+		return false
+	}
+	return true
+}
+
+type route struct {
+	rule       *Rule
+	downloader Downloader
+}
+
+type RoutingDownloader struct {
+	routes   []route
+	fallback Downloader
+}
+
+func NewRoutingDownloader(fallback Downloader) *RoutingDownloader {
+	return &RoutingDownloader{
+		fallback: fallback,
+	}
+}
+
+func (r *RoutingDownloader) AddRoute(rule *Rule, d Downloader) {
+	r.routes = append(r.routes, route{rule: rule, downloader: d})
+}
+
+func (r *RoutingDownloader) Download(url string) entity.PageInfo {
+	for _, rt := range r.routes {
+		if rt.rule.Match(url) {
+			return rt.downloader.Download(url)
+		}
+	}
+	return r.fallback.Download(url)
+}
@@ -1,14 +1,15 @@
 // 仅仅实现了简单的http Get方式下载
 // TODO:
 // 1. 增加更多GET配置，包括agent、cookies、proxy、content-type
-// 2. 增加更多的错误验证(http response status)
-// 3. 支持http/https，细化cname/3xx跳转
+// 2. 细化cname/3xx跳转
 package downloader
 
 import (
 	"context"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/andrewyi/crawler/src/entity"
@@ -19,61 +20,100 @@ type SimpleDownloader struct {
 	ctx     context.Context
 	timeout uint32
 	retry   uint32
+	maxSize int64 // 内容大小上限，单位字节，0为不限制
 
 	client *http.Client
+	auth   *Authenticator // 为nil时不认证
 }
 
-func NewSimpleDownloader(ctx context.Context, timeout uint32, retry uint32) Downloader {
+func NewSimpleDownloader(ctx context.Context, timeout uint32, retry uint32, maxSize int64, auth *Authenticator) Downloader {
 
+	var client = &http.Client{
+		Timeout: time.Duration(timeout) * time.Second,
+	}
+	if auth != nil {
+		client.Jar = auth.Jar()
+	}
 	return &SimpleDownloader{
 		ctx:     ctx,
 		timeout: timeout,
 		retry:   retry,
-		client: &http.Client{
-			Timeout: time.Duration(timeout) * time.Second,
-		},
+		maxSize: maxSize,
+		client:  client,
+		auth:    auth,
 	}
 }
 
+// 仅在暂时性失败时重试，retry为尝试的总次数，至少尝试一次
 func (s *SimpleDownloader) Download(url string) entity.PageInfo {
-	var (
-		err        error
-		retryCount uint32
-		content    []byte
-	)
+	url = withScheme(url)
+	if page, ok := outOfScope(url); ok {
+		return page
+	}
+	page := s.get(url)
+	for retryCount := uint32(1); retryCount < s.retry && page.State == enum.PageStateFailTransient; retryCount++ {
+		page = s.get(url)
+	}
+	return page
+}
 
-	for {
-		if retryCount >= s.retry {
-			break
-		}
+// 未配置认证时与client.Get相同
+func (s *SimpleDownloader) do(url string) (*http.Response, uint64, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if s.auth == nil {
+		resp, err := s.client.Do(req)
+		return resp, 0, err
+	}
+	return s.auth.Do(s.client, req)
+}
 
-		resp, err := s.client.Get(url)
-		if err != nil {
-			retryCount++
-			continue
-		}
-		defer resp.Body.Close()
-		content, err = ioutil.ReadAll(resp.Body)
-		if err != nil {
-			retryCount++
-			continue
-		}
+func (s *SimpleDownloader) get(url string) entity.PageInfo {
+	resp, session, err := s.do(url)
+	if err != nil {
+		return requestFailed(url, err)
+	}
+	defer resp.Body.Close()
+
+	var page = entity.PageInfo{
+		URL:        url,
+		StatusCode: resp.StatusCode,
+		RobotsTag:  strings.Join(resp.Header["X-Robots-Tag"], ","),
+	}
+	if state := classifyStatus(resp.StatusCode); state != enum.PageStateSuccess {
+		page.State = state
+		page.Remark = resp.Status
+		return page
+	}
 
-		if err == nil {
-			break
+	var body io.Reader = resp.Body
+	if s.maxSize > 0 {
+		if resp.ContentLength > s.maxSize {
+			return tooLarge(page, resp.ContentLength, s.maxSize)
 		}
+		// Content-Length可能不存在或者不准确，多读取一个字节用于判断是否超出
+		body = io.LimitReader(resp.Body, s.maxSize+1)
 	}
+	content, err := ioutil.ReadAll(body)
 	if err != nil {
-		return entity.PageInfo{
-			URL:    url,
-			State:  enum.PageStateFail,
-			Remark: err.Error(),
-		}
+		page.State = enum.PageStateFailTransient
+		page.Remark = err.Error()
+		return page
 	}
-
-	return entity.PageInfo{
-		URL:     url,
-		State:   enum.PageStateSuccess,
-		Content: string(content),
+	if s.maxSize > 0 && int64(len(content)) > s.maxSize {
+		return tooLarge(page, int64(len(content)), s.maxSize)
 	}
+	// session失效时按暂时性失败处理，重试时重新登录
+	if s.auth != nil && s.auth.LoggedOut(url, session, string(content)) {
+		page.State = enum.PageStateFailTransient
+		page.Remark = "logged out"
+		page.AuthFailed = true
+		return page
+	}
+
+	page.State = enum.PageStateSuccess
+	page.Content = string(content)
+	return page
 }
@@ -1,18 +1,92 @@
 package entity
 
+import (
+	"strings"
+)
+
 // 保存了下载的内容
 type PageInfo struct {
-	URL     string
-	State   uint32 // 0/success 1/fail
-	Remark  string // error description, if any
-	Content string
+	JobID      string
+	URL        string
+	State      uint32 // 0/success 1/fail
+	Remark     string // error description, if any
+	Content    string
+	StatusCode int    // http状态码，无法获取时为0
+	RobotsTag  string // X-Robots-Tag响应头，多个值以逗号连接
+	// 登录失败、session失效等认证失败，说明的是凭据或配置的问题，与域名是否可用无关
+	AuthFailed bool
+}
+
+// 页面中的一个链接，Text为a标签中的文本
+type Link struct {
+	URL      string
+	Text     string
+	NoFollow bool // rel="nofollow"
+}
+
+// 页面的另一语言版本，来自 <link rel="alternate" hreflang="...">
+type Alternate struct {
+	URL  string
+	Lang string
+}
+
+// 来自 <meta name="robots"> 及 X-Robots-Tag 响应头的指令
+type Robots struct {
+	NoIndex   bool
+	NoFollow  bool
+	NoArchive bool
+}
+
+func (r Robots) String() string {
+	var directives []string
+	if r.NoIndex {
+		directives = append(directives, "noindex")
+	}
+	if r.NoFollow {
+		directives = append(directives, "nofollow")
+	}
+	if r.NoArchive {
+		directives = append(directives, "noarchive")
+	}
+	return strings.Join(directives, ",")
+}
+
+// 从页面中提取的正文及元信息
+type Extracted struct {
+	Title       string
+	Description string
+	Language    string // 页面声明的语言，例如zh-CN
+	Headings    []string
+	Text        string // 去除导航、广告等之后的正文纯文本，段落之间以换行分隔
 }
 
 // 保存了分析后的内容，字段与PageInfo一致，只是多了一个解析好的url结合 SubURLs
+// Links与SubURLs一一对应，额外保存了链接的文本
 type ParsedPageInfo struct {
+	JobID   string
 	URL     string
 	State   uint32
 	Remark  string
 	Content string
+	Title   string
+	SimHash uint64 // 可见文本的指纹，0表示没有指纹
 	SubURLs []string
+	Links   []Link
+
+	Extracted Extracted
+
+	Canonical  string // <link rel="canonical">，已转换为绝对url
+	Alternates []Alternate
+	Robots     Robots
+}
+
+// 待下载的url，除url外还携带了用于计算优先级的信息
+type URLTask struct {
+	JobID  string // 所属的job，为空时为enum.DefaultJob
+	URL    string
+	Depth  uint8  // 距离seed的深度，seed为0
+	Parent string // 父页面url，seed为空
+	Anchor string // 父页面中指向此url的链接文本
+	Seed   bool
+	Score  float64 // 由frontier在入队时计算
 }
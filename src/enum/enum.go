@@ -1,11 +1,209 @@
 package enum
 
+import "sort"
+
+const (
+	// 定义了page的状态，失败按照原因区分，只有fail_transient会被重试扫描重新下载
+	PageStatePending         = 0
+	PageStateSuccess         = 1
+	PageStateFailTransient   = 2 // 网络错误、超时、5xx、429等，稍后重试可能成功
+	PageStateFailPermanent   = 3 // 4xx、内容无法解析等，重试不会成功
+	PageStateBlockedByRobots = 4 // 被robots.txt禁止抓取，当前尚未支持robots.txt，保留
+	PageStateOutOfScope      = 5 // 不在抓取范围内，例如非http(s)协议的url
+	PageStateTooLarge        = 6 // 内容超过downloader.max_size
+)
+
+var pageStateNames = map[int]string{
+	PageStatePending:         "pending",
+	PageStateSuccess:         "success",
+	PageStateFailTransient:   "fail_transient",
+	PageStateFailPermanent:   "fail_permanent",
+	PageStateBlockedByRobots: "blocked_by_robots",
+	PageStateOutOfScope:      "out_of_scope",
+	PageStateTooLarge:        "too_large",
+}
+
+// 所有表示失败的状态
+var PageFailStates = []uint8{
+	PageStateFailTransient,
+	PageStateFailPermanent,
+	PageStateBlockedByRobots,
+	PageStateOutOfScope,
+	PageStateTooLarge,
+}
+
+func IsPageFailState(state uint32) bool {
+	return state != PageStatePending && state != PageStateSuccess
+}
+
+// 用于日志、指标等展示
+func PageStateName(state int) string {
+	if name, ok := pageStateNames[state]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// 所有page状态，按照值排序，用于展示可选的状态
+func PageStates() []int {
+	var states = make([]int, 0, len(pageStateNames))
+	for state := range pageStateNames {
+		states = append(states, state)
+	}
+	sort.Ints(states)
+	return states
+}
+
+// 状态名称的别名：fail为区分失败原因之前的状态名称，对应的值即现在的fail_transient
+var pageStateAliases = map[string]int{
+	"fail": PageStateFailTransient,
+}
+
+// 根据名称获取page状态，用于命令行参数等输入
+func ParsePageState(name string) (int, bool) {
+	if state, ok := pageStateAliases[name]; ok {
+		return state, true
+	}
+	for state, n := range pageStateNames {
+		if n == name {
+			return state, true
+		}
+	}
+	return 0, false
+}
+
+const (
+	// 进程可以运行的角色，通过--role指定，多个角色以逗号分隔
+	RoleDownloader = "downloader"
+	RoleAnalyzer   = "analyzer"
+	RoleController = "controller"
+	RoleCore       = "core" // seed注入、重试、终止探测
+)
+
+var Roles = []string{RoleDownloader, RoleAnalyzer, RoleController, RoleCore}
+
 const (
-	// 定义了page的状态
-	// 当前不会针对已经下载好、或下载失败的页面再次进行下载，即只操作一次，后续可以优化
-	PageStatePending = 0
-	PageStateSuccess = 1
-	PageStateFail    = 2
+	// 各个stage之间队列的实现方式
+	QueueTypeMemory   = "memory"
+	QueueTypeDisk     = "disk" // 内存部分已满时溢出到磁盘，Push不会阻塞
+	QueueTypePostgres = "postgres"
+
+	// 队列名称
+	QueueURL        = "url"
+	QueuePage       = "page"
+	QueueParsedPage = "parsed_page"
+)
+
+const (
+	// 导出的文件格式
+	ExportFormatJSONL   = "jsonl"
+	ExportFormatCSV     = "csv"
+	ExportFormatParquet = "parquet"
+)
+
+const (
+	// 链接图的导出格式
+	GraphFormatGraphML = "graphml"
+	GraphFormatGEXF    = "gexf"
+	GraphFormatDOT     = "dot"
+)
+
+const (
+	// 域名的熔断状态
+	DomainStateClosed   = 0 // 正常下载
+	DomainStateOpen     = 1 // 熔断，url保持pending，冷却结束后允许一次探测
+	DomainStateHalfOpen = 2 // 探测中，探测成功后恢复正常
+	DomainStateDead     = 3 // 探测失败次数过多，url保持pending，按照最大冷却时间探测，新的job开始时重置
+)
 
-	MaxRetryTaskNum = 10
+var domainStateNames = map[int]string{
+	DomainStateClosed:   "closed",
+	DomainStateOpen:     "open",
+	DomainStateHalfOpen: "half_open",
+	DomainStateDead:     "dead",
+}
+
+func DomainStateName(state int) string {
+	if name, ok := domainStateNames[state]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// 未指定job时使用的job，crawl命令的seed文件及升级之前的页面都属于该job
+const DefaultJob = "default"
+
+const (
+	// 爬取任务（job）的状态
+	JobStateRunning   = 0
+	JobStatePaused    = 1 // 不再下载，队列中的url保留，恢复后继续
+	JobStateCancelled = 2 // 不再下载，队列中的url被丢弃
+	JobStateFinished  = 3 // 没有pending及等待重试的页面，或者达到了预算
+)
+
+var jobStateNames = map[int]string{
+	JobStateRunning:   "running",
+	JobStatePaused:    "paused",
+	JobStateCancelled: "cancelled",
+	JobStateFinished:  "finished",
+}
+
+func JobStateName(state int) string {
+	if name, ok := jobStateNames[state]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// 定时爬取每一代的状态
+const (
+	GenerationStateRunning  = 0 // job尚未结束
+	GenerationStateFinished = 1 // job已经结束，统计及比较结果已经写入
+)
+
+var generationStateNames = map[int]string{
+	GenerationStateRunning:  "running",
+	GenerationStateFinished: "finished",
+}
+
+func GenerationStateName(state int) string {
+	if name, ok := generationStateNames[state]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// 与上一代相比的页面变化
+const (
+	DiffAdded   = "added"   // 本代抓取成功，上一代没有抓取成功
+	DiffRemoved = "removed" // 上一代抓取成功，本代没有抓取成功
+	DiffChanged = "changed" // 两代均抓取成功，内容指纹不同
+)
+
+var DiffKinds = []string{DiffAdded, DiffRemoved, DiffChanged}
+
+// 按域名配置的认证方式，见downloader.Authenticator
+const (
+	AuthTypeBasic  = "basic"
+	AuthTypeDigest = "digest"
+	AuthTypeBearer = "bearer"
+	AuthTypeForm   = "form" // GET登录页，填写表单后POST，保存响应中的session cookie
+)
+
+// 事件类型，见event包
+const (
+	EventPageStored      = "page_stored"      // 页面抓取成功并写入数据库
+	EventPageFailed      = "page_failed"      // 页面抓取失败，暂时性失败之后可能被重试
+	EventCircuitOpened   = "circuit_opened"   // 域名熔断，包括探测失败后再次熔断
+	EventCrawlCompleted  = "crawl_completed"  // job完成（没有pending及等待重试的页面），或者单次运行的crawl因任务完成而关闭
+	EventBudgetExhausted = "budget_exhausted" // job达到max_pages或max_duration而结束
+)
+
+var EventTypes = []string{EventPageStored, EventPageFailed, EventCircuitOpened, EventCrawlCompleted, EventBudgetExhausted}
+
+// 事件的输出方式
+const (
+	EventSinkStdout  = "stdout"
+	EventSinkFile    = "file"
+	EventSinkWebhook = "webhook"
 )
@@ -0,0 +1,180 @@
+// 爬取过程中的事件（页面写入、失败、域名熔断、job完成等），由各个角色产生，异步发送到配置的sink
+// 用于触发下游处理，不需要轮询数据库或者管理接口
+package event
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/andrewyi/crawler/src/metrics"
+)
+
+type Event struct {
+	ID     string                 `json:"id"` // 同一事件重试发送时不变，接收方可以据此去重
+	Type   string                 `json:"type"`
+	Time   time.Time              `json:"time"`
+	Job    string                 `json:"job,omitempty"`
+	URL    string                 `json:"url,omitempty"`
+	Domain string                 `json:"domain,omitempty"`
+	Data   map[string]interface{} `json:"data,omitempty"`
+}
+
+func newID() string {
+	var b = make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type worker struct {
+	name  string
+	sink  Sink
+	types map[string]bool // 为空时接收所有类型
+	ch    chan Event
+}
+
+// Bus 每个sink有单独的缓冲及发送协程，某个sink缓慢或不可用时不影响其他sink，也不阻塞产生事件的worker
+// 缓冲已满时丢弃事件并记录日志，nil的Bus不发送任何事件
+type Bus struct {
+	logger  *log.Logger
+	workers []*worker
+	wg      sync.WaitGroup
+
+	// 发送中的请求（例如webhook的重试）在Close超时后被取消
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// 没有配置sink时返回nil
+func NewBus(configs []SinkConfig, bufferSize int, logger *log.Logger) (*Bus, error) {
+	if len(configs) == 0 {
+		return nil, nil
+	}
+	if bufferSize <= 0 {
+		bufferSize = 1000
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var b = &Bus{
+		logger: logger,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	for i, cfg := range configs {
+		sink, err := NewSink(cfg)
+		if err != nil {
+			b.closeSinks()
+			cancel()
+			return nil, err
+		}
+		w := &worker{
+			name: sinkName(i, cfg),
+			sink: sink,
+			ch:   make(chan Event, bufferSize),
+		}
+		if len(cfg.Types) > 0 {
+			w.types = make(map[string]bool)
+			for _, t := range cfg.Types {
+				w.types[t] = true
+			}
+		}
+		b.workers = append(b.workers, w)
+	}
+	for _, w := range b.workers {
+		b.wg.Add(1)
+		go b.run(w)
+	}
+	return b, nil
+}
+
+func (b *Bus) run(w *worker) {
+	defer b.wg.Done()
+	for e := range w.ch {
+		if b.ctx.Err() != nil { // Close超时，剩余的事件直接丢弃
+			metrics.EventsSent.WithLabelValues(w.name, "dropped").Inc()
+			continue
+		}
+		if err := w.sink.Write(b.ctx, e); err != nil {
+			metrics.EventsSent.WithLabelValues(w.name, "error").Inc()
+			b.logger.WithError(err).WithField("sink", w.name).WithField("event", e.Type).Error("fail to send event")
+			continue
+		}
+		metrics.EventsSent.WithLabelValues(w.name, "ok").Inc()
+	}
+}
+
+// 补齐id及时间后放入各个sink的缓冲，不会阻塞；Close之后调用时直接丢弃
+func (b *Bus) Emit(e Event) {
+	if b == nil {
+		return
+	}
+	if e.ID == "" {
+		e.ID = newID()
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return
+	}
+	for _, w := range b.workers {
+		if w.types != nil && !w.types[e.Type] {
+			continue
+		}
+		select {
+		case w.ch <- e:
+		default:
+			metrics.EventsSent.WithLabelValues(w.name, "dropped").Inc()
+			b.logger.WithField("sink", w.name).WithField("event", e.Type).Warn("event buffer is full, event dropped")
+		}
+	}
+}
+
+// 等待缓冲中的事件发送完成，超过timeout后取消发送中的请求，剩余的事件被丢弃
+func (b *Bus) Close(timeout time.Duration) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.closed = true
+	for _, w := range b.workers {
+		close(w.ch)
+	}
+	b.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		b.logger.WithField("timeout", timeout).Warn("timeout waiting for events to be sent")
+		b.cancel()
+		<-done
+	}
+	b.cancel()
+	b.closeSinks()
+}
+
+func (b *Bus) closeSinks() {
+	for _, w := range b.workers {
+		if err := w.sink.Close(); err != nil {
+			b.logger.WithError(err).WithField("sink", w.name).Error("fail to close event sink")
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package event
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/andrewyi/crawler/src/enum"
+)
+
+// SinkConfig 与配置文件中的events.sinks一致
+type SinkConfig struct {
+	Type  string   `mapstructure:"type"`
+	Types []string `mapstructure:"types"`
+	// file
+	Path string `mapstructure:"path"`
+	// webhook
+	URL        string `mapstructure:"url"`
+	Secret     string `mapstructure:"secret"`
+	Timeout    uint32 `mapstructure:"timeout"`
+	MaxRetries uint32 `mapstructure:"max_retries"`
+	Backoff    uint32 `mapstructure:"backoff"`
+}
+
+// Sink 事件的输出，每个sink只在一个协程中调用，不需要加锁
+type Sink interface {
+	Write(ctx context.Context, e Event) error
+	Close() error
+}
+
+func NewSink(cfg SinkConfig) (Sink, error) {
+	for _, t := range cfg.Types {
+		if !validType(t) {
+			return nil, fmt.Errorf("unknown event type: %s", t)
+		}
+	}
+	switch cfg.Type {
+	case enum.EventSinkStdout:
+		return newWriterSink(os.Stdout, nil), nil
+	case enum.EventSinkFile:
+		if cfg.Path == "" {
+			return nil, fmt.Errorf("path of %s event sink is required", cfg.Type)
+		}
+		f, err := os.OpenFile(cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		return newWriterSink(f, f), nil
+	case enum.EventSinkWebhook:
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("url of %s event sink is required", cfg.Type)
+		}
+		return newWebhookSink(cfg), nil
+	default:
+		return nil, fmt.Errorf("unknown event sink type: %s", cfg.Type)
+	}
+}
+
+func validType(t string) bool {
+	for _, v := range enum.EventTypes {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
+
+// 用于日志及指标的label
+func sinkName(i int, cfg SinkConfig) string {
+	switch cfg.Type {
+	case enum.EventSinkFile:
+		return cfg.Type + ":" + cfg.Path
+	default:
+		return cfg.Type + ":" + strconv.Itoa(i)
+	}
+}
+
+// 每个事件一行json，stdout与日志共用时以行为单位交错
+var stdoutMu sync.Mutex
+
+type writerSink struct {
+	w      io.Writer
+	closer io.Closer // stdout不需要关闭
+}
+
+func newWriterSink(w io.Writer, closer io.Closer) Sink {
+	return &writerSink{w: w, closer: closer}
+}
+
+func (s *writerSink) Write(ctx context.Context, e Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if s.closer == nil {
+		stdoutMu.Lock()
+		defer stdoutMu.Unlock()
+	}
+	_, err = s.w.Write(line)
+	return err
+}
+
+func (s *writerSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// 请求体为事件的json，配置secret时附带签名：
+// X-Crawler-Signature: sha256=hex(hmac_sha256(secret, X-Crawler-Timestamp + "." + body))
+type webhookSink struct {
+	client     *http.Client
+	url        string
+	secret     []byte
+	maxRetries uint32
+	backoff    time.Duration
+}
+
+func newWebhookSink(cfg SinkConfig) Sink {
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	backoff := time.Duration(cfg.Backoff) * time.Second
+	if backoff == 0 {
+		backoff = time.Second
+	}
+	return &webhookSink{
+		client:     &http.Client{Timeout: timeout},
+		url:        cfg.URL,
+		secret:     []byte(cfg.Secret),
+		maxRetries: cfg.MaxRetries,
+		backoff:    backoff,
+	}
+}
+
+// 网络错误、429及5xx时在退避之后重试，最多重试maxRetries次，其他4xx直接放弃
+func (s *webhookSink) Write(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	backoff := s.backoff
+	for attempt := uint32(0); ; attempt++ {
+		retryable, err := s.post(ctx, e, body)
+		if err == nil || !retryable || attempt >= s.maxRetries {
+			return err
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+func (s *webhookSink) post(ctx context.Context, e Event, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Crawler-Event", e.Type)
+	req.Header.Set("X-Crawler-Event-Id", e.ID)
+	if len(s.secret) > 0 {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Crawler-Timestamp", ts)
+		req.Header.Set("X-Crawler-Signature", "sha256="+Sign(s.secret, ts, body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+func (s *webhookSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// 接收方使用相同的方式计算并比较签名
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
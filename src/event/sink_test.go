@@ -0,0 +1,122 @@
+package event
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andrewyi/crawler/src/enum"
+)
+
+func TestSign(t *testing.T) {
+	// python: hmac.new(b"secret", b'1700000000.{"id":"1"}', hashlib.sha256).hexdigest()
+	want := "086f6aff7bd084c98679825129c5a64dbad88c760016d6d2c0fb123f27951d54"
+	if got := Sign([]byte("secret"), "1700000000", []byte(`{"id":"1"}`)); got != want {
+		t.Fatalf("Sign = %s, want %s", got, want)
+	}
+	if Sign([]byte("secret"), "1700000001", []byte(`{"id":"1"}`)) == want {
+		t.Fatal("timestamp not covered by signature")
+	}
+}
+
+func newTestWebhook(url string, secret string, maxRetries uint32) *webhookSink {
+	return &webhookSink{
+		client:     &http.Client{Timeout: time.Second},
+		url:        url,
+		secret:     []byte(secret),
+		maxRetries: maxRetries,
+		backoff:    time.Millisecond,
+	}
+}
+
+// 依次返回statuses中的状态码，之后总是返回200
+func statusServer(statuses ...int) (*httptest.Server, *int32) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		if int(n) <= len(statuses) {
+			w.WriteHeader(statuses[n-1])
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	return srv, &calls
+}
+
+func TestWebhookRetry(t *testing.T) {
+	tests := []struct {
+		name       string
+		statuses   []int
+		maxRetries uint32
+		calls      int32
+		wantErr    bool
+	}{
+		{"success", nil, 3, 1, false},
+		{"retry 5xx", []int{http.StatusInternalServerError, http.StatusBadGateway}, 3, 3, false},
+		{"retry 429", []int{http.StatusTooManyRequests}, 3, 2, false},
+		{"give up on 4xx", []int{http.StatusBadRequest}, 3, 1, true},
+		{"give up on 404", []int{http.StatusNotFound}, 3, 1, true},
+		{"max retries", []int{500, 500, 500, 500}, 2, 3, true},
+		{"no retries", []int{http.StatusServiceUnavailable}, 0, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, calls := statusServer(tt.statuses...)
+			defer srv.Close()
+			err := newTestWebhook(srv.URL, "", tt.maxRetries).Write(context.Background(), Event{ID: "1", Type: enum.EventCrawlCompleted})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if got := atomic.LoadInt32(calls); got != tt.calls {
+				t.Fatalf("calls = %d, want %d", got, tt.calls)
+			}
+		})
+	}
+}
+
+// 请求头中带有事件信息，重试时事件id不变，签名可以由接收方验证
+func TestWebhookRequest(t *testing.T) {
+	var ids []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		ids = append(ids, r.Header.Get("X-Crawler-Event-Id"))
+		if r.Header.Get("X-Crawler-Event") != enum.EventCrawlCompleted || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("headers = %v", r.Header)
+		}
+		sig := "sha256=" + Sign([]byte("secret"), r.Header.Get("X-Crawler-Timestamp"), body)
+		if r.Header.Get("X-Crawler-Signature") != sig {
+			t.Errorf("signature = %s, want %s", r.Header.Get("X-Crawler-Signature"), sig)
+		}
+		if len(ids) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	if err := newTestWebhook(srv.URL, "secret", 1).Write(context.Background(), Event{ID: "abc", Type: enum.EventCrawlCompleted}); err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] != "abc" || ids[1] != "abc" {
+		t.Fatalf("event ids = %v", ids)
+	}
+}
+
+// 退避期间ctx结束时返回最近一次的错误
+func TestWebhookCancel(t *testing.T) {
+	srv, calls := statusServer(500, 500, 500)
+	defer srv.Close()
+	s := newTestWebhook(srv.URL, "", 3)
+	s.backoff = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Write(ctx, Event{ID: "1", Type: enum.EventCrawlCompleted}); err == nil {
+		t.Fatal("no error after cancel")
+	}
+	if got := atomic.LoadInt32(calls); got != 1 {
+		t.Fatalf("calls = %d, want 1", got)
+	}
+}
@@ -0,0 +1,87 @@
+package export
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+var (
+	pageHeader = []string{"url", "domain", "state", "remark", "fetched_at", "depth", "storage_path", "title", "description", "language", "text_path", "job"}
+	edgeHeader = []string{"source", "target"}
+)
+
+type csvFile struct {
+	f *os.File
+	w *csv.Writer
+}
+
+func createCSVFile(path string, header []string) (*csvFile, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := csv.NewWriter(f)
+	if err = w.Write(header); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &csvFile{f: f, w: w}, nil
+}
+
+func (c *csvFile) Close() error {
+	c.w.Flush()
+	if err := c.w.Error(); err != nil {
+		c.f.Close()
+		return err
+	}
+	return c.f.Close()
+}
+
+// CSVWriter 带表头，时间为RFC3339格式，未抓取时为空
+type CSVWriter struct {
+	pages *csvFile
+	edges *csvFile
+}
+
+func NewCSVWriter(dir string, name string) (Writer, error) {
+	pages, err := createCSVFile(filePath(dir, "pages", name, "csv"), pageHeader)
+	if err != nil {
+		return nil, err
+	}
+	edges, err := createCSVFile(filePath(dir, "edges", name, "csv"), edgeHeader)
+	if err != nil {
+		pages.Close()
+		return nil, err
+	}
+	return &CSVWriter{pages: pages, edges: edges}, nil
+}
+
+func (w *CSVWriter) WritePage(r PageRecord) error {
+	var fetchedAt string
+	if !r.FetchedAt.IsZero() {
+		fetchedAt = r.FetchedAt.Format(time.RFC3339)
+	}
+	return w.pages.w.Write([]string{
+		r.URL, r.Domain, r.State, r.Remark, fetchedAt,
+		strconv.Itoa(int(r.Depth)), r.StoragePath, r.Title, r.Description, r.Language, r.TextPath, r.Job,
+	})
+}
+
+func (w *CSVWriter) WriteEdge(r EdgeRecord) error {
+	return w.edges.w.Write([]string{r.Source, r.Target})
+}
+
+func (w *CSVWriter) Close() error {
+	err := w.pages.Close()
+	if e := w.edges.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+func filePath(dir string, table string, name string, ext string) string {
+	return filepath.Join(dir, table+"-"+name+"."+ext)
+}
@@ -0,0 +1,148 @@
+// 将抓取结果（页面及链接关系）导出为jsonl/csv/parquet文件
+// 页面按(updated_at, id)顺序遍历，配合checkpoint可以增量导出上一次之后新增或变化的页面
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/andrewyi/crawler/src/dbstorage"
+	"github.com/andrewyi/crawler/src/dbstorage/schema"
+	"github.com/andrewyi/crawler/src/enum"
+	"github.com/andrewyi/crawler/src/util"
+)
+
+const batchSize = 1000
+
+type PageRecord struct {
+	URL         string    `json:"url"`
+	Domain      string    `json:"domain"`
+	State       string    `json:"state"`
+	Remark      string    `json:"remark"`
+	FetchedAt   time.Time `json:"fetched_at"`
+	Depth       uint8     `json:"depth"`
+	StoragePath string    `json:"storage_path"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Language    string    `json:"language"`
+	TextPath    string    `json:"text_path"`
+	Job         string    `json:"job"`
+}
+
+type EdgeRecord struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// Writer 页面与链接关系分别写入两个文件
+type Writer interface {
+	WritePage(PageRecord) error
+	WriteEdge(EdgeRecord) error
+	Close() error
+}
+
+// 在dir中创建 pages-{name}.{format} 及 edges-{name}.{format}
+func NewWriter(format string, dir string, name string) (Writer, error) {
+	switch format {
+	case enum.ExportFormatJSONL:
+		return NewJSONLWriter(dir, name)
+	case enum.ExportFormatCSV:
+		return NewCSVWriter(dir, name)
+	case enum.ExportFormatParquet:
+		return NewParquetWriter(dir, name)
+	default:
+		return nil, fmt.Errorf("unknown export format: %s", format)
+	}
+}
+
+type Stats struct {
+	Pages int
+	Edges int
+}
+
+// 导出from之后变化、且满足filter的页面，返回新的checkpoint
+// 仅导出开始之前发生的变化，导出过程中被修改的页面留给下一次
+// updated_at只精确到秒，同一秒内之后更新的页面id可能小于checkpoint中的id，
+// 因此只导出到上一个完整的秒为止，保证checkpoint所在的秒不会再有新的变化
+func Export(dbStorage *dbstorage.SimpleDBStorage, w Writer, filter dbstorage.PageFilter, from Checkpoint) (Checkpoint, Stats, error) {
+	var (
+		cp    = from
+		stats Stats
+		until = time.Now().Truncate(time.Second).Add(-time.Second)
+	)
+	for {
+		t, err := dbStorage.NewTransaction()
+		if err != nil {
+			return cp, stats, err
+		}
+		pages, err := t.GetPagesUpdatedAfter(cp.UpdatedAt, cp.ID, until, filter, batchSize)
+		t.Close()
+		if err != nil {
+			return cp, stats, err
+		}
+
+		for _, p := range pages {
+			if err = w.WritePage(newPageRecord(p)); err != nil {
+				return cp, stats, err
+			}
+			stats.Pages++
+
+			targets, err := Targets(p)
+			if err != nil {
+				return cp, stats, fmt.Errorf("invalid sub urls of %s, err: %w", p.URL, err)
+			}
+			for _, target := range targets {
+				if err = w.WriteEdge(EdgeRecord{Source: p.URL, Target: target}); err != nil {
+					return cp, stats, err
+				}
+				stats.Edges++
+			}
+			cp = Checkpoint{UpdatedAt: p.UpdatedAt, ID: p.ID}
+		}
+
+		if len(pages) < batchSize {
+			return cp, stats, nil
+		}
+	}
+}
+
+func newPageRecord(p *schema.Page) PageRecord {
+	return PageRecord{
+		URL:         p.URL,
+		Domain:      p.Domain,
+		State:       enum.PageStateName(int(p.State)),
+		Remark:      p.Remark,
+		FetchedAt:   p.FetchedAt,
+		Depth:       p.Depth,
+		StoragePath: p.StoragePath,
+		Title:       p.Title,
+		Description: p.Description,
+		Language:    p.Language,
+		TextPath:    p.TextPath,
+		Job:         p.JobID,
+	}
+}
+
+// 页面指向的url，与pages表中的url格式一致，无法解析的url被忽略
+func Targets(p *schema.Page) ([]string, error) {
+	if p.SubURLs == "" {
+		return nil, nil
+	}
+	var subURLs []string
+	if err := json.Unmarshal([]byte(p.SubURLs), &subURLs); err != nil {
+		return nil, err
+	}
+
+	var seen = make(map[string]bool)
+	var targets []string
+	for _, u := range subURLs {
+		nURL, err := util.ShortifyURL(u)
+		if err != nil || seen[nURL] {
+			continue
+		}
+		seen[nURL] = true
+		targets = append(targets, nURL)
+	}
+	return targets, nil
+}
@@ -0,0 +1,65 @@
+package export
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+)
+
+type jsonlFile struct {
+	f   *os.File
+	buf *bufio.Writer
+	enc *json.Encoder
+}
+
+func createJSONLFile(path string) (*jsonlFile, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	buf := bufio.NewWriter(f)
+	return &jsonlFile{f: f, buf: buf, enc: json.NewEncoder(buf)}, nil
+}
+
+func (j *jsonlFile) Close() error {
+	if err := j.buf.Flush(); err != nil {
+		j.f.Close()
+		return err
+	}
+	return j.f.Close()
+}
+
+// JSONLWriter 每行一个json对象
+type JSONLWriter struct {
+	pages *jsonlFile
+	edges *jsonlFile
+}
+
+func NewJSONLWriter(dir string, name string) (Writer, error) {
+	pages, err := createJSONLFile(filePath(dir, "pages", name, "jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	edges, err := createJSONLFile(filePath(dir, "edges", name, "jsonl"))
+	if err != nil {
+		pages.Close()
+		return nil, err
+	}
+	return &JSONLWriter{pages: pages, edges: edges}, nil
+}
+
+func (w *JSONLWriter) WritePage(r PageRecord) error {
+	return w.pages.enc.Encode(r)
+}
+
+func (w *JSONLWriter) WriteEdge(r EdgeRecord) error {
+	return w.edges.enc.Encode(r)
+}
+
+func (w *JSONLWriter) Close() error {
+	err := w.pages.Close()
+	if e := w.edges.Close(); err == nil {
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,107 @@
+package export
+
+import (
+	"os"
+
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// 写入parquet的并发度
+const parquetParallel = 4
+
+type parquetPage struct {
+	URL         string `parquet:"name=url, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	Domain      string `parquet:"name=domain, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	State       string `parquet:"name=state, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	Remark      string `parquet:"name=remark, type=UTF8"`
+	FetchedAt   int64  `parquet:"name=fetched_at, type=TIMESTAMP_MILLIS"` // 未抓取时为0
+	Depth       int32  `parquet:"name=depth, type=INT32"`
+	StoragePath string `parquet:"name=storage_path, type=UTF8"`
+	Title       string `parquet:"name=title, type=UTF8"`
+	Description string `parquet:"name=description, type=UTF8"`
+	Language    string `parquet:"name=language, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	TextPath    string `parquet:"name=text_path, type=UTF8"`
+	Job         string `parquet:"name=job, type=UTF8, encoding=PLAIN_DICTIONARY"`
+}
+
+type parquetEdge struct {
+	Source string `parquet:"name=source, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	Target string `parquet:"name=target, type=UTF8, encoding=PLAIN_DICTIONARY"`
+}
+
+type parquetFile struct {
+	f  *os.File
+	pw *writer.ParquetWriter
+}
+
+func createParquetFile(path string, schema interface{}) (*parquetFile, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	pw, err := writer.NewParquetWriterFromWriter(f, schema, parquetParallel)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &parquetFile{f: f, pw: pw}, nil
+}
+
+func (p *parquetFile) Close() error {
+	if err := p.pw.WriteStop(); err != nil {
+		p.f.Close()
+		return err
+	}
+	return p.f.Close()
+}
+
+type ParquetWriter struct {
+	pages *parquetFile
+	edges *parquetFile
+}
+
+func NewParquetWriter(dir string, name string) (Writer, error) {
+	pages, err := createParquetFile(filePath(dir, "pages", name, "parquet"), new(parquetPage))
+	if err != nil {
+		return nil, err
+	}
+	edges, err := createParquetFile(filePath(dir, "edges", name, "parquet"), new(parquetEdge))
+	if err != nil {
+		pages.Close()
+		return nil, err
+	}
+	return &ParquetWriter{pages: pages, edges: edges}, nil
+}
+
+func (w *ParquetWriter) WritePage(r PageRecord) error {
+	var fetchedAt int64
+	if !r.FetchedAt.IsZero() {
+		fetchedAt = r.FetchedAt.UnixNano() / 1e6
+	}
+	return w.pages.pw.Write(parquetPage{
+		URL:         r.URL,
+		Domain:      r.Domain,
+		State:       r.State,
+		Remark:      r.Remark,
+		FetchedAt:   fetchedAt,
+		Depth:       int32(r.Depth),
+		StoragePath: r.StoragePath,
+		Title:       r.Title,
+		Description: r.Description,
+		Language:    r.Language,
+		TextPath:    r.TextPath,
+		Job:         r.Job,
+	})
+}
+
+func (w *ParquetWriter) WriteEdge(r EdgeRecord) error {
+	return w.edges.pw.Write(parquetEdge{Source: r.Source, Target: r.Target})
+}
+
+func (w *ParquetWriter) Close() error {
+	err := w.pages.Close()
+	if e := w.edges.Close(); err == nil {
+		err = e
+	}
+	return err
+}
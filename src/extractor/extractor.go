@@ -0,0 +1,12 @@
+package extractor
+
+import (
+	"github.com/PuerkitoBio/goquery"
+
+	"github.com/andrewyi/crawler/src/entity"
+)
+
+// Extractor 从html中提取正文及元信息，调用方需要保证doc不再被其他逻辑使用（提取过程会修改doc）
+type Extractor interface {
+	Extract(*goquery.Document) entity.Extracted
+}
@@ -0,0 +1,284 @@
+// 参考readability的正文提取：
+// 1. 移除脚本、导航、页脚等明显不属于正文的元素，以及class/id明显为广告、评论、分享等的元素
+// 2. 为每个足够长的段落打分（逗号数量、长度），分数累加到父节点及祖父节点
+// 3. 候选节点的分数乘以(1-链接密度)，取分数最高者，并合并分数相近的兄弟节点作为正文
+package extractor
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"github.com/andrewyi/crawler/src/entity"
+)
+
+const (
+	// 少于此长度的段落不参与打分
+	minParagraphLen = 25
+	// 最多保留的标题数量
+	maxHeadings = 50
+)
+
+var (
+	unlikelyPattern = regexp.MustCompile(`(?i)banner|breadcrumb|combx|comment|community|cookie|disqus|extra|footer|header|menu|modal|nav|popup|related|remark|rss|share|shoutbox|sidebar|skyscraper|social|sponsor|subscribe|ad-break|agegate|pagination|pager`)
+	maybePattern    = regexp.MustCompile(`(?i)and|article|body|column|content|main|shadow`)
+	positivePattern = regexp.MustCompile(`(?i)article|body|content|entry|hentry|h-entry|main|page|post|text|blog|story`)
+	negativePattern = regexp.MustCompile(`(?i)hidden|banner|combx|comment|com-|contact|foot|footer|footnote|masthead|media|meta|outbrain|promo|related|scroll|share|shoutbox|sidebar|skyscraper|sponsor|shopping|tags|tool|widget`)
+
+	spacePattern = regexp.MustCompile(`[ \t\r\f\v]+`)
+)
+
+type ReadabilityExtractor struct{}
+
+func NewReadabilityExtractor() Extractor {
+	return &ReadabilityExtractor{}
+}
+
+func (r *ReadabilityExtractor) Extract(doc *goquery.Document) entity.Extracted {
+	var e = entity.Extracted{
+		Title:       extractTitle(doc),
+		Description: extractDescription(doc),
+		Language:    extractLanguage(doc),
+	}
+
+	doc.Find("script, style, noscript, template, iframe, svg, form, nav, footer, aside").Remove()
+	removeUnlikely(doc)
+
+	doc.Find("h1, h2, h3").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		if text := collapse(s.Text()); text != "" {
+			e.Headings = append(e.Headings, text)
+		}
+		return len(e.Headings) < maxHeadings
+	})
+
+	e.Text = mainText(doc)
+	return e
+}
+
+// og:title优先（通常不含站点名），其次为title标签及第一个h1
+func extractTitle(doc *goquery.Document) string {
+	if v, ok := doc.Find(`meta[property="og:title"]`).First().Attr("content"); ok && strings.TrimSpace(v) != "" {
+		return collapse(v)
+	}
+	if v := collapse(doc.Find("title").First().Text()); v != "" {
+		return v
+	}
+	return collapse(doc.Find("h1").First().Text())
+}
+
+func extractDescription(doc *goquery.Document) string {
+	for _, selector := range []string{`meta[name="description"]`, `meta[property="og:description"]`} {
+		if v, ok := doc.Find(selector).First().Attr("content"); ok && strings.TrimSpace(v) != "" {
+			return collapse(v)
+		}
+	}
+	return ""
+}
+
+// 仅使用页面声明的语言，不做语言检测
+func extractLanguage(doc *goquery.Document) string {
+	if v, ok := doc.Find("html").First().Attr("lang"); ok && strings.TrimSpace(v) != "" {
+		return strings.TrimSpace(v)
+	}
+	var lang string
+	doc.Find("meta[http-equiv]").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		if v, _ := s.Attr("http-equiv"); strings.EqualFold(v, "content-language") {
+			v, _ = s.Attr("content")
+			lang = strings.TrimSpace(strings.Split(v, ",")[0])
+		}
+		return lang == ""
+	})
+	if lang != "" {
+		return lang
+	}
+	if v, ok := doc.Find(`meta[property="og:locale"]`).First().Attr("content"); ok {
+		return strings.TrimSpace(v)
+	}
+	return ""
+}
+
+func removeUnlikely(doc *goquery.Document) {
+	doc.Find("body *").Each(func(i int, s *goquery.Selection) {
+		if s.Is("a, body, article, main") {
+			return
+		}
+		id, _ := s.Attr("id")
+		class, _ := s.Attr("class")
+		match := class + " " + id
+		if unlikelyPattern.MatchString(match) && !maybePattern.MatchString(match) {
+			s.Remove()
+		}
+	})
+}
+
+func mainText(doc *goquery.Document) string {
+	var scores = make(map[*html.Node]float64)
+	var candidates []*html.Node
+
+	addScore := func(n *html.Node, score float64) {
+		if n == nil || n.Type != html.ElementNode {
+			return
+		}
+		if _, ok := scores[n]; !ok {
+			scores[n] = initialScore(n)
+			candidates = append(candidates, n)
+		}
+		scores[n] += score
+	}
+
+	doc.Find("p, pre, td, blockquote").Each(func(i int, s *goquery.Selection) {
+		text := collapse(s.Text())
+		length := utf8.RuneCountInString(text)
+		if length < minParagraphLen {
+			return
+		}
+		// 1分基础分，每个逗号（含中文逗号）1分，每100个字符1分，最多3分
+		score := 1 + float64(strings.Count(text, ",")+strings.Count(text, "，"))
+		if bonus := float64(length / 100); bonus < 3 {
+			score += bonus
+		} else {
+			score += 3
+		}
+		parent := s.Nodes[0].Parent
+		addScore(parent, score)
+		if parent != nil {
+			addScore(parent.Parent, score/2)
+		}
+	})
+
+	var top *html.Node
+	var topScore float64
+	for _, n := range candidates {
+		scores[n] *= 1 - linkDensity(goquery.NewDocumentFromNode(n).Selection)
+		if top == nil || scores[n] > topScore {
+			top, topScore = n, scores[n]
+		}
+	}
+	if top == nil {
+		// 没有足够长的段落，退化为整个body的文本
+		return blockText(doc.Find("body").Nodes...)
+	}
+
+	// 合并分数相近的兄弟节点，以及链接较少的长段落
+	threshold := topScore * 0.2
+	if threshold < 10 {
+		threshold = 10
+	}
+	var nodes []*html.Node
+	for sib := firstSibling(top); sib != nil; sib = sib.NextSibling {
+		if sib.Type != html.ElementNode {
+			continue
+		}
+		if sib == top {
+			nodes = append(nodes, sib)
+			continue
+		}
+		if score, ok := scores[sib]; ok && score >= threshold {
+			nodes = append(nodes, sib)
+			continue
+		}
+		if sib.DataAtom == atom.P {
+			s := goquery.NewDocumentFromNode(sib).Selection
+			length := utf8.RuneCountInString(collapse(s.Text()))
+			if length > 80 && linkDensity(s) < 0.25 {
+				nodes = append(nodes, sib)
+			}
+		}
+	}
+	return blockText(nodes...)
+}
+
+func initialScore(n *html.Node) float64 {
+	var score float64
+	switch n.DataAtom {
+	case atom.Div, atom.Article, atom.Main:
+		score = 5
+	case atom.Pre, atom.Td, atom.Blockquote:
+		score = 3
+	case atom.Address, atom.Ol, atom.Ul, atom.Dl, atom.Dd, atom.Dt, atom.Li:
+		score = -3
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6, atom.Th:
+		score = -5
+	}
+	for _, attr := range n.Attr {
+		if attr.Key != "class" && attr.Key != "id" {
+			continue
+		}
+		if negativePattern.MatchString(attr.Val) {
+			score -= 25
+		}
+		if positivePattern.MatchString(attr.Val) {
+			score += 25
+		}
+	}
+	return score
+}
+
+// 链接文本占全部文本的比例
+func linkDensity(s *goquery.Selection) float64 {
+	length := utf8.RuneCountInString(collapse(s.Text()))
+	if length == 0 {
+		return 0
+	}
+	var linkLength int
+	s.Find("a").Each(func(i int, a *goquery.Selection) {
+		linkLength += utf8.RuneCountInString(collapse(a.Text()))
+	})
+	return float64(linkLength) / float64(length)
+}
+
+func firstSibling(n *html.Node) *html.Node {
+	if n.Parent == nil {
+		return n
+	}
+	return n.Parent.FirstChild
+}
+
+var blockAtoms = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Br: true, atom.Li: true, atom.Tr: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Pre: true, atom.Blockquote: true, atom.Article: true, atom.Section: true,
+	atom.Table: true, atom.Ul: true, atom.Ol: true, atom.Dd: true, atom.Dt: true,
+}
+
+// 块级元素之间换行，合并行内空白并去除空行
+func blockText(nodes ...*html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			b.WriteString(n.Data)
+			return
+		case html.ElementNode:
+			if blockAtoms[n.DataAtom] {
+				b.WriteByte('\n')
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if n.Type == html.ElementNode && blockAtoms[n.DataAtom] {
+			b.WriteByte('\n')
+		}
+	}
+	for _, n := range nodes {
+		walk(n)
+	}
+
+	var lines []string
+	for _, line := range strings.Split(b.String(), "\n") {
+		if line = collapse(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func collapse(s string) string {
+	return strings.TrimSpace(spacePattern.ReplaceAllString(strings.Replace(s, "\n", " ", -1), " "))
+}
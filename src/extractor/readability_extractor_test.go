@@ -0,0 +1,141 @@
+package extractor
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func parse(t *testing.T, body string) *goquery.Document {
+	t.Helper()
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestExtractTitle(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{"og title first", `<head><meta property="og:title" content=" Story "><title>Story - Site</title></head><body><h1>Heading</h1></body>`, "Story"},
+		{"blank og title", `<head><meta property="og:title" content="  "><title>Story - Site</title></head>`, "Story - Site"},
+		{"title tag", `<head><title>
+			Story   - Site</title></head><body><h1>Heading</h1></body>`, "Story - Site"},
+		{"first h1", `<body><h1>Heading</h1><h1>Second</h1></body>`, "Heading"},
+		{"none", `<body><p>text</p></body>`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractTitle(parse(t, tt.html)); got != tt.want {
+				t.Fatalf("title = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractDescription(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{"meta description first", `<meta property="og:description" content="og"><meta name="description" content="meta">`, "meta"},
+		{"blank meta description", `<meta name="description" content=" "><meta property="og:description" content="og">`, "og"},
+		{"none", `<title>x</title>`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractDescription(parse(t, tt.html)); got != tt.want {
+				t.Fatalf("description = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractLanguage(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{"html lang first", `<html lang=" zh-CN "><head><meta http-equiv="Content-Language" content="en"></head></html>`, "zh-CN"},
+		{"content-language", `<html><head><meta http-equiv="refresh" content="30"><meta http-equiv="content-language" content="de, en"><meta property="og:locale" content="fr_FR"></head></html>`, "de"},
+		{"og locale", `<html><head><meta property="og:locale" content="fr_FR"></head></html>`, "fr_FR"},
+		{"none", `<html><body></body></html>`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractLanguage(parse(t, tt.html)); got != tt.want {
+				t.Fatalf("language = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+const articlePage = `<html lang="en"><head><title>Story</title></head><body>
+<nav><a href="/">Home</a> <a href="/news">News</a></nav>
+<div class="header"><h1>Site name</h1></div>
+<div class="sidebar"><p>Popular posts, trending stories, and other links that should not be part of the text.</p></div>
+<div class="article-body">
+  <h2>Story heading</h2>
+  <p>The first paragraph of the story, which is long enough to count, and has a few commas, too.</p>
+  <p>The second paragraph continues the story, adding detail, context, and quotes from people.</p>
+</div>
+<div class="links"><p><a href="/a">A link list that is long enough to be scored as a paragraph</a></p></div>
+<footer><p>Copyright notice, terms of use, privacy policy and contact information for the site.</p></footer>
+<script>var x = "script text should never show up in the extracted text at all";</script>
+</body></html>`
+
+func TestExtract(t *testing.T) {
+	e := NewReadabilityExtractor().Extract(parse(t, articlePage))
+	if e.Title != "Story" || e.Language != "en" {
+		t.Fatalf("title %q language %q", e.Title, e.Language)
+	}
+	want := "Story heading\n" +
+		"The first paragraph of the story, which is long enough to count, and has a few commas, too.\n" +
+		"The second paragraph continues the story, adding detail, context, and quotes from people."
+	if e.Text != want {
+		t.Fatalf("text = %q, want %q", e.Text, want)
+	}
+	// 导航、页头等被移除的元素中的标题不保留
+	if !reflect.DeepEqual(e.Headings, []string{"Story heading"}) {
+		t.Fatalf("headings = %q", e.Headings)
+	}
+}
+
+// 没有足够长的段落时退化为body的全部文本
+func TestExtractShortPage(t *testing.T) {
+	e := NewReadabilityExtractor().Extract(parse(t, `<body><div>Hello</div><div>world <b>again</b></div><script>x()</script></body>`))
+	if e.Text != "Hello\nworld again" {
+		t.Fatalf("text = %q", e.Text)
+	}
+}
+
+// 分数相近的兄弟节点以及链接较少的长段落与正文合并
+func TestMainTextSiblings(t *testing.T) {
+	long := strings.Repeat("word ", 20)
+	page := `<body><div id="wrap">
+<div class="content"><p>` + long + `one, two, three.</p><p>` + long + `four, five.</p></div>
+<p>` + long + `a standalone paragraph between the content blocks.</p>
+<div class="content"><p>` + long + `six, seven, eight.</p></div>
+<p><a href="/x">` + long + `a paragraph made only of a link.</a></p>
+<p>short</p>
+</div></body>`
+	text := mainText(parse(t, page))
+	for _, s := range []string{"one, two, three.", "four, five.", "standalone paragraph", "six, seven, eight."} {
+		if !strings.Contains(text, s) {
+			t.Errorf("text missing %q: %q", s, text)
+		}
+	}
+	for _, s := range []string{"made only of a link", "short"} {
+		if strings.Contains(text, s) {
+			t.Errorf("text contains %q: %q", s, text)
+		}
+	}
+}
@@ -5,5 +5,6 @@ import (
 )
 
 type FileStorage interface {
-	Store(string, entity.ParsedPageInfo) error
+	// 返回内容的存储路径
+	Store(string, entity.ParsedPageInfo) (string, error)
 }
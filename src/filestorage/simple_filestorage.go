@@ -24,29 +24,29 @@ func NewSimpleFileStorage(ctx context.Context, location string) FileStorage {
 
 // 以domain作为sharding key来建立文件夹，防止单一文件夹中包含文件数量过多
 // 可以将dir的获取作为函数，以创建更加复杂的sharding逻辑
-func (s *SimpleFileStorage) Store(domain string, parsedPage entity.ParsedPageInfo) error {
+func (s *SimpleFileStorage) Store(domain string, parsedPage entity.ParsedPageInfo) (string, error) {
 	dir := filepath.Join(s.location, domain)
 	err := os.MkdirAll(dir, os.ModePerm)
 	if err != nil {
 		if os.IsExist(err) {
 			err = nil // ignore
 		} else {
-			return err
+			return "", err
 		}
 	}
 
 	fileName, err := util.ShortifyURL(parsedPage.URL)
 	if err != nil {
-		return err
+		return "", err
 	}
 	fp := filepath.Join(dir, fileName)
 
 	f, err := os.Create(fp)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer f.Close()
 
 	_, err = f.WriteString(parsedPage.Content)
-	return err
+	return fp, err
 }
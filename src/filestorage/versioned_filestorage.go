@@ -0,0 +1,70 @@
+package filestorage
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+
+	"github.com/andrewyi/crawler/src/entity"
+	"github.com/andrewyi/crawler/src/util"
+)
+
+// VersionedFileStorage 保存页面内容的每一个版本，不会覆盖之前的内容
+// 每个url一个文件夹，文件名为内容的sha1，相同的内容只保存一份，因此同样可以安全重试
+type VersionedFileStorage struct {
+	ctx      context.Context
+	location string
+}
+
+func NewVersionedFileStorage(ctx context.Context, location string) *VersionedFileStorage {
+	return &VersionedFileStorage{
+		ctx:      ctx,
+		location: location,
+	}
+}
+
+// 内容的sha1，用于判断内容是否变化
+func ContentHash(content string) string {
+	sum := sha1.Sum([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// 同样以domain作为sharding key，url的hash作为文件夹名称，避免url中的特殊字符
+// 路径只由url及内容决定，因此可以在写入之前得到
+func (s *VersionedFileStorage) Path(domain string, parsedPage entity.ParsedPageInfo) (string, error) {
+	nURL, err := util.ShortifyURL(parsedPage.URL)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.location, domain, ContentHash(nURL), ContentHash(parsedPage.Content)), nil
+}
+
+func (s *VersionedFileStorage) Store(domain string, parsedPage entity.ParsedPageInfo) (string, error) {
+	fp, err := s.Path(domain, parsedPage)
+	if err != nil {
+		return "", err
+	}
+	if err = os.MkdirAll(filepath.Dir(fp), os.ModePerm); err != nil {
+		return "", err
+	}
+	if _, err = os.Stat(fp); err == nil { // 相同的内容已经存在
+		return fp, nil
+	}
+
+	// 先写入临时文件再重命名，避免中途失败时留下不完整的文件被当作已经存在
+	tmp := fp + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return "", err
+	}
+	if _, err = f.WriteString(parsedPage.Content); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err = f.Close(); err != nil {
+		return "", err
+	}
+	return fp, os.Rename(tmp, fp)
+}
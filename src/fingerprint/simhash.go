@@ -0,0 +1,116 @@
+// 基于SimHash的内容指纹，用于检测近似重复的页面
+// 64位指纹被切分为Bands个16位的band，两个指纹的汉明距离不超过Bands-1时至少有一个band完全相同
+// 因此可以通过band上的等值索引查找候选，再计算汉明距离确认
+package fingerprint
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"strings"
+	"unicode"
+)
+
+const (
+	Bands    = 4
+	bandBits = 64 / Bands
+
+	// 每个特征由连续的shingleSize个词组成
+	shingleSize = 3
+
+	// 通过band索引能够保证找到的最大汉明距离
+	MaxDistance = Bands - 1
+)
+
+// text为空时返回0，0表示没有指纹
+func SimHash(text string) uint64 {
+	tokens := tokenize(text)
+	if len(tokens) == 0 {
+		return 0
+	}
+
+	var weights [64]int
+	add := func(feature string) {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(feature))
+		sum := h.Sum64()
+		for i := 0; i < 64; i++ {
+			if sum&(1<<uint(i)) != 0 {
+				weights[i]++
+			} else {
+				weights[i]--
+			}
+		}
+	}
+
+	if len(tokens) < shingleSize {
+		add(strings.Join(tokens, " "))
+	}
+	for i := 0; i+shingleSize <= len(tokens); i++ {
+		add(strings.Join(tokens[i:i+shingleSize], " "))
+	}
+
+	var hash uint64
+	for i, w := range weights {
+		if w > 0 {
+			hash |= 1 << uint(i)
+		}
+	}
+	// 极端情况下所有位均为0，避免与"没有指纹"混淆
+	if hash == 0 {
+		hash = 1
+	}
+	return hash
+}
+
+func Distance(a uint64, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// 两个指纹至少有一个band相同时，才会被band索引查找为候选
+func SharesBand(a uint64, b uint64) bool {
+	x, y := SplitBands(a), SplitBands(b)
+	for i := range x {
+		if x[i] == y[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// 是否为近似重复：通过band索引能够找到（至少一个band相同），并且汉明距离不超过maxDistance
+func Near(a uint64, b uint64, maxDistance int) bool {
+	return SharesBand(a, b) && Distance(a, b) <= maxDistance
+}
+
+func SplitBands(hash uint64) [Bands]int32 {
+	var bands [Bands]int32
+	for i := range bands {
+		bands[i] = int32((hash >> uint(i*bandBits)) & (1<<bandBits - 1))
+	}
+	return bands
+}
+
+// 英文等以字母、数字组成的词为单位，中日韩文字以单个字符为单位，并统一转为小写
+func tokenize(text string) []string {
+	var tokens []string
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+	for _, r := range text {
+		switch {
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+			flush()
+			tokens = append(tokens, string(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word.WriteRune(unicode.ToLower(r))
+		default:
+			flush()
+		}
+	}
+	flush()
+	return tokens
+}
@@ -0,0 +1,95 @@
+package fingerprint
+
+import (
+	"testing"
+)
+
+// 按顺序翻转指定的位
+func flip(hash uint64, positions ...int) uint64 {
+	for _, p := range positions {
+		hash ^= 1 << uint(p)
+	}
+	return hash
+}
+
+func TestSplitBands(t *testing.T) {
+	const hash = 0xFFFF_0001_8000_1234
+	bands := SplitBands(hash)
+	want := [Bands]int32{0x1234, 0x8000, 0x0001, 0xFFFF}
+	if bands != want {
+		t.Fatalf("SplitBands(%x) = %x, want %x", uint64(hash), bands, want)
+	}
+
+	var joined uint64
+	for i, b := range bands {
+		if b < 0 || b >= 1<<bandBits {
+			t.Fatalf("band %d = %d out of range", i, b)
+		}
+		joined |= uint64(b) << uint(i*bandBits)
+	}
+	if joined != hash {
+		t.Fatalf("bands join to %x, want %x", joined, uint64(hash))
+	}
+}
+
+// 汉明距离不超过MaxDistance时一定有一个band相同，即一定能通过band索引找到
+func TestSharesBandWithinMaxDistance(t *testing.T) {
+	const base = 0x0123_4567_89AB_CDEF
+	for i := 0; i < 64; i++ {
+		for j := i + 1; j < 64; j++ {
+			for k := j + 1; k < 64; k++ {
+				h := flip(base, i, j, k)
+				if Distance(base, h) != MaxDistance || !SharesBand(base, h) {
+					t.Fatalf("flip %d %d %d: distance %d, shares band %v", i, j, k, Distance(base, h), SharesBand(base, h))
+				}
+			}
+		}
+	}
+}
+
+func TestNear(t *testing.T) {
+	const base = 0x0123_4567_89AB_CDEF
+	tests := []struct {
+		name        string
+		other       uint64
+		maxDistance int
+		sharesBand  bool
+		near        bool
+	}{
+		{"identical", base, 0, true, true},
+		{"distance equals threshold", flip(base, 0, 20, 40), 3, true, true},
+		{"distance above threshold", flip(base, 0, 20, 40), 2, true, false},
+		{"same band at distance 1", flip(base, 63), 0, true, false},
+		// 每个band各翻转一位，距离为4且没有相同的band，超出band索引的保证范围
+		{"one flip per band", flip(base, 0, 16, 32, 48), MaxDistance + 1, false, false},
+		// band相同（索引命中）但其余位完全不同，需要通过汉明距离排除
+		{"band collision far away", base ^ 0xFFFF_FFFF_FFFF_0000, MaxDistance, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SharesBand(base, tt.other); got != tt.sharesBand {
+				t.Fatalf("SharesBand = %v, want %v", got, tt.sharesBand)
+			}
+			if got := Near(base, tt.other, tt.maxDistance); got != tt.near {
+				t.Fatalf("Near(distance %d, max %d) = %v, want %v", Distance(base, tt.other), tt.maxDistance, got, tt.near)
+			}
+		})
+	}
+}
+
+func TestSimHash(t *testing.T) {
+	if h := SimHash("  ,. "); h != 0 {
+		t.Fatalf("SimHash of text without tokens = %x, want 0", h)
+	}
+	a := SimHash("The quick brown fox jumps over the lazy dog")
+	if a == 0 {
+		t.Fatal("SimHash returned 0 for non-empty text")
+	}
+	// 大小写及标点不影响分词
+	if b := SimHash("the QUICK brown, fox jumps over... the lazy dog!"); b != a {
+		t.Fatalf("SimHash differs after case and punctuation changes: %x vs %x", a, b)
+	}
+	if c := SimHash("中文内容的指纹"); c == 0 || c == SimHash("完全不同的文字") {
+		t.Fatalf("unexpected SimHash of cjk text: %x", c)
+	}
+}
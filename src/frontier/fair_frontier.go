@@ -0,0 +1,155 @@
+package frontier
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/andrewyi/crawler/src/entity"
+)
+
+// FairFrontier 每个job使用单独的frontier，Pop时在可调度的job之间轮转，从而所有job平分downloader
+// job内部仍然按照scorer计算的优先级出队
+type FairFrontier struct {
+	mu        sync.Mutex
+	frontiers map[string]Frontier
+	create    func(jobID string) (Frontier, error)
+	// 当前可调度的job，按固定顺序返回
+	jobs    func() []string
+	jobless string // url没有job时使用的job
+
+	// 同一时间只有一个Pop在检查各个job，检查到有消息的job之后出队不会阻塞
+	popMu  sync.Mutex
+	cursor int
+	// 容量为1的通知channel，当前进程Push时唤醒等待中的Pop；其他进程的Push（postgres队列）通过轮询发现
+	notify       chan struct{}
+	pollInterval time.Duration
+}
+
+func NewFairFrontier(create func(jobID string) (Frontier, error), jobs func() []string, jobless string, pollInterval time.Duration) Frontier {
+	if pollInterval <= 0 {
+		pollInterval = time.Second
+	}
+	return &FairFrontier{
+		frontiers:    make(map[string]Frontier),
+		create:       create,
+		jobs:         jobs,
+		jobless:      jobless,
+		notify:       make(chan struct{}, 1),
+		pollInterval: pollInterval,
+	}
+}
+
+func (f *FairFrontier) get(jobID string) (Frontier, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if fr, ok := f.frontiers[jobID]; ok {
+		return fr, nil
+	}
+	fr, err := f.create(jobID)
+	if err != nil {
+		return nil, err
+	}
+	f.frontiers[jobID] = fr
+	return fr, nil
+}
+
+func (f *FairFrontier) Push(ctx context.Context, task entity.URLTask) error {
+	if task.JobID == "" {
+		task.JobID = f.jobless
+	}
+	fr, err := f.get(task.JobID)
+	if err != nil {
+		return err
+	}
+	if err = fr.Push(ctx, task); err != nil {
+		return err
+	}
+	select {
+	case f.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// 仅在ctx结束时返回错误，无法创建队列的job被跳过
+func (f *FairFrontier) Pop(ctx context.Context) (entity.URLTask, error) {
+	for {
+		if task, ok := f.next(ctx); ok {
+			return task, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return entity.URLTask{}, err
+		}
+		timer := time.NewTimer(f.pollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return entity.URLTask{}, ctx.Err()
+		case <-f.notify:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// 从上一次出队的job的下一个开始轮转，所有job都没有消息时返回false
+func (f *FairFrontier) next(ctx context.Context) (entity.URLTask, bool) {
+	f.popMu.Lock()
+	defer f.popMu.Unlock()
+
+	jobs := f.jobs()
+	for i := 0; i < len(jobs); i++ {
+		idx := (f.cursor + i) % len(jobs)
+		fr, err := f.get(jobs[idx])
+		if err != nil {
+			continue
+		}
+		if n, err := fr.Len(); err != nil || n == 0 {
+			continue
+		}
+		// 跨进程共享的队列中，消息可能在检查之后被其他进程取走，因此仍然需要限制等待时间
+		popCtx, cancel := context.WithTimeout(ctx, f.pollInterval)
+		task, err := fr.Pop(popCtx)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return entity.URLTask{}, false
+			}
+			continue
+		}
+		f.cursor = idx + 1
+		if task.JobID == "" {
+			task.JobID = jobs[idx]
+		}
+		return task, true
+	}
+	return entity.URLTask{}, false
+}
+
+// 所有已知job的消息数量之和
+func (f *FairFrontier) Len() (int64, error) {
+	var ids = make(map[string]bool)
+	for _, id := range f.jobs() {
+		ids[id] = true
+	}
+	f.mu.Lock()
+	for id := range f.frontiers {
+		ids[id] = true
+	}
+	f.mu.Unlock()
+
+	var total int64
+	for id := range ids {
+		fr, err := f.get(id)
+		if err != nil {
+			return 0, err
+		}
+		n, err := fr.Len()
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
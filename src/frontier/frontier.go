@@ -0,0 +1,14 @@
+package frontier
+
+import (
+	"context"
+
+	"github.com/andrewyi/crawler/src/entity"
+)
+
+// 待下载url的集合，controller写入，downloader每次取出当前优先级最高的url
+type Frontier interface {
+	Push(context.Context, entity.URLTask) error
+	Pop(context.Context) (entity.URLTask, error)
+	Len() (int64, error)
+}
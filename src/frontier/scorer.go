@@ -0,0 +1,10 @@
+package frontier
+
+import (
+	"github.com/andrewyi/crawler/src/entity"
+)
+
+// 计算url的优先级，分数越高越先被下载
+type Scorer interface {
+	Score(entity.URLTask) float64
+}
@@ -0,0 +1,41 @@
+// 在入队时通过scorer计算优先级，实际的存储交给queue，从而可以跨进程共享
+package frontier
+
+import (
+	"context"
+
+	"github.com/andrewyi/crawler/src/entity"
+	"github.com/andrewyi/crawler/src/queue"
+)
+
+type SimpleFrontier struct {
+	queue  queue.Queue
+	scorer Scorer
+}
+
+func NewSimpleFrontier(q queue.Queue, scorer Scorer) Frontier {
+	return &SimpleFrontier{
+		queue:  q,
+		scorer: scorer,
+	}
+}
+
+func (f *SimpleFrontier) Push(ctx context.Context, task entity.URLTask) error {
+	task.Score = f.scorer.Score(task)
+	return queue.PushJSON(ctx, f.queue, task.Score, task)
+}
+
+func (f *SimpleFrontier) Len() (int64, error) {
+	return f.queue.Len()
+}
+
+func (f *SimpleFrontier) Pop(ctx context.Context) (entity.URLTask, error) {
+	var task entity.URLTask
+	for {
+		err := queue.PopJSON(ctx, f.queue, &task)
+		if err == nil || ctx.Err() != nil {
+			return task, err
+		}
+		// 无法反序列化的消息直接丢弃
+	}
+}
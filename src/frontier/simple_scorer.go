@@ -0,0 +1,137 @@
+// 内置的几种scorer，可以通过CompositeScorer进行组合
+// 分数相同的url按照入队顺序下载（即FIFO）
+package frontier
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/andrewyi/crawler/src/entity"
+)
+
+const (
+	ScorerTypeDepth   = "depth"
+	ScorerTypeSeed    = "seed"
+	ScorerTypeKeyword = "keyword"
+
+	KeywordTargetURL    = "url"
+	KeywordTargetAnchor = "anchor"
+	KeywordTargetAll    = "all"
+)
+
+// 深度越小分数越高，即广度优先
+type DepthScorer struct {
+	weight float64
+}
+
+func NewDepthScorer(weight float64) Scorer {
+	return &DepthScorer{weight: weight}
+}
+
+func (s *DepthScorer) Score(task entity.URLTask) float64 {
+	return -s.weight * float64(task.Depth)
+}
+
+// seed url优先
+type SeedScorer struct {
+	weight float64
+}
+
+func NewSeedScorer(weight float64) Scorer {
+	return &SeedScorer{weight: weight}
+}
+
+func (s *SeedScorer) Score(task entity.URLTask) float64 {
+	if task.Seed {
+		return s.weight
+	}
+	return 0
+}
+
+// url或链接文本匹配正则时加分
+type KeywordScorer struct {
+	weight  float64
+	target  string
+	pattern *regexp.Regexp
+}
+
+func NewKeywordScorer(weight float64, target string, pattern string) (Scorer, error) {
+	switch target {
+	case "":
+		target = KeywordTargetAll
+	case KeywordTargetURL, KeywordTargetAnchor, KeywordTargetAll:
+	default:
+		return nil, fmt.Errorf("unknown keyword target: %s", target)
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &KeywordScorer{
+		weight:  weight,
+		target:  target,
+		pattern: re,
+	}, nil
+}
+
+func (s *KeywordScorer) Score(task entity.URLTask) float64 {
+	if s.target != KeywordTargetAnchor && s.pattern.MatchString(task.URL) {
+		return s.weight
+	}
+	if s.target != KeywordTargetURL && s.pattern.MatchString(task.Anchor) {
+		return s.weight
+	}
+	return 0
+}
+
+// 所有scorer分数之和
+type CompositeScorer struct {
+	scorers []Scorer
+}
+
+func NewCompositeScorer(scorers ...Scorer) Scorer {
+	return &CompositeScorer{scorers: scorers}
+}
+
+func (s *CompositeScorer) Score(task entity.URLTask) float64 {
+	var score float64
+	for _, scorer := range s.scorers {
+		score += scorer.Score(task)
+	}
+	return score
+}
+
+// 根据配置创建scorer，未配置任何scorer时使用广度优先
+func NewScorer(configs []ScorerConfig) (Scorer, error) {
+	if len(configs) == 0 {
+		return NewDepthScorer(1), nil
+	}
+
+	var scorers []Scorer
+	for _, c := range configs {
+		switch c.Type {
+		case ScorerTypeDepth:
+			scorers = append(scorers, NewDepthScorer(c.Weight))
+		case ScorerTypeSeed:
+			scorers = append(scorers, NewSeedScorer(c.Weight))
+		case ScorerTypeKeyword:
+			s, err := NewKeywordScorer(c.Weight, c.Target, c.Pattern)
+			if err != nil {
+				return nil, err
+			}
+			scorers = append(scorers, s)
+		default:
+			return nil, fmt.Errorf("unknown scorer type: %s", c.Type)
+		}
+	}
+	return NewCompositeScorer(scorers...), nil
+}
+
+// ScorerConfig 即配置文件中的frontier.scorers
+type ScorerConfig struct {
+	Type    string  `mapstructure:"type"`    // depth/seed/keyword
+	Weight  float64 `mapstructure:"weight"`  // 分数权重
+	Pattern string  `mapstructure:"pattern"` // keyword使用的正则
+	Target  string  `mapstructure:"target"`  // keyword匹配的目标，url/anchor/all
+}
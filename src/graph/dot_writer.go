@@ -0,0 +1,36 @@
+package graph
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+type DOTWriter struct {
+	w *bufio.Writer
+}
+
+func NewDOTWriter(w io.Writer) (Writer, error) {
+	bw := bufio.NewWriter(w)
+	_, err := bw.WriteString("digraph crawl {\n")
+	return &DOTWriter{w: bw}, err
+}
+
+func (d *DOTWriter) WriteNode(n Node) error {
+	_, err := fmt.Fprintf(d.w, "  n%d [label=%s, domain=%s, in_degree=%d, out_degree=%d, pagerank=%g, scc=%d];\n",
+		n.Index, strconv.Quote(n.URL), strconv.Quote(n.Domain), n.InDegree, n.OutDegree, n.PageRank, n.Component)
+	return err
+}
+
+func (d *DOTWriter) WriteEdge(source int32, target int32) error {
+	_, err := fmt.Fprintf(d.w, "  n%d -> n%d;\n", source, target)
+	return err
+}
+
+func (d *DOTWriter) Close() error {
+	if _, err := d.w.WriteString("}\n"); err != nil {
+		return err
+	}
+	return d.w.Flush()
+}
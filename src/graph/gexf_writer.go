@@ -0,0 +1,73 @@
+package graph
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+// GEXFWriter gexf要求nodes与edges分段，第一条边写入时结束nodes段
+type GEXFWriter struct {
+	w       *bufio.Writer
+	inEdges bool
+	edgeID  int
+}
+
+func NewGEXFWriter(w io.Writer) (Writer, error) {
+	bw := bufio.NewWriter(w)
+	_, err := bw.WriteString(xml.Header + `<gexf xmlns="http://www.gexf.net/1.2draft" version="1.2">
+  <graph defaultedgetype="directed">
+    <attributes class="node">
+      <attribute id="0" title="domain" type="string"/>
+      <attribute id="1" title="in_degree" type="integer"/>
+      <attribute id="2" title="out_degree" type="integer"/>
+      <attribute id="3" title="pagerank" type="double"/>
+      <attribute id="4" title="scc" type="integer"/>
+    </attributes>
+    <nodes>
+`)
+	return &GEXFWriter{w: bw}, err
+}
+
+func (g *GEXFWriter) WriteNode(n Node) error {
+	_, err := fmt.Fprintf(g.w, `      <node id="%d" label="%s">
+        <attvalues>
+          <attvalue for="0" value="%s"/>
+          <attvalue for="1" value="%d"/>
+          <attvalue for="2" value="%d"/>
+          <attvalue for="3" value="%g"/>
+          <attvalue for="4" value="%d"/>
+        </attvalues>
+      </node>
+`, n.Index, escapeXML(n.URL), escapeXML(n.Domain), n.InDegree, n.OutDegree, n.PageRank, n.Component)
+	return err
+}
+
+func (g *GEXFWriter) startEdges() error {
+	if g.inEdges {
+		return nil
+	}
+	g.inEdges = true
+	_, err := g.w.WriteString("    </nodes>\n    <edges>\n")
+	return err
+}
+
+func (g *GEXFWriter) WriteEdge(source int32, target int32) error {
+	if err := g.startEdges(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(g.w, "      <edge id=\"%d\" source=\"%d\" target=\"%d\"/>\n", g.edgeID, source, target)
+	g.edgeID++
+	return err
+}
+
+func (g *GEXFWriter) Close() error {
+	if err := g.startEdges(); err != nil {
+		return err
+	}
+	if _, err := g.w.WriteString("    </edges>\n  </graph>\n</gexf>\n"); err != nil {
+		return err
+	}
+	return g.w.Flush()
+}
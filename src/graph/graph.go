@@ -0,0 +1,141 @@
+// 基于抓取结果的链接分析：出入度、PageRank、强连通分量
+// 图以CSR形式保存在内存中，节点及边均使用int32下标，不保存url字符串，以便处理数百万条边
+package graph
+
+import (
+	"hash/fnv"
+
+	"github.com/andrewyi/crawler/src/dbstorage"
+	"github.com/andrewyi/crawler/src/dbstorage/schema"
+	"github.com/andrewyi/crawler/src/export"
+)
+
+// 每批从数据库读取的页面数量
+const scanBatchSize = 1000
+
+// Graph 有向图，节点下标按page id升序分配
+type Graph struct {
+	jobID    string   // 为空时包含所有job的页面
+	ids      []uint64 // 节点对应的page id
+	maxID    uint64   // 加载时的最大page id，重新遍历pages时保证节点顺序一致
+	offsets  []int32  // 节点i的出边为 targets[offsets[i]:offsets[i+1]]
+	targets  []int32
+	inDegree []int32
+}
+
+// 分两次遍历pages：第一次为所有页面分配下标，第二次解析sub_urls得到边
+// 指向pages表之外的url以及自环被忽略；不同job中的同一url只保留一个节点，因此通常按job分别分析
+func Load(dbStorage *dbstorage.SimpleDBStorage, jobID string) (*Graph, error) {
+	t, err := dbStorage.NewTransaction()
+	if err != nil {
+		return nil, err
+	}
+	maxID, err := t.GetMaxPageID()
+	t.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var g = &Graph{jobID: jobID, maxID: maxID}
+	// 使用url的hash作为key，避免在内存中保存所有url
+	var index = make(map[uint64]int32)
+	err = g.scan(dbStorage, func(p *schema.Page) error {
+		index[hashURL(p.URL)] = int32(len(g.ids))
+		g.ids = append(g.ids, p.ID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var sources, targets []int32
+	var source int32
+	err = g.scan(dbStorage, func(p *schema.Page) error {
+		// 两次遍历之间被删除的页面会导致id不连续，跳过对应的节点
+		var ok bool
+		if source, ok = g.seek(source, p.ID); !ok {
+			return nil
+		}
+		urls, err := export.Targets(p)
+		if err != nil {
+			return err
+		}
+		for _, u := range urls {
+			target, ok := index[hashURL(u)]
+			if !ok || target == source {
+				continue
+			}
+			sources = append(sources, source)
+			targets = append(targets, target)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	g.build(sources, targets)
+	return g, nil
+}
+
+// 按page id升序遍历加载时已存在的页面
+func (g *Graph) scan(dbStorage *dbstorage.SimpleDBStorage, fn func(*schema.Page) error) error {
+	if g.maxID == 0 {
+		return nil
+	}
+	filter := dbstorage.PageFilter{State: -1, JobID: g.jobID, MaxID: g.maxID}
+	return dbStorage.ScanPages(filter, scanBatchSize, fn)
+}
+
+// 从下标v开始向后查找id对应的节点，返回新的位置以及是否找到
+func (g *Graph) seek(v int32, id uint64) (int32, bool) {
+	for int(v) < g.NumNodes() && g.ids[v] < id {
+		v++
+	}
+	return v, int(v) < g.NumNodes() && g.ids[v] == id
+}
+
+// 边按source有序，通过计数即可得到CSR
+func (g *Graph) build(sources []int32, targets []int32) {
+	n := len(g.ids)
+	g.offsets = make([]int32, n+1)
+	g.inDegree = make([]int32, n)
+	for i, s := range sources {
+		g.offsets[s+1]++
+		g.inDegree[targets[i]]++
+	}
+	for i := 0; i < n; i++ {
+		g.offsets[i+1] += g.offsets[i]
+	}
+	g.targets = targets
+}
+
+func (g *Graph) NumNodes() int {
+	return len(g.ids)
+}
+
+func (g *Graph) NumEdges() int {
+	return len(g.targets)
+}
+
+func (g *Graph) PageID(v int32) uint64 {
+	return g.ids[v]
+}
+
+func (g *Graph) Neighbors(v int32) []int32 {
+	return g.targets[g.offsets[v]:g.offsets[v+1]]
+}
+
+func (g *Graph) OutDegree(v int32) int32 {
+	return g.offsets[v+1] - g.offsets[v]
+}
+
+func (g *Graph) InDegree(v int32) int32 {
+	return g.inDegree[v]
+}
+
+func hashURL(u string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(u))
+	return h.Sum64()
+}
@@ -0,0 +1,161 @@
+package graph
+
+import (
+	"math"
+	"sort"
+	"testing"
+)
+
+// 构造n个节点的图，节点i的page id为(i+1)*10
+func newTestGraph(n int, edges [][2]int32) *Graph {
+	var g = &Graph{}
+	for i := 0; i < n; i++ {
+		g.ids = append(g.ids, uint64(i+1)*10)
+	}
+	sort.Slice(edges, func(i, j int) bool { return edges[i][0] < edges[j][0] })
+	var sources, targets []int32
+	for _, e := range edges {
+		sources = append(sources, e[0])
+		targets = append(targets, e[1])
+	}
+	g.build(sources, targets)
+	return g
+}
+
+func TestBuild(t *testing.T) {
+	g := newTestGraph(3, [][2]int32{{2, 0}, {0, 1}, {0, 2}})
+	if g.NumNodes() != 3 || g.NumEdges() != 3 {
+		t.Fatalf("nodes %d edges %d", g.NumNodes(), g.NumEdges())
+	}
+	if out := g.Neighbors(0); len(out) != 2 || out[0] != 1 || out[1] != 2 {
+		t.Fatalf("neighbors of 0 = %v", out)
+	}
+	if g.OutDegree(1) != 0 || g.InDegree(0) != 1 || g.InDegree(2) != 1 {
+		t.Fatalf("degree mismatch: out(1)=%d in(0)=%d in(2)=%d", g.OutDegree(1), g.InDegree(0), g.InDegree(2))
+	}
+}
+
+func TestSeek(t *testing.T) {
+	g := newTestGraph(3, nil) // id: 10, 20, 30
+	tests := []struct {
+		from  int32
+		id    uint64
+		want  int32
+		found bool
+	}{
+		{0, 10, 0, true},
+		{0, 30, 2, true},
+		{1, 20, 1, true},
+		{0, 15, 1, false}, // 加载之后才出现的id
+		{2, 40, 3, false},
+	}
+	for _, tt := range tests {
+		v, ok := g.seek(tt.from, tt.id)
+		if v != tt.want || ok != tt.found {
+			t.Errorf("seek(%d, %d) = %d, %v, want %d, %v", tt.from, tt.id, v, ok, tt.want, tt.found)
+		}
+	}
+}
+
+func TestPageRank(t *testing.T) {
+	tests := []struct {
+		name  string
+		n     int
+		edges [][2]int32
+		want  []float64 // nil时只检查总和
+	}{
+		{"empty", 0, nil, nil},
+		{"no edges", 4, nil, []float64{0.25, 0.25, 0.25, 0.25}},
+		{"cycle", 3, [][2]int32{{0, 1}, {1, 2}, {2, 0}}, []float64{1.0 / 3, 1.0 / 3, 1.0 / 3}},
+		// 1没有出边，其分数平均分配给所有节点：r0 = 0.075 + 0.425*r1，r1 = r0 + 0.85*r0
+		{"dangling", 2, [][2]int32{{0, 1}}, []float64{0.3508772, 0.6491228}},
+		{"star", 4, [][2]int32{{1, 0}, {2, 0}, {3, 0}, {0, 1}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGraph(tt.n, tt.edges)
+			rank, iter := PageRank(g, 0.85, 200, 1e-9)
+			if len(rank) != tt.n {
+				t.Fatalf("len = %d, want %d", len(rank), tt.n)
+			}
+			if tt.n == 0 {
+				return
+			}
+			if iter >= 200 {
+				t.Fatalf("not converged after %d iterations", iter)
+			}
+			var sum float64
+			for _, r := range rank {
+				sum += r
+			}
+			if math.Abs(sum-1) > 1e-6 {
+				t.Fatalf("sum = %v, want 1", sum)
+			}
+			for i, w := range tt.want {
+				if math.Abs(rank[i]-w) > 1e-6 {
+					t.Fatalf("rank = %v, want %v", rank, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// 达到maxIter时停止
+func TestPageRankMaxIter(t *testing.T) {
+	g := newTestGraph(3, [][2]int32{{0, 1}, {1, 2}})
+	if _, iter := PageRank(g, 0.85, 2, 0); iter != 2 {
+		t.Fatalf("iterations = %d, want 2", iter)
+	}
+}
+
+func TestStronglyConnectedComponents(t *testing.T) {
+	tests := []struct {
+		name   string
+		n      int
+		edges  [][2]int32
+		groups [][]int32 // 同一组的节点属于同一个分量，不同组属于不同分量
+	}{
+		{"empty", 0, nil, nil},
+		{"isolated", 3, nil, [][]int32{{0}, {1}, {2}}},
+		{"chain", 3, [][2]int32{{0, 1}, {1, 2}}, [][]int32{{0}, {1}, {2}}},
+		{"cycle", 3, [][2]int32{{0, 1}, {1, 2}, {2, 0}}, [][]int32{{0, 1, 2}}},
+		{"two cycles joined", 6, [][2]int32{{0, 1}, {1, 0}, {1, 2}, {2, 3}, {3, 4}, {4, 2}, {4, 5}},
+			[][]int32{{0, 1}, {2, 3, 4}, {5}}},
+		{"nested back edges", 5, [][2]int32{{0, 1}, {1, 2}, {2, 0}, {2, 3}, {3, 4}, {4, 3}, {3, 1}},
+			[][]int32{{0, 1, 2, 3, 4}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comp, count := StronglyConnectedComponents(newTestGraph(tt.n, tt.edges))
+			if count != len(tt.groups) {
+				t.Fatalf("count = %d, want %d (%v)", count, len(tt.groups), comp)
+			}
+			var seen = make(map[int32]bool)
+			for _, group := range tt.groups {
+				c := comp[group[0]]
+				if seen[c] {
+					t.Fatalf("component %d shared by groups: %v", c, comp)
+				}
+				seen[c] = true
+				for _, v := range group {
+					if comp[v] != c {
+						t.Fatalf("node %d in component %d, want %d: %v", v, comp[v], c, comp)
+					}
+				}
+			}
+		})
+	}
+}
+
+// 长链不会因为递归过深而栈溢出
+func TestStronglyConnectedComponentsLongChain(t *testing.T) {
+	const n = 200000
+	var edges [][2]int32
+	for i := int32(0); i < n-1; i++ {
+		edges = append(edges, [2]int32{i, i + 1})
+	}
+	edges = append(edges, [2]int32{n - 1, 0})
+	if _, count := StronglyConnectedComponents(newTestGraph(n, edges)); count != 1 {
+		t.Fatalf("count = %d, want 1", count)
+	}
+}
@@ -0,0 +1,51 @@
+package graph
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+type GraphMLWriter struct {
+	w *bufio.Writer
+}
+
+func NewGraphMLWriter(w io.Writer) (Writer, error) {
+	bw := bufio.NewWriter(w)
+	_, err := bw.WriteString(xml.Header + `<graphml xmlns="http://graphml.graphdrawing.org/xmlns">
+  <key id="url" for="node" attr.name="url" attr.type="string"/>
+  <key id="domain" for="node" attr.name="domain" attr.type="string"/>
+  <key id="in_degree" for="node" attr.name="in_degree" attr.type="int"/>
+  <key id="out_degree" for="node" attr.name="out_degree" attr.type="int"/>
+  <key id="pagerank" for="node" attr.name="pagerank" attr.type="double"/>
+  <key id="scc" for="node" attr.name="scc" attr.type="int"/>
+  <graph id="crawl" edgedefault="directed">
+`)
+	return &GraphMLWriter{w: bw}, err
+}
+
+func (g *GraphMLWriter) WriteNode(n Node) error {
+	_, err := fmt.Fprintf(g.w, `    <node id="n%d">
+      <data key="url">%s</data>
+      <data key="domain">%s</data>
+      <data key="in_degree">%d</data>
+      <data key="out_degree">%d</data>
+      <data key="pagerank">%g</data>
+      <data key="scc">%d</data>
+    </node>
+`, n.Index, escapeXML(n.URL), escapeXML(n.Domain), n.InDegree, n.OutDegree, n.PageRank, n.Component)
+	return err
+}
+
+func (g *GraphMLWriter) WriteEdge(source int32, target int32) error {
+	_, err := fmt.Fprintf(g.w, "    <edge source=\"n%d\" target=\"n%d\"/>\n", source, target)
+	return err
+}
+
+func (g *GraphMLWriter) Close() error {
+	if _, err := g.w.WriteString("  </graph>\n</graphml>\n"); err != nil {
+		return err
+	}
+	return g.w.Flush()
+}
@@ -0,0 +1,56 @@
+package graph
+
+import (
+	"math"
+)
+
+// 迭代计算PageRank，相邻两次结果的L1距离小于tolerance或达到maxIter时停止
+// 没有出边的节点将其分数平均分配给所有节点，返回分数及实际迭代次数
+func PageRank(g *Graph, damping float64, maxIter int, tolerance float64) ([]float64, int) {
+	n := g.NumNodes()
+	if n == 0 {
+		return nil, 0
+	}
+
+	var rank = make([]float64, n)
+	var next = make([]float64, n)
+	for i := range rank {
+		rank[i] = 1 / float64(n)
+	}
+
+	var iter int
+	for iter < maxIter {
+		iter++
+
+		var dangling float64
+		for v := 0; v < n; v++ {
+			if g.OutDegree(int32(v)) == 0 {
+				dangling += rank[v]
+			}
+		}
+		base := (1-damping)/float64(n) + damping*dangling/float64(n)
+		for i := range next {
+			next[i] = base
+		}
+		for v := 0; v < n; v++ {
+			out := g.OutDegree(int32(v))
+			if out == 0 {
+				continue
+			}
+			share := damping * rank[v] / float64(out)
+			for _, w := range g.Neighbors(int32(v)) {
+				next[w] += share
+			}
+		}
+
+		var diff float64
+		for i := range rank {
+			diff += math.Abs(next[i] - rank[i])
+		}
+		rank, next = next, rank
+		if diff < tolerance {
+			break
+		}
+	}
+	return rank, iter
+}
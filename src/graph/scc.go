@@ -0,0 +1,75 @@
+package graph
+
+// Tarjan算法计算强连通分量，返回每个节点所属分量的编号以及分量数量
+// 使用显式栈代替递归，避免深层链接导致栈溢出
+func StronglyConnectedComponents(g *Graph) ([]int32, int) {
+	n := g.NumNodes()
+	var (
+		index   = make([]int32, n) // 0表示尚未访问，否则为访问顺序+1
+		low     = make([]int32, n)
+		onStack = make([]bool, n)
+		comp    = make([]int32, n)
+		stack   []int32
+		next    int32 = 1
+		count   int32
+	)
+
+	type frame struct {
+		v    int32
+		edge int32 // 下一条待处理的出边在targets中的位置
+	}
+	var calls []frame
+
+	visit := func(v int32) {
+		index[v] = next
+		low[v] = next
+		next++
+		stack = append(stack, v)
+		onStack[v] = true
+		calls = append(calls, frame{v: v, edge: g.offsets[v]})
+	}
+
+	for s := 0; s < n; s++ {
+		if index[s] != 0 {
+			continue
+		}
+		visit(int32(s))
+
+		for len(calls) > 0 {
+			f := &calls[len(calls)-1]
+			v := f.v
+			if f.edge < g.offsets[v+1] {
+				w := g.targets[f.edge]
+				f.edge++
+				if index[w] == 0 {
+					visit(w)
+				} else if onStack[w] && index[w] < low[v] {
+					low[v] = index[w]
+				}
+				continue
+			}
+
+			// v的所有出边处理完毕
+			if low[v] == index[v] {
+				for {
+					w := stack[len(stack)-1]
+					stack = stack[:len(stack)-1]
+					onStack[w] = false
+					comp[w] = count
+					if w == v {
+						break
+					}
+				}
+				count++
+			}
+			calls = calls[:len(calls)-1]
+			if len(calls) > 0 {
+				u := calls[len(calls)-1].v
+				if low[v] < low[u] {
+					low[u] = low[v]
+				}
+			}
+		}
+	}
+	return comp, int(count)
+}
@@ -0,0 +1,134 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+
+	"github.com/andrewyi/crawler/src/dbstorage"
+	"github.com/andrewyi/crawler/src/dbstorage/schema"
+	"github.com/andrewyi/crawler/src/enum"
+)
+
+type Node struct {
+	Index     int32
+	URL       string
+	Domain    string
+	InDegree  int32
+	OutDegree int32
+	PageRank  float64
+	Component int32
+}
+
+// Writer 节点全部写入之后再写入边
+type Writer interface {
+	WriteNode(Node) error
+	WriteEdge(source int32, target int32) error
+	// 写入结尾部分，不关闭底层的io.Writer
+	Close() error
+}
+
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case enum.GraphFormatGraphML:
+		return NewGraphMLWriter(w)
+	case enum.GraphFormatGEXF:
+		return NewGEXFWriter(w)
+	case enum.GraphFormatDOT:
+		return NewDOTWriter(w)
+	default:
+		return nil, fmt.Errorf("unknown graph format: %s", format)
+	}
+}
+
+// Scores 与节点下标一一对应的分析结果
+type Scores struct {
+	PageRank   []float64
+	Component  []int32
+	Components int
+}
+
+func Analyze(g *Graph, damping float64, maxIter int, tolerance float64) Scores {
+	var s Scores
+	s.PageRank, _ = PageRank(g, damping, maxIter, tolerance)
+	s.Component, s.Components = StronglyConnectedComponents(g)
+	return s
+}
+
+// 再次遍历pages取得url等信息，写入所有节点及边
+func Write(dbStorage *dbstorage.SimpleDBStorage, g *Graph, s Scores, w Writer) error {
+	var v int32
+	err := g.scan(dbStorage, func(p *schema.Page) error {
+		// 加载之后被删除的页面会导致id不连续，跳过对应的节点
+		var ok bool
+		if v, ok = g.seek(v, p.ID); !ok {
+			return nil
+		}
+		err := w.WriteNode(Node{
+			Index:     v,
+			URL:       p.URL,
+			Domain:    p.Domain,
+			InDegree:  g.InDegree(v),
+			OutDegree: g.OutDegree(v),
+			PageRank:  s.PageRank[v],
+			Component: s.Component[v],
+		})
+		v++
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for v := int32(0); int(v) < g.NumNodes(); v++ {
+		for _, t := range g.Neighbors(v) {
+			if err := w.WriteEdge(v, t); err != nil {
+				return err
+			}
+		}
+	}
+	return w.Close()
+}
+
+// 将分析结果写回pages表，每批使用单独的事务
+func Save(dbStorage *dbstorage.SimpleDBStorage, g *Graph, s Scores) error {
+	var batch = make([]dbstorage.PageScore, 0, scanBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		t, err := dbStorage.NewTransaction()
+		if err != nil {
+			return err
+		}
+		defer t.Close()
+		if err = t.UpdatePageScores(batch); err != nil {
+			return err
+		}
+		batch = batch[:0]
+		return t.Commit()
+	}
+
+	for v := int32(0); int(v) < g.NumNodes(); v++ {
+		batch = append(batch, dbstorage.PageScore{
+			ID:        g.ids[v],
+			InDegree:  g.InDegree(v),
+			OutDegree: g.OutDegree(v),
+			PageRank:  s.PageRank[v],
+			Component: s.Component[v],
+		})
+		if len(batch) == cap(batch) {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+func escapeXML(s string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
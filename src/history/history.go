@@ -0,0 +1,130 @@
+// 页面内容的版本历史：比较两个版本之间的差异，以及监控指定url的变化
+package history
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"time"
+
+	"github.com/pmezard/go-difflib/difflib"
+
+	"github.com/andrewyi/crawler/src/dbstorage"
+	"github.com/andrewyi/crawler/src/dbstorage/schema"
+)
+
+// Change 页面抓取到了与之前不同的内容，保存为新的版本
+type Change struct {
+	JobID     string    `json:"job"`
+	URL       string    `json:"url"` // 原始url，数据库中保存的为移除协议后的url
+	Version   uint32    `json:"version"`
+	Previous  uint32    `json:"previous"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// Monitor 需要监控变化的url，patterns为正则表达式，匹配原始url中的任意部分
+type Monitor struct {
+	patterns []*regexp.Regexp
+}
+
+func NewMonitor(patterns []string) (*Monitor, error) {
+	var m = &Monitor{}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid monitor pattern: %s, err: %w", p, err)
+		}
+		m.patterns = append(m.patterns, re)
+	}
+	return m, nil
+}
+
+// 没有配置pattern时不监控任何url
+func (m *Monitor) Match(url string) bool {
+	for _, re := range m.patterns {
+		if re.MatchString(url) {
+			return true
+		}
+	}
+	return false
+}
+
+// 用于比较的内容：有正文时为正文，否则为网页内容
+func Read(v *schema.PageVersion) (string, error) {
+	path := v.TextPath
+	if path == "" {
+		path = v.StoragePath
+	}
+	if path == "" {
+		return "", fmt.Errorf("version %d of %s has no content", v.Version, v.URL)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// from到to之间的unified diff，context为每处差异前后保留的行数，内容相同时返回空字符串
+func Diff(from *schema.PageVersion, to *schema.PageVersion, context int) (string, error) {
+	a, err := Read(from)
+	if err != nil {
+		return "", err
+	}
+	b, err := Read(to)
+	if err != nil {
+		return "", err
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(a),
+		B:        splitLines(b),
+		FromFile: fmt.Sprintf("%s@%d", from.URL, from.Version),
+		ToFile:   fmt.Sprintf("%s@%d", to.URL, to.Version),
+		FromDate: from.FetchedAt.Format(time.RFC3339),
+		ToDate:   to.FetchedAt.Format(time.RFC3339),
+		Context:  context,
+	})
+}
+
+// SplitLines在最后一行补齐换行符，内容以换行结尾时会多出一个空行，需要移除
+func splitLines(s string) []string {
+	lines := difflib.SplitLines(s)
+	if n := len(lines); n > 0 && lines[n-1] == "\n" {
+		lines = lines[:n-1]
+	}
+	return lines
+}
+
+// 获取需要比较的两个版本，to为0时为最新的版本，from为0时为to的上一个版本
+// url没有任何版本时返回dbstorage.ErrDataNotExist
+func Versions(t *dbstorage.Transaction, url string, from uint32, to uint32) (*schema.PageVersion, *schema.PageVersion, error) {
+	versions, err := t.GetPageVersions(url)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(versions) == 0 {
+		return nil, nil, dbstorage.ErrDataNotExist
+	}
+	if to == 0 {
+		to = versions[len(versions)-1].Version
+	}
+	if from == 0 {
+		if to <= 1 {
+			return nil, nil, fmt.Errorf("version %d of %s has no previous version", to, url)
+		}
+		from = to - 1
+	}
+	var a, b *schema.PageVersion
+	for _, v := range versions {
+		switch v.Version {
+		case from:
+			a = v
+		case to:
+			b = v
+		}
+	}
+	if a == nil || b == nil {
+		return nil, nil, fmt.Errorf("version %d or %d of %s not found", from, to, url)
+	}
+	return a, b, nil
+}
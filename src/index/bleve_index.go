@@ -0,0 +1,131 @@
+package index
+
+import (
+	"os"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+type BleveIndex struct {
+	index bleve.Index
+}
+
+// path不存在时创建新的索引，readOnly用于命令行检索
+func NewBleveIndex(path string, readOnly bool) (Index, error) {
+	var (
+		idx bleve.Index
+		err error
+	)
+	if _, statErr := os.Stat(path); os.IsNotExist(statErr) {
+		idx, err = bleve.New(path, newMapping())
+	} else {
+		idx, err = bleve.OpenUsing(path, map[string]interface{}{
+			"read_only": readOnly,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &BleveIndex{index: idx}, nil
+}
+
+// job、url、domain不分词，仅用于精确匹配；title、text使用默认分词器
+func newMapping() *mapping.IndexMappingImpl {
+	keywordField := bleve.NewTextFieldMapping()
+	keywordField.Analyzer = keyword.Name
+
+	textField := bleve.NewTextFieldMapping() // 默认存储原文及term vector，用于高亮片段
+
+	doc := bleve.NewDocumentMapping()
+	doc.AddFieldMappingsAt("job", keywordField)
+	doc.AddFieldMappingsAt("url", keywordField)
+	doc.AddFieldMappingsAt("domain", keywordField)
+	doc.AddFieldMappingsAt("title", textField)
+	doc.AddFieldMappingsAt("text", textField)
+	doc.AddFieldMappingsAt("fetched_at", bleve.NewDateTimeFieldMapping())
+
+	m := bleve.NewIndexMapping()
+	m.DefaultMapping = doc
+	return m
+}
+
+// 文档id为job及url，url中不包含空格
+func docID(job string, url string) string {
+	return job + " " + url
+}
+
+func (b *BleveIndex) Index(d Document) error {
+	return b.index.Index(docID(d.Job, d.URL), map[string]interface{}{
+		"job":        d.Job,
+		"url":        d.URL,
+		"title":      d.Title,
+		"text":       d.Text,
+		"domain":     d.Domain,
+		"fetched_at": d.FetchedAt,
+	})
+}
+
+func (b *BleveIndex) Search(q Query) (Result, error) {
+	text := bleve.NewDisjunctionQuery(
+		matchQuery(q.Text, "title", 2), // 标题命中的权重更高
+		matchQuery(q.Text, "text", 1),
+	)
+	var conjuncts = []query.Query{text}
+	if q.Job != "" {
+		job := bleve.NewTermQuery(q.Job)
+		job.SetField("job")
+		conjuncts = append(conjuncts, job)
+	}
+	if q.Domain != "" {
+		domain := bleve.NewTermQuery(q.Domain)
+		domain.SetField("domain")
+		conjuncts = append(conjuncts, domain)
+	}
+	if !q.Since.IsZero() || !q.Until.IsZero() {
+		fetched := bleve.NewDateRangeQuery(q.Since, q.Until)
+		fetched.SetField("fetched_at")
+		conjuncts = append(conjuncts, fetched)
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+	req := bleve.NewSearchRequestOptions(bleve.NewConjunctionQuery(conjuncts...), limit, q.Offset, false)
+	req.Fields = []string{"job", "url", "title", "domain", "fetched_at"}
+	req.Highlight = bleve.NewHighlight()
+	req.Highlight.AddField("text")
+
+	res, err := b.index.Search(req)
+	if err != nil {
+		return Result{}, err
+	}
+	var result = Result{Total: res.Total, Hits: make([]Hit, 0, len(res.Hits))}
+	for _, h := range res.Hits {
+		hit := Hit{Score: h.Score, Fragments: h.Fragments["text"]}
+		hit.Job, _ = h.Fields["job"].(string)
+		hit.URL, _ = h.Fields["url"].(string)
+		hit.Title, _ = h.Fields["title"].(string)
+		hit.Domain, _ = h.Fields["domain"].(string)
+		if v, ok := h.Fields["fetched_at"].(string); ok {
+			hit.FetchedAt, _ = time.Parse(time.RFC3339, v)
+		}
+		result.Hits = append(result.Hits, hit)
+	}
+	return result, nil
+}
+
+func matchQuery(text string, field string, boost float64) query.Query {
+	q := bleve.NewMatchQuery(text)
+	q.SetField(field)
+	q.SetBoost(boost)
+	return q
+}
+
+func (b *BleveIndex) Close() error {
+	return b.index.Close()
+}
@@ -0,0 +1,52 @@
+// 抓取结果的全文索引，由controller在页面成功存储后写入
+// 嵌入式索引同一时间只能被一个进程打开，爬虫运行期间请通过http接口检索
+package index
+
+import (
+	"time"
+)
+
+const defaultLimit = 20
+
+// Document 被索引的页面，URL为shortify之后的url，不同job中的同一url为不同的文档
+type Document struct {
+	Job       string
+	URL       string
+	Title     string
+	Text      string
+	Domain    string
+	FetchedAt time.Time
+}
+
+// Query Since/Until为零值时不限制，Job、Domain为空时不限制
+type Query struct {
+	Text   string
+	Job    string
+	Domain string
+	Since  time.Time
+	Until  time.Time
+	Offset int
+	Limit  int // 小于等于0时使用默认值
+}
+
+type Hit struct {
+	Job       string    `json:"job"`
+	URL       string    `json:"url"`
+	Title     string    `json:"title"`
+	Domain    string    `json:"domain"`
+	FetchedAt time.Time `json:"fetched_at"`
+	Score     float64   `json:"score"`
+	Fragments []string  `json:"fragments"` // 正文中命中关键字的片段
+}
+
+type Result struct {
+	Total uint64 `json:"total"`
+	Hits  []Hit  `json:"hits"`
+}
+
+type Index interface {
+	// 相同Job及URL的文档会被覆盖
+	Index(Document) error
+	Search(Query) (Result, error)
+	Close() error
+}
@@ -0,0 +1,239 @@
+// 爬取任务（job）：每个job有单独的seed、深度、范围、预算以及存储位置，页面通过job_id区分
+// job通过命令行创建及修改状态，运行中的进程定时从数据库重新载入
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/andrewyi/crawler/src/dbstorage"
+	"github.com/andrewyi/crawler/src/dbstorage/schema"
+	"github.com/andrewyi/crawler/src/enum"
+)
+
+// job id同时用于队列名称及文件路径，只允许小写字母、数字、下划线及中划线
+var idPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,47}$`)
+
+func ValidateID(id string) error {
+	if !idPattern.MatchString(id) {
+		return fmt.Errorf("invalid job id: %s, must match %s", id, idPattern.String())
+	}
+	return nil
+}
+
+// 为空时返回enum.DefaultJob，用于兼容升级之前没有job_id的消息
+func Normalize(id string) string {
+	if id == "" {
+		return enum.DefaultJob
+	}
+	return id
+}
+
+func EncodeScope(domains []string) (string, error) {
+	if len(domains) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(domains)
+	return string(b), err
+}
+
+func DecodeScope(scope string) ([]string, error) {
+	if scope == "" {
+		return nil, nil
+	}
+	var domains []string
+	err := json.Unmarshal([]byte(scope), &domains)
+	return domains, err
+}
+
+// domain等于scope中的某个域名或者是其子域名时返回true，scope为空时不限制
+func InScope(scope []string, domain string) bool {
+	if len(scope) == 0 {
+		return true
+	}
+	domain = strings.ToLower(domain)
+	for _, s := range scope {
+		if domain == s || strings.HasSuffix(domain, "."+s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Registry 进程内缓存的job，由所有worker共享
+// 返回的job在两次载入之间不会被修改，调用方同样不能修改
+type Registry struct {
+	mu     sync.RWMutex
+	jobs   map[string]*schema.Job
+	scopes map[string][]string
+
+	db *dbstorage.SimpleDBStorage
+}
+
+func NewRegistry(db *dbstorage.SimpleDBStorage) *Registry {
+	return &Registry{
+		jobs:   make(map[string]*schema.Job),
+		scopes: make(map[string][]string),
+		db:     db,
+	}
+}
+
+// 从数据库重新载入所有job
+func (r *Registry) Load() error {
+	t, err := r.db.NewTransaction()
+	if err != nil {
+		return err
+	}
+	defer t.Rollback()
+	jobs, err := t.GetJobs()
+	if err != nil {
+		return err
+	}
+
+	var loaded = make(map[string]*schema.Job, len(jobs))
+	var scopes = make(map[string][]string, len(jobs))
+	for _, j := range jobs {
+		scope, err := DecodeScope(j.Scope)
+		if err != nil {
+			return fmt.Errorf("invalid scope of job %s, err: %w", j.ID, err)
+		}
+		loaded[j.ID] = j
+		scopes[j.ID] = scope
+	}
+	r.mu.Lock()
+	r.jobs = loaded
+	r.scopes = scopes
+	r.mu.Unlock()
+	return nil
+}
+
+// 不在缓存中时（例如刚刚通过命令行创建）从数据库载入，不存在时返回dbstorage.ErrDataNotExist
+func (r *Registry) Get(id string) (*schema.Job, error) {
+	id = Normalize(id)
+	r.mu.RLock()
+	j, ok := r.jobs[id]
+	r.mu.RUnlock()
+	if ok {
+		return j, nil
+	}
+
+	t, err := r.db.NewTransaction()
+	if err != nil {
+		return nil, err
+	}
+	defer t.Rollback()
+	if j, err = t.GetJob(id); err != nil {
+		return nil, err
+	}
+	scope, err := DecodeScope(j.Scope)
+	if err != nil {
+		return nil, fmt.Errorf("invalid scope of job %s, err: %w", j.ID, err)
+	}
+	r.mu.Lock()
+	r.jobs[id] = j
+	r.scopes[id] = scope
+	r.mu.Unlock()
+	return j, nil
+}
+
+// 按id排序
+func (r *Registry) Jobs() []*schema.Job {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var jobs = make([]*schema.Job, 0, len(r.jobs))
+	for _, j := range r.jobs {
+		jobs = append(jobs, j)
+	}
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].ID < jobs[b].ID })
+	return jobs
+}
+
+// downloader可以从中取出url的job，按id排序
+// 暂停的job不参与调度，队列中的url保留；取消及结束的job仍然参与，以便丢弃队列中剩余的url
+func (r *Registry) Schedulable() []string {
+	var ids []string
+	for _, j := range r.Jobs() {
+		if j.State != enum.JobStatePaused {
+			ids = append(ids, j.ID)
+		}
+	}
+	return ids
+}
+
+// domain是否在job的抓取范围内
+func (r *Registry) InScope(id string, domain string) bool {
+	id = Normalize(id)
+	if _, err := r.Get(id); err != nil {
+		return true // 无法判断时不限制，由调用方处理job本身的错误
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return InScope(r.scopes[id], domain)
+}
+
+// 定时重新载入，直到ctx结束
+func (r *Registry) Run(ctx context.Context, period time.Duration, logger *log.Logger) {
+	go func() {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := r.Load(); err != nil {
+					logger.WithError(err).Error("fail to load jobs")
+				}
+			}
+		}
+	}()
+}
+
+// crawl命令的seed文件属于default job，不存在时创建，已经结束时重新开始（取消的job保持不变）
+// 其seed由crawl直接提交，因此创建时即记录开始时间
+func EnsureDefault(db *dbstorage.SimpleDBStorage, depth uint8, location string, textLocation string) error {
+	t, err := db.NewTransaction()
+	if err != nil {
+		return err
+	}
+	defer t.Rollback()
+
+	j, err := t.GetJob(enum.DefaultJob)
+	switch {
+	case err == dbstorage.ErrDataNotExist:
+		_, err = t.InsertJob(&schema.Job{
+			ID:              enum.DefaultJob,
+			State:           enum.JobStateRunning,
+			Depth:           depth,
+			StorageLocation: location,
+			TextLocation:    textLocation,
+			StartedAt:       time.Now(),
+		})
+	case err != nil:
+		return err
+	default:
+		// 与配置保持一致
+		j.Depth = depth
+		j.StorageLocation = location
+		j.TextLocation = textLocation
+		cols := []string{"depth", "storage_location", "text_location"}
+		if j.State == enum.JobStateFinished {
+			j.State = enum.JobStateRunning
+			j.Remark = ""
+			cols = append(cols, "state", "remark")
+		}
+		_, err = t.UpdateJob(j, cols...)
+	}
+	if err != nil {
+		return err
+	}
+	return t.Commit()
+}
@@ -0,0 +1,214 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/andrewyi/crawler/src/dbstorage"
+	"github.com/andrewyi/crawler/src/dbstorage/schema"
+	"github.com/andrewyi/crawler/src/enum"
+	"github.com/andrewyi/crawler/src/event"
+)
+
+const (
+	RemarkCompleted   = "completed"
+	RemarkMaxPages    = "max_pages reached"
+	RemarkMaxDuration = "max_duration reached"
+)
+
+// Submit 提交job中所有pending的页面，在Manager的同步过程中调用，不能阻塞
+type Submit func(jobID string)
+
+// Manager 在core角色中运行，负责启动新建的job，以及判断job是否已经完成或者达到预算
+// 多进程部署时只能有一个core，因此不需要处理并发
+type Manager struct {
+	registry   *Registry
+	db         *dbstorage.SimpleDBStorage
+	logger     *log.Logger
+	maxRetries uint32
+	submit     Submit
+	events     *event.Bus
+	// 启动时已经提交过数据库中所有pending的页面（memory/disk队列），此时尚未开始的job不需要再次提交
+	restored bool
+}
+
+func NewManager(registry *Registry, db *dbstorage.SimpleDBStorage, logger *log.Logger, maxRetries uint32, restored bool, submit Submit, events *event.Bus) *Manager {
+	return &Manager{
+		registry:   registry,
+		db:         db,
+		logger:     logger,
+		maxRetries: maxRetries,
+		submit:     submit,
+		restored:   restored,
+		events:     events,
+	}
+}
+
+// 立即同步一次，之后每隔period同步一次，直到ctx结束
+func (m *Manager) Run(ctx context.Context, period time.Duration) {
+	m.sync(true)
+	go func() {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.sync(false)
+			}
+		}
+	}()
+}
+
+func (m *Manager) sync(initial bool) {
+	if err := m.registry.Load(); err != nil {
+		m.logger.WithError(err).Error("fail to load jobs")
+		return
+	}
+	now := time.Now()
+	for _, j := range m.registry.Jobs() {
+		if j.State != enum.JobStateRunning {
+			continue
+		}
+		if j.StartedAt.IsZero() {
+			m.start(j, initial && m.restored)
+			continue
+		}
+		if remark, done := m.finished(j, now); done {
+			m.finish(j, remark)
+		}
+	}
+}
+
+// 通过命令行创建的job，seed只插入了记录，需要在此提交
+func (m *Manager) start(j *schema.Job, submitted bool) {
+	started := *j
+	started.StartedAt = time.Now()
+	if err := m.update(&started, "started_at"); err != nil {
+		m.logger.WithError(err).WithField("job", j.ID).Error("fail to start job")
+		return
+	}
+	m.logger.WithField("job", j.ID).Info("job started")
+	if !submitted {
+		m.submit(j.ID)
+	}
+}
+
+// 返回job是否应当结束及其原因
+func (m *Manager) finished(j *schema.Job, now time.Time) (string, bool) {
+	if j.MaxDuration > 0 && now.Sub(j.StartedAt) >= time.Duration(j.MaxDuration)*time.Second {
+		return RemarkMaxDuration, true
+	}
+
+	t, err := m.db.NewTransaction()
+	if err != nil {
+		m.logger.WithError(err).Error("fail to start transaction")
+		return "", false
+	}
+	defer t.Rollback()
+
+	if j.MaxPages > 0 {
+		n, err := t.GetJobPageCount(j.ID, enum.PageStateSuccess)
+		if err != nil {
+			m.logger.WithError(err).WithField("job", j.ID).Error("fail to get job page count")
+			return "", false
+		}
+		if n >= int64(j.MaxPages) {
+			return RemarkMaxPages, true
+		}
+	}
+
+	n, err := t.GetJobOutstandingCount(j.ID, m.maxRetries)
+	if err != nil {
+		m.logger.WithError(err).WithField("job", j.ID).Error("fail to get job outstanding count")
+		return "", false
+	}
+	return RemarkCompleted, n == 0
+}
+
+// 结束后downloader丢弃该job剩余的url，已经开始的下载仍会写入结果
+func (m *Manager) finish(j *schema.Job, remark string) {
+	finished := *j
+	finished.State = enum.JobStateFinished
+	finished.Remark = remark
+	finished.FinishedAt = time.Now()
+	if err := m.update(&finished, "state", "remark", "finished_at"); err != nil {
+		m.logger.WithError(err).WithField("job", j.ID).Error("fail to finish job")
+		return
+	}
+	m.logger.WithField("job", j.ID).WithField("remark", remark).Info("job finished")
+
+	var typ = enum.EventBudgetExhausted
+	if remark == RemarkCompleted {
+		typ = enum.EventCrawlCompleted
+	}
+	m.events.Emit(event.Event{
+		Type: typ,
+		Job:  j.ID,
+		Data: map[string]interface{}{
+			"remark":      remark,
+			"started_at":  finished.StartedAt,
+			"finished_at": finished.FinishedAt,
+		},
+	})
+}
+
+func (m *Manager) update(j *schema.Job, cols ...string) error {
+	t, err := m.db.NewTransaction()
+	if err != nil {
+		return err
+	}
+	defer t.Rollback()
+	if _, err = t.UpdateJob(j, cols...); err != nil {
+		return err
+	}
+	return t.Commit()
+}
+
+// 命令行及管理接口修改job状态时使用，只允许running <-> paused，以及running/paused -> cancelled
+func SetState(db *dbstorage.SimpleDBStorage, id string, state uint8) error {
+	t, err := db.NewTransaction()
+	if err != nil {
+		return err
+	}
+	defer t.Rollback()
+
+	j, err := t.GetJob(id)
+	if err != nil {
+		return err
+	}
+	var allowed bool
+	switch state {
+	case enum.JobStatePaused:
+		allowed = j.State == enum.JobStateRunning
+	case enum.JobStateRunning:
+		allowed = j.State == enum.JobStatePaused
+	case enum.JobStateCancelled:
+		allowed = j.State == enum.JobStateRunning || j.State == enum.JobStatePaused
+	}
+	if !allowed {
+		return errInvalidTransition(j.State, state)
+	}
+
+	j.State = state
+	cols := []string{"state"}
+	if state == enum.JobStateCancelled {
+		j.FinishedAt = time.Now()
+		cols = append(cols, "finished_at")
+	}
+	if _, err = t.UpdateJob(j, cols...); err != nil {
+		return err
+	}
+	return t.Commit()
+}
+
+var ErrInvalidTransition = errors.New("invalid job state transition")
+
+func errInvalidTransition(from uint8, to uint8) error {
+	return fmt.Errorf("%w: job is %s, can not be %s", ErrInvalidTransition, enum.JobStateName(int(from)), enum.JobStateName(int(to)))
+}
@@ -0,0 +1,181 @@
+// prometheus指标定义，所有指标注册在默认的registry中，由server通过/metrics暴露
+// NOTE: 部分指标以domain作为label，爬取的域名数量非常多时需要注意基数问题
+package metrics
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/andrewyi/crawler/src/enum"
+)
+
+const namespace = "crawler"
+
+var (
+	PagesDownloaded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "downloader",
+		Name:      "pages_total",
+		Help:      "Pages downloaded, by result, http status class and domain.",
+	}, []string{"result", "status_class", "domain"})
+
+	BytesFetched = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "downloader",
+		Name:      "bytes_total",
+		Help:      "Bytes of page content fetched, by domain.",
+	}, []string{"domain"})
+
+	DownloadDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "downloader",
+		Name:      "duration_seconds",
+		Help:      "Time spent downloading a page, including retries.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	})
+
+	AnalyzeDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "analyzer",
+		Name:      "duration_seconds",
+		Help:      "Time spent analyzing a page.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 12),
+	})
+
+	ProcessDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "controller",
+		Name:      "duration_seconds",
+		Help:      "Time spent processing an analyzed page in the controller.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	})
+
+	DBTransactionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "db",
+		Name:      "transaction_duration_seconds",
+		Help:      "Time from begin to commit or rollback of a database transaction.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"result"})
+
+	DBTransactionErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "db",
+		Name:      "transaction_errors_total",
+		Help:      "Database transaction errors, by operation.",
+	}, []string{"op"})
+
+	PoolRestarts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "pool",
+		Name:      "restarts_total",
+		Help:      "Workers restarted after a panic, by pool.",
+	}, []string{"pool"})
+
+	DuplicatePages = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "controller",
+		Name:      "duplicate_pages_total",
+		Help:      "Pages marked as near-duplicates of an earlier page.",
+	})
+
+	CircuitOpened = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "downloader",
+		Name:      "circuit_opened_total",
+		Help:      "Times a domain circuit was opened, including failed probes.",
+	})
+
+	ParkedURLs = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "downloader",
+		Name:      "parked_urls_total",
+		Help:      "URLs left pending because their domain circuit was open.",
+	})
+
+	WorkOutstanding = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "work",
+		Name:      "outstanding",
+		Help:      "Tasks tracked in this process, by queue or stage; deferred counts urls waiting for retry or an open circuit.",
+	}, []string{"stage"})
+
+	EventsSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "events",
+		Name:      "sent_total",
+		Help:      "Events delivered to a sink, by sink and result; dropped means the sink buffer was full.",
+	}, []string{"sink", "result"})
+
+	queueDepthDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "queue", "depth"),
+		"Messages currently waiting in a stage queue.",
+		[]string{"queue"}, nil)
+
+	pagesByStateDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "pages", "by_state"),
+		"Rows in the pages table, by state.",
+		[]string{"state"}, nil)
+)
+
+func init() {
+	prometheus.MustRegister(
+		PagesDownloaded,
+		BytesFetched,
+		DownloadDuration,
+		AnalyzeDuration,
+		ProcessDuration,
+		DBTransactionDuration,
+		DBTransactionErrors,
+		PoolRestarts,
+		DuplicatePages,
+		CircuitOpened,
+		ParkedURLs,
+		WorkOutstanding,
+		EventsSent,
+	)
+}
+
+// 将http状态码转换为2xx/3xx等，没有状态码时（例如连接失败）为none
+func StatusClass(code int) string {
+	if code <= 0 {
+		return "none"
+	}
+	return fmt.Sprintf("%dxx", code/100)
+}
+
+// 在每次抓取时才获取的指标（队列长度、数据库中各状态的page数量）
+type stateCollector struct {
+	queueDepths  func() map[string]int64
+	pagesByState func() (map[uint8]int64, error)
+}
+
+// queueDepths与pagesByState均在每次抓取/metrics时调用
+func RegisterStateCollector(queueDepths func() map[string]int64, pagesByState func() (map[uint8]int64, error)) error {
+	return prometheus.Register(&stateCollector{
+		queueDepths:  queueDepths,
+		pagesByState: pagesByState,
+	})
+}
+
+func (c *stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueDepthDesc
+	ch <- pagesByStateDesc
+}
+
+func (c *stateCollector) Collect(ch chan<- prometheus.Metric) {
+	for name, depth := range c.queueDepths() {
+		ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(depth), name)
+	}
+
+	counts, err := c.pagesByState()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(pagesByStateDesc, err)
+		return
+	}
+	for state, count := range counts {
+		ch <- prometheus.MustNewConstMetric(
+			pagesByStateDesc, prometheus.GaugeValue, float64(count), enum.PageStateName(int(state)))
+	}
+}
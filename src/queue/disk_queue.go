@@ -0,0 +1,251 @@
+// 内存中保留有限数量的消息，超出部分顺序写入磁盘文件，Push永远不会因为队列已满而阻塞
+// 从而避免controller阻塞在url队列、downloader阻塞在page队列时整个流水线死锁
+// NOTE: 写入磁盘的消息按照先进先出的顺序读回内存，因此优先级仅在内存部分中严格有效
+// NOTE: 磁盘文件仅作为溢出缓冲，启动时会清理上一次残留的文件，不用于持久化
+package queue
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// 每条记录的头部：priority(8字节) + body长度(4字节)
+const recordHeaderSize = 12
+
+// 单个segment文件的最大长度，超过后切换到新文件，读完的文件会被删除
+var maxSegmentSize int64 = 64 * 1024 * 1024
+
+type segment struct {
+	path string
+	size int64
+}
+
+type DiskQueue struct {
+	mu    sync.Mutex
+	items msgHeap
+	seq   uint64
+	size  uint32 // 内存中最多保留的消息数量
+
+	dir      string
+	name     string
+	segments []*segment // 从旧到新排列，最后一个为当前写入的segment
+	segSeq   uint64
+	onDisk   int64
+
+	// 每条记录一次写入文件，不经过缓冲，因此文件内容与segment.size一致，可以随时读取
+	writerFile *os.File
+	reader     *bufio.Reader
+	readerFile *os.File
+	readOffset int64
+
+	notEmpty chan struct{}
+}
+
+func NewDiskQueue(dir string, name string, size uint32) (Queue, error) {
+	if size == 0 {
+		size = 1
+	}
+	dir = filepath.Join(dir, name)
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &DiskQueue{
+		size:     size,
+		dir:      dir,
+		name:     name,
+		notEmpty: make(chan struct{}, 1),
+	}, nil
+}
+
+func (q *DiskQueue) Push(ctx context.Context, msg Message) error {
+	q.mu.Lock()
+	// 磁盘上已有消息时必须继续写入磁盘，否则新消息会越过较早溢出的消息
+	if q.onDisk == 0 && uint32(q.items.Len()) < q.size {
+		heap.Push(&q.items, item{msg: msg, seq: q.seq})
+		q.seq++
+		q.mu.Unlock()
+		notify(q.notEmpty)
+		return nil
+	}
+
+	err := q.spill(msg)
+	q.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	notify(q.notEmpty)
+	return nil
+}
+
+func (q *DiskQueue) Pop(ctx context.Context) (Message, error) {
+	for {
+		q.mu.Lock()
+		if q.items.Len() == 0 && q.onDisk > 0 {
+			if err := q.refill(); err != nil {
+				q.mu.Unlock()
+				return Message{}, err
+			}
+		}
+		if q.items.Len() > 0 {
+			it := heap.Pop(&q.items).(item)
+			hasMore := q.items.Len() > 0 || q.onDisk > 0
+			q.mu.Unlock()
+
+			if hasMore {
+				notify(q.notEmpty)
+			}
+			return it.msg, nil
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return Message{}, ctx.Err()
+		case <-q.notEmpty:
+		}
+	}
+}
+
+func (q *DiskQueue) Len() (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return int64(q.items.Len()) + q.onDisk, nil
+}
+
+// 调用方需持有锁
+func (q *DiskQueue) spill(msg Message) error {
+	if q.writerFile == nil || q.segments[len(q.segments)-1].size >= maxSegmentSize {
+		if err := q.rotate(); err != nil {
+			return err
+		}
+	}
+
+	// 头部与body一次写入，避免只写入一部分
+	record := make([]byte, recordHeaderSize+len(msg.Body))
+	binary.BigEndian.PutUint64(record[:8], math.Float64bits(msg.Priority))
+	binary.BigEndian.PutUint32(record[8:recordHeaderSize], uint32(len(msg.Body)))
+	copy(record[recordHeaderSize:], msg.Body)
+
+	seg := q.segments[len(q.segments)-1]
+	if _, err := q.writerFile.Write(record); err != nil {
+		q.rollback(seg)
+		return err
+	}
+	seg.size += int64(len(record))
+	q.onDisk++
+	return nil
+}
+
+// 写入失败时将文件回退到最后一条完整记录的末尾，否则之后写入的记录在读取时全部错位
+// 回退同样失败时不再写入此segment，读取时只读到seg.size为止，之后的内容被忽略
+func (q *DiskQueue) rollback(seg *segment) {
+	if err := q.writerFile.Truncate(seg.size); err == nil {
+		if _, err = q.writerFile.Seek(seg.size, io.SeekStart); err == nil {
+			return
+		}
+	}
+	q.writerFile.Close()
+	q.writerFile = nil
+}
+
+// 切换到新的segment写入，调用方需持有锁
+func (q *DiskQueue) rotate() error {
+	if q.writerFile != nil {
+		if err := q.writerFile.Close(); err != nil {
+			return err
+		}
+		q.writerFile = nil
+	}
+
+	seg := &segment{path: filepath.Join(q.dir, fmt.Sprintf("%s-%020d.seg", q.name, q.segSeq))}
+	q.segSeq++
+	f, err := os.OpenFile(seg.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	q.segments = append(q.segments, seg)
+	q.writerFile = f
+	return nil
+}
+
+// 从最旧的segment中读回消息，直到内存部分填满，调用方需持有锁
+func (q *DiskQueue) refill() error {
+	for q.onDisk > 0 && uint32(q.items.Len()) < q.size {
+		seg := q.segments[0]
+		if q.readerFile == nil {
+			f, err := os.Open(seg.path)
+			if err != nil {
+				return err
+			}
+			q.readerFile = f
+			q.reader = bufio.NewReader(f)
+			q.readOffset = 0
+		}
+
+		// 当前segment已经读完，切换到下一个（磁盘上还有消息时，之后一定还有segment）
+		if q.readOffset >= seg.size {
+			q.readerFile.Close()
+			q.readerFile = nil
+			q.reader = nil
+			if err := os.Remove(seg.path); err != nil {
+				return err
+			}
+			q.segments = q.segments[1:]
+			continue
+		}
+
+		var header [recordHeaderSize]byte
+		if _, err := io.ReadFull(q.reader, header[:]); err != nil {
+			return err
+		}
+		body := make([]byte, binary.BigEndian.Uint32(header[8:]))
+		if _, err := io.ReadFull(q.reader, body); err != nil {
+			return err
+		}
+		q.readOffset += int64(recordHeaderSize + len(body))
+		q.onDisk--
+
+		heap.Push(&q.items, item{
+			msg: Message{
+				Priority: math.Float64frombits(binary.BigEndian.Uint64(header[:8])),
+				Body:     body,
+			},
+			seq: q.seq,
+		})
+		q.seq++
+	}
+
+	// 磁盘中的消息已经全部读回，当前segment可以从头开始复用
+	if q.onDisk == 0 && len(q.segments) == 1 && q.writerFile != nil {
+		return q.truncate()
+	}
+	return nil
+}
+
+func (q *DiskQueue) truncate() error {
+	if err := q.writerFile.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := q.writerFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	q.segments[0].size = 0
+	if q.readerFile != nil {
+		q.readerFile.Close()
+	}
+	q.readerFile = nil
+	q.reader = nil
+	q.readOffset = 0
+	return nil
+}
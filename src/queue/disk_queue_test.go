@@ -0,0 +1,251 @@
+package queue
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// push时priority有效，pop时body为期望出队的消息
+type op struct {
+	push     bool
+	body     string
+	priority float64
+}
+
+func push(body string, priority float64) op { return op{push: true, body: body, priority: priority} }
+func pop(body string) op                    { return op{body: body} }
+
+func newTestDiskQueue(t *testing.T, dir string, size uint32) *DiskQueue {
+	t.Helper()
+	q, err := NewDiskQueue(dir, "test", size)
+	if err != nil {
+		t.Fatalf("fail to create disk queue: %v", err)
+	}
+	return q.(*DiskQueue)
+}
+
+func popTimeout(t *testing.T, q Queue) Message {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	msg, err := q.Pop(ctx)
+	if err != nil {
+		t.Fatalf("fail to pop: %v", err)
+	}
+	return msg
+}
+
+func run(t *testing.T, q *DiskQueue, ops []op) {
+	t.Helper()
+	for i, o := range ops {
+		if o.push {
+			if err := q.Push(context.Background(), Message{Priority: o.priority, Body: []byte(o.body)}); err != nil {
+				t.Fatalf("op %d: fail to push %s: %v", i, o.body, err)
+			}
+			continue
+		}
+		if msg := popTimeout(t, q); string(msg.Body) != o.body {
+			t.Fatalf("op %d: pop %q, want %q", i, msg.Body, o.body)
+		}
+	}
+}
+
+func TestDiskQueue(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   uint32
+		segMax int64
+		ops    []op
+		onDisk int64 // 所有操作完成后磁盘上的消息数量
+	}{
+		{
+			name: "memory only by priority",
+			size: 4,
+			ops:  []op{push("a", 1), push("b", 3), push("c", 2), pop("b"), pop("c"), pop("a")},
+		},
+		{
+			name: "spilled messages follow memory",
+			size: 2,
+			ops:  []op{push("a", 1), push("b", 5), push("c", 9), push("d", 2), pop("b"), pop("a"), pop("c"), pop("d")},
+		},
+		{
+			name: "push goes to disk while disk is not empty",
+			size: 1,
+			ops: []op{
+				push("a", 1), push("b", 1), pop("a"),
+				// b读回内存之后c仍然写入磁盘，且不能越过b
+				push("c", 9), pop("b"), pop("c"),
+			},
+		},
+		{
+			name: "refill from segment still being written",
+			size: 1,
+			ops: []op{
+				push("a", 0), push("b", 0), push("c", 0), pop("a"), pop("b"),
+				push("d", 0), push("e", 0), pop("c"), pop("d"),
+			},
+			onDisk: 1,
+		},
+		{
+			name: "reuse segment after drain",
+			size: 1,
+			ops: []op{
+				push("a", 0), push("b", 0), pop("a"), pop("b"),
+				push("c", 0), push("d", 0), pop("c"), pop("d"),
+			},
+		},
+		{
+			name:   "rotate across segments",
+			size:   1,
+			segMax: recordHeaderSize + 1,
+			ops: []op{
+				push("a", 0), push("b", 0), push("c", 0), push("d", 0), pop("a"), pop("b"),
+				push("e", 0), pop("c"), pop("d"), pop("e"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.segMax > 0 {
+				defer func(v int64) { maxSegmentSize = v }(maxSegmentSize)
+				maxSegmentSize = tt.segMax
+			}
+			dir, err := ioutil.TempDir("", "disk_queue")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			q := newTestDiskQueue(t, dir, tt.size)
+			run(t, q, tt.ops)
+			if q.onDisk != tt.onDisk {
+				t.Fatalf("onDisk = %d, want %d", q.onDisk, tt.onDisk)
+			}
+		})
+	}
+}
+
+// 读完的segment被删除，只剩当前写入的segment时被清空复用
+func TestDiskQueueTruncate(t *testing.T) {
+	defer func(v int64) { maxSegmentSize = v }(maxSegmentSize)
+	maxSegmentSize = 2 * (recordHeaderSize + 1)
+
+	dir, err := ioutil.TempDir("", "disk_queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q := newTestDiskQueue(t, dir, 1)
+	run(t, q, []op{push("a", 0), push("b", 0), push("c", 0), push("d", 0), push("e", 0)})
+	if len(q.segments) != 2 {
+		t.Fatalf("%d segments, want 2", len(q.segments))
+	}
+	run(t, q, []op{pop("a"), pop("b"), pop("c"), pop("d"), pop("e")})
+
+	files, err := filepath.Glob(filepath.Join(q.dir, "*.seg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || len(q.segments) != 1 {
+		t.Fatalf("%d files and %d segments after drain, want 1", len(files), len(q.segments))
+	}
+	info, err := os.Stat(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 0 || q.segments[0].size != 0 {
+		t.Fatalf("segment size %d (file %d) after drain, want 0", q.segments[0].size, info.Size())
+	}
+
+	run(t, q, []op{push("f", 0), push("g", 0), push("h", 0), pop("f"), pop("g"), pop("h")})
+}
+
+// 启动时清理上一次残留的文件，磁盘上的消息不会被恢复
+func TestDiskQueueRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "disk_queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q := newTestDiskQueue(t, dir, 1)
+	run(t, q, []op{push("a", 0), push("b", 0), push("c", 0)})
+	q.writerFile.Close()
+
+	q = newTestDiskQueue(t, dir, 1)
+	if n, _ := q.Len(); n != 0 {
+		t.Fatalf("len = %d after restart, want 0", n)
+	}
+	files, _ := filepath.Glob(filepath.Join(q.dir, "*.seg"))
+	if len(files) != 0 {
+		t.Fatalf("%d segment files left after restart", len(files))
+	}
+	run(t, q, []op{push("d", 0), push("e", 0), pop("d"), pop("e")})
+}
+
+// 写入失败之后，之前及之后写入的消息仍然能够按顺序读回
+func TestDiskQueueWriteFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "disk_queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q := newTestDiskQueue(t, dir, 1)
+	run(t, q, []op{push("a", 0), push("b", 0)})
+
+	// 替换为只读的文件句柄，写入及回退均会失败
+	seg := q.segments[len(q.segments)-1]
+	q.writerFile.Close()
+	if q.writerFile, err = os.Open(seg.path); err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Push(context.Background(), Message{Body: []byte("x")}); err == nil {
+		t.Fatal("push to read-only segment succeeded")
+	}
+	if n, _ := q.Len(); n != 2 {
+		t.Fatalf("len = %d after failed push, want 2", n)
+	}
+
+	run(t, q, []op{push("c", 0), pop("a"), pop("b"), pop("c")})
+}
+
+// 回退成功时继续写入同一个segment
+func TestDiskQueueRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "disk_queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q := newTestDiskQueue(t, dir, 1)
+	run(t, q, []op{push("a", 0), push("b", 0)})
+
+	// 模拟只写入了一部分的记录
+	seg := q.segments[len(q.segments)-1]
+	if _, err = q.writerFile.Write([]byte{0, 1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	q.rollback(seg)
+	if q.writerFile == nil {
+		t.Fatal("segment closed after successful rollback")
+	}
+	info, err := os.Stat(seg.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != seg.size {
+		t.Fatalf("file size %d after rollback, want %d", info.Size(), seg.size)
+	}
+
+	run(t, q, []op{push("c", 0), pop("a"), pop("b"), pop("c")})
+	if len(q.segments) != 1 {
+		t.Fatalf("%d segments, want 1", len(q.segments))
+	}
+}
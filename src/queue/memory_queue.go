@@ -0,0 +1,121 @@
+// 基于堆实现的有界优先级队列，容量已满时Push阻塞，为空时Pop阻塞
+// 仅能在单个进程内使用
+package queue
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+)
+
+type item struct {
+	msg Message
+	seq uint64 // 优先级相同时按照入队顺序出队
+}
+
+type msgHeap []item
+
+func (h msgHeap) Len() int { return len(h) }
+
+func (h msgHeap) Less(i, j int) bool {
+	if h[i].msg.Priority != h[j].msg.Priority {
+		return h[i].msg.Priority > h[j].msg.Priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h msgHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *msgHeap) Push(x interface{}) { *h = append(*h, x.(item)) }
+
+func (h *msgHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+type MemoryQueue struct {
+	mu    sync.Mutex
+	items msgHeap
+	seq   uint64
+	size  uint32
+
+	// 容量为1的通知channel，用于唤醒阻塞中的Push/Pop
+	notEmpty chan struct{}
+	notFull  chan struct{}
+}
+
+func NewMemoryQueue(size uint32) Queue {
+	if size == 0 {
+		size = 1
+	}
+	return &MemoryQueue{
+		size:     size,
+		notEmpty: make(chan struct{}, 1),
+		notFull:  make(chan struct{}, 1),
+	}
+}
+
+func (q *MemoryQueue) Push(ctx context.Context, msg Message) error {
+	for {
+		q.mu.Lock()
+		if uint32(q.items.Len()) < q.size {
+			heap.Push(&q.items, item{msg: msg, seq: q.seq})
+			q.seq++
+			hasRoom := uint32(q.items.Len()) < q.size
+			q.mu.Unlock()
+
+			notify(q.notEmpty)
+			if hasRoom {
+				notify(q.notFull)
+			}
+			return nil
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-q.notFull:
+		}
+	}
+}
+
+func (q *MemoryQueue) Pop(ctx context.Context) (Message, error) {
+	for {
+		q.mu.Lock()
+		if q.items.Len() > 0 {
+			it := heap.Pop(&q.items).(item)
+			hasMore := q.items.Len() > 0
+			q.mu.Unlock()
+
+			notify(q.notFull)
+			if hasMore {
+				notify(q.notEmpty)
+			}
+			return it.msg, nil
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return Message{}, ctx.Err()
+		case <-q.notEmpty:
+		}
+	}
+}
+
+func (q *MemoryQueue) Len() (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return int64(q.items.Len()), nil
+}
+
+func notify(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
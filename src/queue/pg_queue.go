@@ -0,0 +1,106 @@
+// 基于postgresql的work queue，多个进程可以同时Push/Pop同一个队列
+// 出队使用select ... for update skip locked，保证一条消息只会被一个消费者取走
+// NOTE: 消息出队即删除，消费者崩溃时此消息丢失，由core中的重试任务兜底
+package queue
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/andrewyi/crawler/src/dbstorage"
+	"github.com/andrewyi/crawler/src/dbstorage/schema"
+)
+
+// 数据库出错时的重试间隔上限
+const maxErrorBackoff = 30 * time.Second
+
+type PGQueue struct {
+	name         string
+	db           *dbstorage.SimpleDBStorage
+	logger       *log.Logger
+	pollInterval time.Duration // 队列为空时的轮询间隔
+}
+
+func NewPGQueue(name string, db *dbstorage.SimpleDBStorage, logger *log.Logger, pollInterval uint32) Queue {
+	if pollInterval == 0 {
+		pollInterval = 1000
+	}
+	return &PGQueue{
+		name:         name,
+		db:           db,
+		logger:       logger,
+		pollInterval: time.Duration(pollInterval) * time.Millisecond,
+	}
+}
+
+func (q *PGQueue) Push(ctx context.Context, msg Message) error {
+	t, err := q.db.NewTransaction()
+	if err != nil {
+		return err
+	}
+	defer t.Rollback()
+
+	_, err = t.InsertQueueItem(&schema.QueueItem{
+		Queue:    q.name,
+		Priority: msg.Priority,
+		Body:     msg.Body,
+	})
+	if err != nil {
+		return err
+	}
+	return t.Commit()
+}
+
+// 队列为空时等待轮询间隔后重试
+// 其他数据库错误（连接断开、sql错误等）记录日志，并以翻倍的间隔重试，不向调用方返回
+func (q *PGQueue) Pop(ctx context.Context) (Message, error) {
+	backoff := q.pollInterval
+	for {
+		item, err := q.pop()
+		if err == nil {
+			return Message{Priority: item.Priority, Body: item.Body}, nil
+		}
+
+		wait := q.pollInterval
+		if err == dbstorage.ErrDataNotExist {
+			backoff = q.pollInterval
+		} else if ctx.Err() == nil {
+			q.logger.WithError(err).WithField("queue", q.name).WithField("backoff", backoff.String()).Error("fail to pop queue item")
+			wait = backoff
+			if backoff *= 2; backoff > maxErrorBackoff {
+				backoff = maxErrorBackoff
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return Message{}, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (q *PGQueue) Len() (int64, error) {
+	t, err := q.db.NewTransaction()
+	if err != nil {
+		return 0, err
+	}
+	defer t.Rollback()
+	return t.GetQueueItemCount(q.name)
+}
+
+func (q *PGQueue) pop() (*schema.QueueItem, error) {
+	t, err := q.db.NewTransaction()
+	if err != nil {
+		return nil, err
+	}
+	defer t.Rollback()
+
+	item, err := t.PopQueueItem(q.name)
+	if err != nil {
+		return nil, err
+	}
+	return item, t.Commit()
+}
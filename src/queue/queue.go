@@ -0,0 +1,38 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// 各个stage之间传递数据的队列，Priority越大越先出队，相同时先进先出
+// Body为序列化后的数据，从而可以替换为跨进程的实现
+type Message struct {
+	Priority float64
+	Body     []byte
+}
+
+type Queue interface {
+	Push(context.Context, Message) error
+	// 队列为空时阻塞，仅在ctx结束时返回错误
+	Pop(context.Context) (Message, error)
+	// 当前队列中的消息数量
+	Len() (int64, error)
+}
+
+func PushJSON(ctx context.Context, q Queue, priority float64, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return q.Push(ctx, Message{Priority: priority, Body: body})
+}
+
+// 反序列化失败时同样返回错误，调用方需要通过ctx.Err()区分
+func PopJSON(ctx context.Context, q Queue, v interface{}) error {
+	msg, err := q.Pop(ctx)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(msg.Body, v)
+}
@@ -0,0 +1,137 @@
+// 根据上游队列长度以及任务耗时，定期在[min, max]之间调整worker池的大小
+// 1. 平均耗时超过目标值时，认为下游（例如目标站点、网络）已经过载，减少worker
+// 2. 否则如果积压的任务多于worker数量，增加worker
+// 3. 上游队列为空时，逐个减少worker
+package routingpool
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// 统计一段时间内任务的平均耗时，每次读取后清零
+type LatencyWindow struct {
+	mu    sync.Mutex
+	total time.Duration
+	count int64
+}
+
+func (l *LatencyWindow) Observe(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total += d
+	l.count++
+}
+
+// 返回自上次调用以来的平均耗时，期间没有任务时返回0
+func (l *LatencyWindow) Reset() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var avg time.Duration
+	if l.count > 0 {
+		avg = l.total / time.Duration(l.count)
+	}
+	l.total = 0
+	l.count = 0
+	return avg
+}
+
+type Autoscaler struct {
+	pool          RoutingPool
+	min           uint32
+	max           uint32
+	period        time.Duration
+	targetLatency time.Duration // 为0时不根据耗时调整
+
+	depth   func() (int64, error) // 上游队列长度
+	latency *LatencyWindow
+
+	// 每次调整后调用，用于记录日志
+	onResize func(from uint32, to uint32, depth int64, latency time.Duration)
+}
+
+func NewAutoscaler(
+	pool RoutingPool, min uint32, max uint32, period time.Duration, targetLatency time.Duration,
+	depth func() (int64, error), latency *LatencyWindow,
+	onResize func(from uint32, to uint32, depth int64, latency time.Duration)) *Autoscaler {
+
+	if min == 0 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	return &Autoscaler{
+		pool:          pool,
+		min:           min,
+		max:           max,
+		period:        period,
+		targetLatency: targetLatency,
+		depth:         depth,
+		latency:       latency,
+		onResize:      onResize,
+	}
+}
+
+func (a *Autoscaler) Start(ctx context.Context) {
+	ticker := time.NewTicker(a.period)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				a.adjust()
+			}
+		}
+	}()
+}
+
+func (a *Autoscaler) adjust() {
+	depth, err := a.depth()
+	if err != nil {
+		return
+	}
+	latency := a.latency.Reset()
+	size := a.pool.Size()
+
+	// 每次最多调整当前大小的1/4，至少为1
+	step := size / 4
+	if step == 0 {
+		step = 1
+	}
+
+	target := size
+	switch {
+	case a.targetLatency > 0 && latency > a.targetLatency:
+		target = sub(size, step)
+	case depth > int64(size):
+		target = size + step
+	case depth == 0:
+		target = sub(size, 1)
+	}
+
+	if target < a.min {
+		target = a.min
+	}
+	if target > a.max {
+		target = a.max
+	}
+	if target == size {
+		return
+	}
+
+	a.pool.Resize(target)
+	if a.onResize != nil {
+		a.onResize(size, target, depth, latency)
+	}
+}
+
+func sub(a uint32, b uint32) uint32 {
+	if b > a {
+		return 0
+	}
+	return a - b
+}
@@ -1,6 +1,43 @@
 package routingpool
 
+import (
+	"context"
+	"errors"
+)
+
+var ErrRetired = errors.New("worker retired")
+
 type RoutingPool interface {
 	Start() error
 	Stop()
+
+	// 暂停后worker在完成当前任务后等待，直到Resume
+	Pause()
+	Resume()
+	Paused() bool
+
+	// 调整worker数量，减少时多余的worker在完成当前任务后退出
+	Resize(uint32)
+	Size() uint32
+
+	// worker panic后会被自动重启，fn在每次panic时调用
+	OnPanic(fn func(recovered interface{}, stack []byte))
+	// worker因panic被重启的总次数
+	Restarts() uint64
+}
+
+type poolKey struct{}
+
+// worker在每次获取任务前调用，暂停时阻塞
+// 返回错误时worker必须退出：ctx已结束，或者由于Resize缩容需要退出(ErrRetired)
+func Checkpoint(ctx context.Context) error {
+	p, ok := ctx.Value(poolKey{}).(checkpointer)
+	if !ok {
+		return ctx.Err()
+	}
+	return p.checkpoint(ctx)
+}
+
+type checkpointer interface {
+	checkpoint(context.Context) error
 }
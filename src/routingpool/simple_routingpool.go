@@ -4,20 +4,37 @@
 // 2. 通过chanel发送任务（task）
 // 3. 自行指定worker（当前采用的方式）
 // 后续如果需要优化任务分配方式，则需要重新此实现（包括worker）即可
-// NOTE: 注意当前实现没有处理worker崩溃、需要重启等问题
+// worker需要在每次获取任务前调用Checkpoint，从而支持暂停以及缩容
+// worker发生panic时会被recover并在短暂等待后重新启动，正在处理的任务将丢失，worker需要自行通过defer清理任务的状态
 package routingpool
 
 import (
 	"context"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// worker panic后等待多久再重启，防止持续panic时空转
+const restartDelay = time.Second
+
 type SimpleRoutingPool struct {
+	restarts uint64 // atomic，放在首位以保证32位平台上的对齐
+
 	wg sync.WaitGroup
 
 	ctx      context.Context
 	size     uint32
 	workerFn func(context.Context)
+	onPanic  func(interface{}, []byte)
+
+	mu      sync.Mutex
+	started bool
+	running uint32 // 当前运行中的worker数量，包括等待退出的
+	retire  uint32 // 等待退出的worker数量
+	paused  bool
+	changed chan struct{} // 状态变化时关闭并重建，唤醒所有等待中的worker
 }
 
 func NewSimpleRoutingPool(ctx context.Context, size uint32, workerFn func(context.Context)) RoutingPool {
@@ -25,21 +42,150 @@ func NewSimpleRoutingPool(ctx context.Context, size uint32, workerFn func(contex
 		ctx:      ctx,
 		size:     size,
 		workerFn: workerFn,
+		changed:  make(chan struct{}),
 	}
 }
 
 func (s *SimpleRoutingPool) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.started = true
 	var i uint32
 	for ; i != s.size; i++ {
-		s.wg.Add(1)
-		go func() {
-			defer s.wg.Done()
-			s.workerFn(s.ctx)
-		}()
+		s.spawn()
 	}
 	return nil
 }
 
+// 调用方需持有锁
+func (s *SimpleRoutingPool) spawn() {
+	s.running++
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ctx := context.WithValue(s.ctx, poolKey{}, s)
+		for s.run(ctx) {
+			atomic.AddUint64(&s.restarts, 1)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(restartDelay):
+			}
+		}
+	}()
+}
+
+// 返回true表示worker因为panic退出，需要重启
+func (s *SimpleRoutingPool) run(ctx context.Context) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicked = true
+			s.mu.Lock()
+			fn := s.onPanic
+			s.mu.Unlock()
+			if fn != nil {
+				fn(r, debug.Stack())
+			}
+		}
+	}()
+	s.workerFn(ctx)
+	return false
+}
+
+func (s *SimpleRoutingPool) OnPanic(fn func(recovered interface{}, stack []byte)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onPanic = fn
+}
+
+func (s *SimpleRoutingPool) Restarts() uint64 {
+	return atomic.LoadUint64(&s.restarts)
+}
+
 func (s *SimpleRoutingPool) Stop() {
 	s.wg.Wait()
 }
+
+func (s *SimpleRoutingPool) Pause() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = true
+	s.broadcast()
+}
+
+func (s *SimpleRoutingPool) Resume() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = false
+	s.broadcast()
+}
+
+func (s *SimpleRoutingPool) Paused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
+func (s *SimpleRoutingPool) Resize(size uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.size = size
+	if !s.started {
+		return
+	}
+
+	active := s.running - s.retire
+	if size > active {
+		// 优先撤销尚未退出的worker，再创建新的worker
+		n := size - active
+		if n <= s.retire {
+			s.retire -= n
+			return
+		}
+		n -= s.retire
+		s.retire = 0
+		for i := uint32(0); i != n; i++ {
+			s.spawn()
+		}
+		return
+	}
+
+	s.retire += active - size
+	s.broadcast()
+}
+
+func (s *SimpleRoutingPool) Size() uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// 调用方需持有锁
+func (s *SimpleRoutingPool) broadcast() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *SimpleRoutingPool) checkpoint(ctx context.Context) error {
+	for {
+		s.mu.Lock()
+		if s.retire > 0 {
+			s.retire--
+			s.running--
+			s.mu.Unlock()
+			return ErrRetired
+		}
+		if !s.paused {
+			s.mu.Unlock()
+			return ctx.Err()
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
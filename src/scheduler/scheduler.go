@@ -0,0 +1,351 @@
+// 定时爬取：按照cron表达式定时重新注入同一组seed，每次运行为一代（generation），对应一个新的job
+// job结束后统计本代的抓取结果，并与上一代比较新增、消失及内容变化的页面
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/andrewyi/crawler/src/core"
+	"github.com/andrewyi/crawler/src/dbstorage"
+	"github.com/andrewyi/crawler/src/dbstorage/schema"
+	"github.com/andrewyi/crawler/src/enum"
+	"github.com/andrewyi/crawler/src/job"
+)
+
+// job id为 {name}-{代数}，为代数保留的长度
+const maxNameLength = 32
+
+var ErrStillRunning = errors.New("previous generation is still running")
+
+// Schedule 与配置文件中的scheduler.schedules一致
+type Schedule struct {
+	Name         string   `mapstructure:"name"`
+	Cron         string   `mapstructure:"cron"`
+	SeedFilePath string   `mapstructure:"seed_file_path"`
+	Depth        uint8    `mapstructure:"depth"`
+	Scope        []string `mapstructure:"scope"`
+	MaxPages     uint32   `mapstructure:"max_pages"`
+	MaxDuration  uint32   `mapstructure:"max_duration"`
+}
+
+// Defaults 创建job时使用的默认值，与job create命令一致
+type Defaults struct {
+	Depth        uint8
+	Location     string // 每一代的内容存放在以job id命名的子目录中
+	TextLocation string // 为空时不存储正文
+}
+
+type Scheduler struct {
+	schedules map[string]Schedule
+	specs     map[string]cron.Schedule
+	defaults  Defaults
+	db        *dbstorage.SimpleDBStorage
+	logger    *log.Logger
+
+	// 同一时间只有一个触发或检查在进行，避免同一schedule同时创建两代
+	mu sync.Mutex
+}
+
+func NewScheduler(schedules []Schedule, defaults Defaults, db *dbstorage.SimpleDBStorage, logger *log.Logger) (*Scheduler, error) {
+	var s = &Scheduler{
+		schedules: make(map[string]Schedule, len(schedules)),
+		specs:     make(map[string]cron.Schedule, len(schedules)),
+		defaults:  defaults,
+		db:        db,
+		logger:    logger,
+	}
+	for _, sch := range schedules {
+		if err := job.ValidateID(sch.Name); err != nil || len(sch.Name) > maxNameLength {
+			return nil, fmt.Errorf("invalid schedule name: %s, must be a valid job id of at most %d characters", sch.Name, maxNameLength)
+		}
+		if _, ok := s.schedules[sch.Name]; ok {
+			return nil, fmt.Errorf("duplicate schedule name: %s", sch.Name)
+		}
+		if sch.SeedFilePath == "" {
+			return nil, fmt.Errorf("seed_file_path of schedule %s is required", sch.Name)
+		}
+		spec, err := cron.ParseStandard(sch.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron of schedule %s, err: %w", sch.Name, err)
+		}
+		s.schedules[sch.Name] = sch
+		s.specs[sch.Name] = spec
+	}
+	return s, nil
+}
+
+// 按名称排序
+func (s *Scheduler) Names() []string {
+	var names = make([]string, 0, len(s.schedules))
+	for name := range s.schedules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *Scheduler) Get(name string) (Schedule, bool) {
+	sch, ok := s.schedules[name]
+	return sch, ok
+}
+
+// 下一次触发的时间
+func (s *Scheduler) Next(name string, now time.Time) time.Time {
+	spec, ok := s.specs[name]
+	if !ok {
+		return time.Time{}
+	}
+	return spec.Next(now)
+}
+
+// 按照cron触发新的一代，并每隔period检查正在运行的一代是否已经结束，直到ctx结束
+func (s *Scheduler) Run(ctx context.Context, period time.Duration) {
+	c := cron.New()
+	for name, spec := range s.specs {
+		name := name
+		c.Schedule(spec, cron.FuncJob(func() {
+			if _, err := s.Trigger(name); err != nil {
+				s.logger.WithError(err).WithField("schedule", name).Warn("scheduled crawl skipped")
+			}
+		}))
+		s.logger.WithField("schedule", name).WithField("next", spec.Next(time.Now())).Info("crawl scheduled")
+	}
+	c.Start()
+
+	go func() {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				<-c.Stop().Done()
+				return
+			case <-ticker.C:
+				s.Check()
+			}
+		}
+	}()
+}
+
+// 立即开始schedule的新一代：插入seed记录并创建job，由core角色中的job管理启动
+// 上一代尚未结束时返回ErrStillRunning
+func (s *Scheduler) Trigger(name string) (*schema.Generation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sch, ok := s.schedules[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown schedule: %s", name)
+	}
+
+	t, err := s.db.NewTransaction()
+	if err != nil {
+		return nil, err
+	}
+	latest, err := t.GetLatestGeneration(name)
+	t.Close()
+	generation, err := nextGeneration(latest, err)
+	if err != nil {
+		return nil, err
+	}
+
+	seeds, err := core.ReadSeedFile(sch.SeedFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("fail to read seed file, err: %w", err)
+	}
+	j, err := s.newJob(sch, generation)
+	if err != nil {
+		return nil, err
+	}
+	// 先插入seed再创建job，job被载入时seed已经存在
+	if _, err = core.AddSeeds(context.Background(), s.logger, nil, s.db, j.ID, seeds, nil); err != nil {
+		return nil, fmt.Errorf("fail to add seeds, err: %w", err)
+	}
+
+	var g = &schema.Generation{
+		Schedule:   name,
+		Generation: generation,
+		JobID:      j.ID,
+		State:      enum.GenerationStateRunning,
+	}
+	if t, err = s.db.NewTransaction(); err != nil {
+		return nil, err
+	}
+	defer t.Close()
+	if _, err = t.InsertGeneration(g); err != nil {
+		return nil, err
+	}
+	if _, err = t.InsertJob(j); err != nil {
+		return nil, err
+	}
+	if err = t.Commit(); err != nil {
+		return nil, err
+	}
+	s.logger.WithField("schedule", name).WithField("generation", generation).WithField("job", j.ID).Info("scheduled crawl started")
+	return g, nil
+}
+
+// latestErr为查询最近一代时返回的错误，还没有任何一代时从1开始，最近一代仍在运行时返回ErrStillRunning
+func nextGeneration(latest *schema.Generation, latestErr error) (uint32, error) {
+	switch {
+	case latestErr == dbstorage.ErrDataNotExist:
+		return 1, nil
+	case latestErr != nil:
+		return 0, latestErr
+	case latest.State == enum.GenerationStateRunning:
+		return 0, fmt.Errorf("%w: %s", ErrStillRunning, latest.JobID)
+	default:
+		return latest.Generation + 1, nil
+	}
+}
+
+func (s *Scheduler) newJob(sch Schedule, generation uint32) (*schema.Job, error) {
+	id := fmt.Sprintf("%s-%d", sch.Name, generation)
+	var domains []string
+	for _, d := range sch.Scope {
+		if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+			domains = append(domains, d)
+		}
+	}
+	scope, err := job.EncodeScope(domains)
+	if err != nil {
+		return nil, err
+	}
+	var j = &schema.Job{
+		ID:              id,
+		State:           enum.JobStateRunning,
+		Depth:           sch.Depth,
+		Scope:           scope,
+		MaxPages:        sch.MaxPages,
+		MaxDuration:     sch.MaxDuration,
+		StorageLocation: filepath.Join(s.defaults.Location, id),
+	}
+	if j.Depth == 0 {
+		j.Depth = s.defaults.Depth
+	}
+	if s.defaults.TextLocation != "" {
+		j.TextLocation = filepath.Join(s.defaults.TextLocation, id)
+	}
+	return j, nil
+}
+
+// 检查正在运行的每一代，job结束（完成、达到预算或被取消）后写入统计及比较结果
+func (s *Scheduler) Check() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.db.NewTransaction()
+	if err != nil {
+		s.logger.WithError(err).Error("fail to start transaction")
+		return
+	}
+	gens, err := t.GetRunningGenerations()
+	t.Close()
+	if err != nil {
+		s.logger.WithError(err).Error("fail to get running generations")
+		return
+	}
+	for _, g := range gens {
+		if err = s.finish(g); err != nil {
+			s.logger.WithError(err).WithField("job", g.JobID).Error("fail to finish generation")
+		}
+	}
+}
+
+func (s *Scheduler) finish(g *schema.Generation) error {
+	t, err := s.db.NewTransaction()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	j, err := t.GetJob(g.JobID)
+	if err != nil {
+		return err
+	}
+	if !jobEnded(j) {
+		return nil
+	}
+
+	if err = Summarize(t, g); err != nil {
+		return err
+	}
+	g.State = enum.GenerationStateFinished
+	g.FinishedAt = time.Now()
+	_, err = t.UpdateGeneration(g, "state", "success", "failed", "added", "removed", "changed", "previous", "finished_at")
+	if err != nil {
+		return err
+	}
+	if err = t.Commit(); err != nil {
+		return err
+	}
+	s.logger.WithFields(log.Fields{
+		"schedule":   g.Schedule,
+		"generation": g.Generation,
+		"job":        g.JobID,
+		"remark":     j.Remark,
+		"success":    g.Success,
+		"failed":     g.Failed,
+		"previous":   g.Previous,
+		"added":      g.Added,
+		"removed":    g.Removed,
+		"changed":    g.Changed,
+	}).Info("scheduled crawl finished")
+	return nil
+}
+
+// job完成（包括达到预算）或者被取消之后这一代才结束，暂停的job之后可能恢复
+func jobEnded(j *schema.Job) bool {
+	return j.State == enum.JobStateFinished || j.State == enum.JobStateCancelled
+}
+
+// Summarize使用的查询，由*dbstorage.Transaction实现
+type SummaryStore interface {
+	GetJobPageCount(jobID string, state uint8) (int64, error)
+	GetJobFailedCount(jobID string) (int64, error)
+	GetPreviousGeneration(schedule string, generation uint32) (*schema.Generation, error)
+	CountJobDiff(prevJob string, curJob string, kind string) (int64, error)
+}
+
+// 统计g的抓取结果，并与上一个已经结束的一代比较，结果写入g但不保存
+// 运行中的一代同样可以统计，此时结果只反映当前的进度
+func Summarize(t SummaryStore, g *schema.Generation) error {
+	var err error
+	if g.Success, err = t.GetJobPageCount(g.JobID, enum.PageStateSuccess); err != nil {
+		return err
+	}
+	if g.Failed, err = t.GetJobFailedCount(g.JobID); err != nil {
+		return err
+	}
+
+	prev, err := t.GetPreviousGeneration(g.Schedule, g.Generation)
+	if err == dbstorage.ErrDataNotExist {
+		g.Previous = ""
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	g.Previous = prev.JobID
+	var counts = map[string]*int64{
+		enum.DiffAdded:   &g.Added,
+		enum.DiffRemoved: &g.Removed,
+		enum.DiffChanged: &g.Changed,
+	}
+	for kind, n := range counts {
+		if *n, err = t.CountJobDiff(prev.JobID, g.JobID, kind); err != nil {
+			return err
+		}
+	}
+	return nil
+}
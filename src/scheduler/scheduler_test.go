@@ -0,0 +1,204 @@
+package scheduler
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/andrewyi/crawler/src/dbstorage"
+	"github.com/andrewyi/crawler/src/dbstorage/schema"
+	"github.com/andrewyi/crawler/src/enum"
+	"github.com/andrewyi/crawler/src/job"
+)
+
+// Summarize只负责把查询结果写入generation，查询本身的语义由dbstorage保证，这里返回固定的数量
+type stubStore struct {
+	prev *schema.Generation // 为nil时没有上一代
+	err  error
+
+	// 调用时的参数，用于检查查询的是哪一个job
+	countedJobs []string
+	diffs       map[string][2]string // kind -> (prev, cur)
+}
+
+func (f *stubStore) GetJobPageCount(jobID string, state uint8) (int64, error) {
+	f.countedJobs = append(f.countedJobs, jobID)
+	if state != enum.PageStateSuccess {
+		return 0, errors.New("unexpected state")
+	}
+	return 10, f.err
+}
+
+func (f *stubStore) GetJobFailedCount(jobID string) (int64, error) {
+	f.countedJobs = append(f.countedJobs, jobID)
+	return 3, f.err
+}
+
+func (f *stubStore) GetPreviousGeneration(schedule string, generation uint32) (*schema.Generation, error) {
+	if f.prev == nil {
+		return nil, dbstorage.ErrDataNotExist
+	}
+	return f.prev, nil
+}
+
+func (f *stubStore) CountJobDiff(prevJob string, curJob string, kind string) (int64, error) {
+	if f.diffs == nil {
+		f.diffs = make(map[string][2]string)
+	}
+	f.diffs[kind] = [2]string{prevJob, curJob}
+	counts := map[string]int64{enum.DiffAdded: 4, enum.DiffRemoved: 2, enum.DiffChanged: 1}
+	n, ok := counts[kind]
+	if !ok {
+		return 0, errors.New("unknown diff kind")
+	}
+	return n, f.err
+}
+
+func TestNextGeneration(t *testing.T) {
+	errDB := errors.New("connection refused")
+	tests := []struct {
+		name    string
+		latest  *schema.Generation
+		err     error
+		want    uint32
+		wantErr error
+	}{
+		{"first generation", nil, dbstorage.ErrDataNotExist, 1, nil},
+		{"query error", nil, errDB, 0, errDB},
+		{"previous still running", &schema.Generation{Generation: 2, JobID: "news-2", State: enum.GenerationStateRunning}, nil, 0, ErrStillRunning},
+		{"previous finished", &schema.Generation{Generation: 2, JobID: "news-2", State: enum.GenerationStateFinished}, nil, 3, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := nextGeneration(tt.latest, tt.err)
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("generation = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJobEnded(t *testing.T) {
+	tests := []struct {
+		state uint8
+		want  bool
+	}{
+		{enum.JobStateRunning, false},
+		{enum.JobStatePaused, false},
+		{enum.JobStateCancelled, true},
+		{enum.JobStateFinished, true},
+	}
+	for _, tt := range tests {
+		if got := jobEnded(&schema.Job{State: tt.state}); got != tt.want {
+			t.Errorf("jobEnded(%s) = %v, want %v", enum.JobStateName(int(tt.state)), got, tt.want)
+		}
+	}
+}
+
+func TestNewScheduler(t *testing.T) {
+	valid := Schedule{Name: "news", Cron: "0 3 * * *", SeedFilePath: "seeds.txt"}
+	tests := []struct {
+		name      string
+		schedules []Schedule
+		ok        bool
+	}{
+		{"valid", []Schedule{valid, {Name: "blog", Cron: "@daily", SeedFilePath: "blog.txt"}}, true},
+		{"invalid name", []Schedule{{Name: "News", Cron: "@daily", SeedFilePath: "seeds.txt"}}, false},
+		{"name too long", []Schedule{{Name: "a23456789012345678901234567890123", Cron: "@daily", SeedFilePath: "seeds.txt"}}, false},
+		{"duplicate name", []Schedule{valid, valid}, false},
+		{"missing seed file", []Schedule{{Name: "news", Cron: "@daily"}}, false},
+		{"invalid cron", []Schedule{{Name: "news", Cron: "0 25 * * *", SeedFilePath: "seeds.txt"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewScheduler(tt.schedules, Defaults{}, nil, nil)
+			if (err == nil) != tt.ok {
+				t.Fatalf("err = %v, want ok %v", err, tt.ok)
+			}
+			if tt.ok && len(s.Names()) != len(tt.schedules) {
+				t.Fatalf("names = %v", s.Names())
+			}
+		})
+	}
+}
+
+func TestNewJob(t *testing.T) {
+	s := &Scheduler{defaults: Defaults{Depth: 3, Location: "/data/pages"}}
+	j, err := s.newJob(Schedule{Name: "news", Scope: []string{" Example.COM ", "", "blog.example.com"}, MaxPages: 100}, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.ID != "news-7" || j.State != enum.JobStateRunning || j.Depth != 3 || j.MaxPages != 100 {
+		t.Fatalf("unexpected job: %+v", j)
+	}
+	if want := filepath.Join("/data/pages", "news-7"); j.StorageLocation != want {
+		t.Fatalf("storage location = %s, want %s", j.StorageLocation, want)
+	}
+	if j.TextLocation != "" {
+		t.Fatalf("text location = %s, want empty", j.TextLocation)
+	}
+	scope, err := job.DecodeScope(j.Scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"example.com", "blog.example.com"}; !reflect.DeepEqual(scope, want) {
+		t.Fatalf("scope = %v, want %v", scope, want)
+	}
+
+	// schedule中的深度优先，每一代的正文存放在单独的子目录中
+	s.defaults.TextLocation = "/data/text"
+	if j, err = s.newJob(Schedule{Name: "news", Depth: 1}, 8); err != nil {
+		t.Fatal(err)
+	}
+	if j.Depth != 1 || j.Scope != "" || j.TextLocation != filepath.Join("/data/text", "news-8") {
+		t.Fatalf("unexpected job: %+v", j)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	store := &stubStore{prev: &schema.Generation{Schedule: "news", Generation: 2, JobID: "news-2"}}
+	g := &schema.Generation{Schedule: "news", Generation: 4, JobID: "news-4"}
+	if err := Summarize(store, g); err != nil {
+		t.Fatal(err)
+	}
+	want := schema.Generation{
+		Schedule: "news", Generation: 4, JobID: "news-4",
+		Success: 10, Failed: 3, Previous: "news-2", Added: 4, Removed: 2, Changed: 1,
+	}
+	if *g != want {
+		t.Fatalf("summary = %+v, want %+v", *g, want)
+	}
+	if !reflect.DeepEqual(store.countedJobs, []string{"news-4", "news-4"}) {
+		t.Fatalf("counted jobs = %v", store.countedJobs)
+	}
+	for _, kind := range []string{enum.DiffAdded, enum.DiffRemoved, enum.DiffChanged} {
+		if got := store.diffs[kind]; got != [2]string{"news-2", "news-4"} {
+			t.Fatalf("%s compared %v, want [news-2 news-4]", kind, got)
+		}
+	}
+
+	// 第一代没有可以比较的上一代，之前写入的比较结果被清除
+	store = &stubStore{}
+	g = &schema.Generation{Schedule: "news", Generation: 1, JobID: "news-1", Previous: "stale"}
+	if err := Summarize(store, g); err != nil {
+		t.Fatal(err)
+	}
+	if g.Success != 10 || g.Failed != 3 || g.Previous != "" || g.Added != 0 || g.Removed != 0 || g.Changed != 0 {
+		t.Fatalf("summary of first generation = %+v", *g)
+	}
+	if len(store.diffs) != 0 {
+		t.Fatalf("first generation compared: %v", store.diffs)
+	}
+}
+
+func TestSummarizeError(t *testing.T) {
+	errDB := errors.New("connection refused")
+	store := &stubStore{err: errDB}
+	if err := Summarize(store, &schema.Generation{Schedule: "news", Generation: 1, JobID: "news-1"}); err != errDB {
+		t.Fatalf("err = %v, want %v", err, errDB)
+	}
+}
@@ -0,0 +1,540 @@
+// 运行期间的管理接口，全部位于/api下，输入输出均为json
+// 配置http.token后，请求需要携带 Authorization: Bearer <token>
+// 未配置token且监听非回环地址时，仅提供只读的接口
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andrewyi/crawler/src/core"
+	"github.com/andrewyi/crawler/src/dbstorage"
+	"github.com/andrewyi/crawler/src/dbstorage/schema"
+	"github.com/andrewyi/crawler/src/enum"
+	"github.com/andrewyi/crawler/src/index"
+	"github.com/andrewyi/crawler/src/job"
+	"github.com/andrewyi/crawler/src/routingpool"
+	"github.com/andrewyi/crawler/src/util"
+)
+
+const defaultPageLimit = 50
+
+func (s *Server) registerAPI(mux *http.ServeMux) {
+	handle := func(pattern string, method string, fn http.HandlerFunc) {
+		mux.Handle(pattern, s.auth(allowMethod(method, fn)))
+	}
+	// 修改运行状态的接口
+	mutable := s.mutable()
+	mutate := func(pattern string, method string, fn http.HandlerFunc) {
+		if mutable {
+			handle(pattern, method, fn)
+		}
+	}
+	if !mutable {
+		s.logger.WithField("listen", s.config.HTTP.Listen).Warn("http.token is empty on a non-loopback address, mutating api disabled")
+	}
+
+	mutate("/api/seeds", http.MethodPost, s.handleAddSeeds)
+	handle("/api/pools", http.MethodGet, s.handleListPools)
+	mutate("/api/pools/", http.MethodPost, s.handlePoolAction)
+	if mutable {
+		handle("/api/ratelimits", "", s.handleRateLimits)
+	} else {
+		handle("/api/ratelimits", http.MethodGet, s.handleRateLimits)
+	}
+	handle("/api/pages", http.MethodGet, s.handleGetPage)
+	handle("/api/pages/failed", http.MethodGet, s.handleListFailedPages)
+	mutate("/api/retry", http.MethodPost, s.handleRetry)
+	handle("/api/search", http.MethodGet, s.handleSearch)
+	handle("/api/domains", http.MethodGet, s.handleListDomains)
+	handle("/api/work", http.MethodGet, s.handleWork)
+	handle("/api/jobs", http.MethodGet, s.handleListJobs)
+	mutate("/api/jobs/", http.MethodPost, s.handleJobAction)
+	handle("/api/generations", http.MethodGet, s.handleListGenerations)
+	handle("/api/history", http.MethodGet, s.handleListVersions)
+	handle("/api/history/diff", http.MethodGet, s.handleDiffVersions)
+}
+
+// 未配置token时，只有监听回环地址才提供修改运行状态的接口，避免任何能访问该端口的人控制爬虫
+func (s *Server) mutable() bool {
+	if s.config.HTTP.Token != "" {
+		return true
+	}
+	host, _, err := net.SplitHostPort(s.config.HTTP.Listen)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (s *Server) auth(next http.Handler) http.Handler {
+	token := s.config.HTTP.Token
+	if token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("invalid token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// method为空时不限制
+func allowMethod(method string, fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if method != "" && r.Method != method {
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		fn(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// {"urls": [...], "job": "news"}，job为空时加入default job
+func (s *Server) handleAddSeeds(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URLs []string `json:"urls"`
+		Job  string   `json:"job"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	j, err := s.jobs.Get(req.Job)
+	if err == dbstorage.ErrDataNotExist {
+		writeError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if j.State != enum.JobStateRunning && j.State != enum.JobStatePaused {
+		writeError(w, http.StatusConflict, fmt.Errorf("job is %s", enum.JobStateName(int(j.State))))
+		return
+	}
+	added, err := core.AddSeeds(s.ctx, s.logger, s.urlFrontier, s.dbStorage, j.ID, req.URLs, s.work)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"added": added})
+}
+
+// 仅包含当前进程运行的stage
+func (s *Server) pools() map[string]routingpool.RoutingPool {
+	var pools = make(map[string]routingpool.RoutingPool)
+	if s.downloader != nil {
+		pools[enum.RoleDownloader] = s.downloader
+	}
+	if s.analyzer != nil {
+		pools[enum.RoleAnalyzer] = s.analyzer
+	}
+	if s.controller != nil {
+		pools[enum.RoleController] = s.controller
+	}
+	return pools
+}
+
+type poolView struct {
+	Size     uint32 `json:"size"`
+	Paused   bool   `json:"paused"`
+	Restarts uint64 `json:"restarts"`
+}
+
+func newPoolView(p routingpool.RoutingPool) poolView {
+	return poolView{Size: p.Size(), Paused: p.Paused(), Restarts: p.Restarts()}
+}
+
+func (s *Server) handleListPools(w http.ResponseWriter, r *http.Request) {
+	var views = make(map[string]poolView)
+	for name, p := range s.pools() {
+		views[name] = newPoolView(p)
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+// 手动调整worker数量时的上限，启用autoscale时downloader以autoscale的max为上限
+const maxPoolSize uint32 = 1000
+
+func (s *Server) poolSizeLimit(stage string) uint32 {
+	cfg := s.config.Downloader.Autoscale
+	if stage == enum.RoleDownloader && cfg.Enabled && cfg.Max > 0 && cfg.Max < maxPoolSize {
+		return cfg.Max
+	}
+	return maxPoolSize
+}
+
+// /api/pools/{stage}/pause, /api/pools/{stage}/resume, /api/pools/{stage}/size {"size": n}
+func (s *Server) handlePoolAction(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/pools/"), "/"), "/")
+	if len(parts) != 2 {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	p, ok := s.pools()[parts[0]]
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("stage not running in this process"))
+		return
+	}
+
+	switch parts[1] {
+	case "pause":
+		p.Pause()
+	case "resume":
+		p.Resume()
+	case "size":
+		var req struct {
+			Size uint32 `json:"size"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if limit := s.poolSizeLimit(parts[0]); req.Size == 0 || req.Size > limit {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("size must be between 1 and %d", limit))
+			return
+		}
+		p.Resize(req.Size)
+	default:
+		writeError(w, http.StatusNotFound, errors.New("unknown action"))
+		return
+	}
+	writeJSON(w, http.StatusOK, newPoolView(p))
+}
+
+// GET返回当前限制，POST {"host": "a.com", "rate": 2} 修改限制
+// host为空时修改默认值，rate小于0时移除host的单独限制
+func (s *Server) handleRateLimits(w http.ResponseWriter, r *http.Request) {
+	if s.hostLimiter == nil {
+		writeError(w, http.StatusNotFound, errors.New("downloader not running in this process"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Host string  `json:"host"`
+			Rate float64 `json:"rate"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if req.Host == "" {
+			s.hostLimiter.SetDefault(req.Rate)
+		} else {
+			s.hostLimiter.SetHost(req.Host, req.Rate)
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	defaultRate, rates := s.hostLimiter.Limits()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"default": defaultRate,
+		"hosts":   rates,
+	})
+}
+
+type pageView struct {
+	Job         string    `json:"job"`
+	URL         string    `json:"url"`
+	Domain      string    `json:"domain"`
+	State       string    `json:"state"`
+	Depth       uint8     `json:"depth"`
+	Remark      string    `json:"remark,omitempty"`
+	DuplicateOf string    `json:"duplicate_of,omitempty"`
+	RetryCount  uint32    `json:"retry_count"`
+	LeaseOwner  string    `json:"lease_owner,omitempty"` // 仅在pending状态下有效
+	LeasedUntil time.Time `json:"leased_until"`
+	FetchedAt   time.Time `json:"fetched_at"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func newPageView(p *schema.Page) pageView {
+	return pageView{
+		Job:         p.JobID,
+		URL:         p.URL,
+		Domain:      p.Domain,
+		State:       enum.PageStateName(int(p.State)),
+		Depth:       p.Depth,
+		Remark:      p.Remark,
+		DuplicateOf: p.DuplicateOf,
+		RetryCount:  p.RetryCount,
+		LeaseOwner:  p.LeaseOwner,
+		LeasedUntil: p.LeasedUntil,
+		FetchedAt:   p.FetchedAt,
+		CreatedAt:   p.CreatedAt,
+		UpdatedAt:   p.UpdatedAt,
+	}
+}
+
+// ?url=a.com/x&job=news，job为空时查询default job
+func (s *Server) handleGetPage(w http.ResponseWriter, r *http.Request) {
+	nURL, err := util.ShortifyURL(r.URL.Query().Get("url"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	t, err := s.dbStorage.NewTransaction()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer t.Rollback()
+
+	page, err := t.GetPage(job.Normalize(r.URL.Query().Get("job")), nURL)
+	if err == dbstorage.ErrDataNotExist {
+		writeError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newPageView(page))
+}
+
+// ?offset=0&limit=50
+func (s *Server) handleListFailedPages(w http.ResponseWriter, r *http.Request) {
+	offset, limit := pagination(r)
+
+	t, err := s.dbStorage.NewTransaction()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer t.Rollback()
+
+	pages, err := t.GetFailedPages(offset, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	var views = make([]pageView, 0, len(pages))
+	for _, p := range pages {
+		views = append(views, newPageView(p))
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+func pagination(r *http.Request) (int, int) {
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if offset < 0 {
+		offset = 0
+	}
+	if limit <= 0 {
+		limit = defaultPageLimit
+	}
+	return offset, limit
+}
+
+func (s *Server) handleRetry(w http.ResponseWriter, r *http.Request) {
+	core.RetryTask(s.ctx, s.logger, s.urlFrontier, s.dbStorage)
+	core.RetryFailedTask(s.ctx, s.logger, s.urlFrontier, s.dbStorage, s.config.Core.FailureRetry.MaxRetries)
+	writeJSON(w, http.StatusOK, map[string]string{"result": "ok"})
+}
+
+// ?q=关键字&domain=example.com&since=2006-01-02&until=2006-01-02&offset=0&limit=50
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if s.index == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("index is not enabled in this process"))
+		return
+	}
+	v := r.URL.Query()
+	var q = index.Query{Text: v.Get("q"), Job: v.Get("job"), Domain: v.Get("domain")}
+	if q.Text == "" {
+		writeError(w, http.StatusBadRequest, errors.New("missing q"))
+		return
+	}
+	var err error
+	if q.Since, err = util.ParseTime(v.Get("since")); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if q.Until, err = util.ParseTime(v.Get("until")); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	q.Offset, q.Limit = pagination(r)
+
+	result, err := s.index.Search(q)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+type domainView struct {
+	Domain    string    `json:"domain"`
+	State     string    `json:"state"`
+	Failures  uint32    `json:"failures"`
+	Probes    uint32    `json:"probes"`
+	LastError string    `json:"last_error"`
+	OpenUntil time.Time `json:"open_until"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// 熔断中的域名，从数据库读取，包含其他downloader进程写入的状态
+func (s *Server) handleListDomains(w http.ResponseWriter, r *http.Request) {
+	t, err := s.dbStorage.NewTransaction()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer t.Rollback()
+
+	domains, err := t.GetDomains()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	var views = make([]domainView, 0, len(domains))
+	for _, d := range domains {
+		views = append(views, domainView{
+			Domain:    d.Domain,
+			State:     enum.DomainStateName(int(d.State)),
+			Failures:  d.Failures,
+			Probes:    d.Probes,
+			LastError: d.LastError,
+			OpenUntil: d.OpenUntil,
+			UpdatedAt: d.UpdatedAt,
+		})
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+// 进程内各个队列及stage中的任务数量，postgres队列时没有统计
+func (s *Server) handleWork(w http.ResponseWriter, r *http.Request) {
+	if s.work == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("work tracking is only available with memory or disk queue"))
+		return
+	}
+	writeJSON(w, http.StatusOK, s.work.Snapshot())
+}
+
+type jobView struct {
+	ID              string           `json:"id"`
+	State           string           `json:"state"`
+	Depth           uint8            `json:"depth"`
+	Scope           []string         `json:"scope,omitempty"`
+	MaxPages        uint32           `json:"max_pages"`
+	MaxDuration     uint32           `json:"max_duration"`
+	StorageLocation string           `json:"storage_location"`
+	TextLocation    string           `json:"text_location,omitempty"`
+	Remark          string           `json:"remark,omitempty"`
+	Pages           map[string]int64 `json:"pages"` // 各个状态的页面数量
+	StartedAt       time.Time        `json:"started_at"`
+	FinishedAt      time.Time        `json:"finished_at"`
+}
+
+// 从数据库读取，包含刚刚通过命令行创建、尚未被载入的job
+func (s *Server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	t, err := s.dbStorage.NewTransaction()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer t.Rollback()
+
+	jobs, err := t.GetJobs()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	counts, err := t.GetJobPageCountByState()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	var views = make([]jobView, 0, len(jobs))
+	for _, j := range jobs {
+		scope, _ := job.DecodeScope(j.Scope)
+		var pages = make(map[string]int64)
+		for state, n := range counts[j.ID] {
+			pages[enum.PageStateName(int(state))] = n
+		}
+		views = append(views, jobView{
+			ID:              j.ID,
+			State:           enum.JobStateName(int(j.State)),
+			Depth:           j.Depth,
+			Scope:           scope,
+			MaxPages:        j.MaxPages,
+			MaxDuration:     j.MaxDuration,
+			StorageLocation: j.StorageLocation,
+			TextLocation:    j.TextLocation,
+			Remark:          j.Remark,
+			Pages:           pages,
+			StartedAt:       j.StartedAt,
+			FinishedAt:      j.FinishedAt,
+		})
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+// /api/jobs/{id}/pause, /api/jobs/{id}/resume, /api/jobs/{id}/cancel
+// 状态写入数据库，所有进程在下一次载入job时生效
+func (s *Server) handleJobAction(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/jobs/"), "/"), "/")
+	if len(parts) != 2 {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	var state uint8
+	switch parts[1] {
+	case "pause":
+		state = enum.JobStatePaused
+	case "resume":
+		state = enum.JobStateRunning
+	case "cancel":
+		state = enum.JobStateCancelled
+	default:
+		writeError(w, http.StatusNotFound, errors.New("unknown action"))
+		return
+	}
+
+	err := job.SetState(s.dbStorage, parts[0], state)
+	if err == dbstorage.ErrDataNotExist {
+		writeError(w, http.StatusNotFound, err)
+		return
+	} else if errors.Is(err, job.ErrInvalidTransition) {
+		writeError(w, http.StatusConflict, err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if err = s.jobs.Load(); err != nil {
+		s.logger.WithError(err).Error("fail to load jobs")
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"result": "ok"})
+}
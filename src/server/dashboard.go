@@ -0,0 +1,281 @@
+// 内置的单页面监控面板，页面通过/api下的接口定时刷新数据
+// 页面及脚本全部内嵌在二进制中，不依赖任何外部资源
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andrewyi/crawler/src/dbstorage"
+	"github.com/andrewyi/crawler/src/enum"
+)
+
+const (
+	// 统计抓取速度的时间窗口
+	crawlRateWindow = time.Minute
+	topDomainLimit  = 10
+	recentErrLimit  = 20
+)
+
+func (s *Server) registerDashboard(mux *http.ServeMux) {
+	// 页面本身不包含数据，无需校验token，数据接口仍然需要
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(dashboardHTML))
+	})
+	mux.Handle("/api/stats", s.auth(allowMethod(http.MethodGet, s.handleStats)))
+	mux.Handle("/api/states", s.auth(allowMethod(http.MethodGet, s.handleListStates)))
+	mux.Handle("/api/pages/search", s.auth(allowMethod(http.MethodGet, s.handleSearchPages)))
+}
+
+type statsView struct {
+	CrawlRate    float64                 `json:"crawl_rate"` // 每分钟抓取成功的页面数量
+	QueueDepths  map[string]int64        `json:"queue_depths"`
+	States       map[string]int64        `json:"states"`
+	TopDomains   []dbstorage.DomainCount `json:"top_domains"`
+	RecentErrors []dbstorage.RemarkCount `json:"recent_errors"`
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	t, err := s.dbStorage.NewTransaction()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer t.Rollback()
+
+	var view = statsView{
+		QueueDepths: s.QueueDepths(),
+		States:      make(map[string]int64),
+	}
+
+	fetched, err := t.GetFetchedPageCountSince(time.Now().Add(-crawlRateWindow))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	view.CrawlRate = float64(fetched) / crawlRateWindow.Minutes()
+
+	counts, err := t.GetPageCountByState()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	for state, count := range counts {
+		view.States[enum.PageStateName(int(state))] = count
+	}
+
+	if view.TopDomains, err = t.GetTopDomains(topDomainLimit); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if view.RecentErrors, err = t.GetRecentErrors(recentErrLimit); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, view)
+}
+
+type stateView struct {
+	Value int    `json:"value"`
+	Name  string `json:"name"`
+}
+
+// 面板中状态的下拉框由此生成，与enum中的定义保持一致
+func (s *Server) handleListStates(w http.ResponseWriter, r *http.Request) {
+	var views []stateView
+	for _, state := range enum.PageStates() {
+		views = append(views, stateView{Value: state, Name: enum.PageStateName(state)})
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+// ?q=关键字&state=1&offset=0&limit=50，state为空时不过滤状态
+func (s *Server) handleSearchPages(w http.ResponseWriter, r *http.Request) {
+	offset, limit := pagination(r)
+	state := -1
+	if v := r.URL.Query().Get("state"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.New("invalid state"))
+			return
+		}
+		state = n
+	}
+
+	t, err := s.dbStorage.NewTransaction()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer t.Rollback()
+
+	pages, err := t.SearchPages(r.URL.Query().Get("q"), state, offset, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	var views = make([]pageView, 0, len(pages))
+	for _, p := range pages {
+		views = append(views, newPageView(p))
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>crawler</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 20px; color: #222; background: #f6f7f9; }
+h1 { font-size: 20px; margin: 0 0 16px; }
+h2 { font-size: 15px; margin: 0 0 8px; }
+.grid { display: grid; grid-template-columns: repeat(auto-fit, minmax(320px, 1fr)); gap: 16px; }
+.card { background: #fff; border: 1px solid #dde1e6; border-radius: 4px; padding: 12px 16px; }
+.big { font-size: 28px; font-weight: 600; }
+table { width: 100%; border-collapse: collapse; font-size: 13px; }
+th, td { text-align: left; padding: 4px 6px; border-bottom: 1px solid #eef0f2; word-break: break-all; }
+th { color: #666; font-weight: 500; }
+input, select, button { font-size: 13px; padding: 4px 6px; }
+.muted { color: #888; font-size: 12px; }
+.err { color: #b00020; }
+</style>
+</head>
+<body>
+<h1>crawler <span class="muted" id="updated"></span> <span class="err" id="error"></span></h1>
+<div class="grid">
+  <div class="card"><h2>crawl rate</h2><div class="big" id="rate">-</div><div class="muted">pages / minute</div></div>
+  <div class="card"><h2>queue depths</h2><table id="queues"></table></div>
+  <div class="card"><h2>pages by state</h2><table id="states"></table></div>
+  <div class="card"><h2>top domains</h2><table id="domains"></table></div>
+</div>
+<div class="card" style="margin-top:16px"><h2>recent errors</h2><table id="errors"></table></div>
+<div class="card" style="margin-top:16px">
+  <h2>pages</h2>
+  <form id="search">
+    <input id="q" placeholder="url contains" size="40">
+    <select id="state"><option value="">any state</option></select>
+    <button type="submit">search</button>
+    <button type="button" id="prev">&lt;</button><button type="button" id="next">&gt;</button>
+    <span class="muted" id="pageinfo"></span>
+  </form>
+  <table id="pages"></table>
+</div>
+<script>
+(function () {
+  var limit = 50, offset = 0;
+
+  function token() {
+    var t = localStorage.getItem("crawler_token");
+    return t === null ? "" : t;
+  }
+
+  function get(path) {
+    var headers = {};
+    if (token()) { headers["Authorization"] = "Bearer " + token(); }
+    return fetch(path, { headers: headers }).then(function (resp) {
+      if (resp.status === 401) {
+        var t = prompt("api token");
+        if (t !== null) { localStorage.setItem("crawler_token", t); }
+        throw new Error("unauthorized");
+      }
+      return resp.json().then(function (body) {
+        if (!resp.ok) { throw new Error(body.error || resp.statusText); }
+        return body;
+      });
+    });
+  }
+
+  function esc(s) {
+    return String(s === undefined || s === null ? "" : s).replace(/[&<>"]/g, function (c) {
+      return { "&": "&amp;", "<": "&lt;", ">": "&gt;", "\"": "&quot;" }[c];
+    });
+  }
+
+  function table(id, head, rows) {
+    var html = "<tr>" + head.map(function (h) { return "<th>" + esc(h) + "</th>"; }).join("") + "</tr>";
+    rows.forEach(function (r) {
+      html += "<tr>" + r.map(function (c) { return "<td>" + esc(c) + "</td>"; }).join("") + "</tr>";
+    });
+    document.getElementById(id).innerHTML = html;
+  }
+
+  function entries(obj) {
+    return Object.keys(obj || {}).sort().map(function (k) { return [k, obj[k]]; });
+  }
+
+  function time(t) {
+    return !t || t.indexOf("0001-") === 0 ? "" : new Date(t).toLocaleString();
+  }
+
+  function refresh() {
+    get("/api/stats").then(function (s) {
+      document.getElementById("rate").textContent = s.crawl_rate.toFixed(1);
+      table("queues", ["queue", "depth"], entries(s.queue_depths));
+      table("states", ["state", "pages"], entries(s.states));
+      table("domains", ["domain", "pages"], (s.top_domains || []).map(function (d) { return [d.domain, d.count]; }));
+      table("errors", ["remark", "pages", "latest"], (s.recent_errors || []).map(function (e) {
+        return [e.remark, e.count, time(e.latest)];
+      }));
+      document.getElementById("updated").textContent = "updated " + new Date().toLocaleTimeString();
+      document.getElementById("error").textContent = "";
+    }).catch(function (e) {
+      document.getElementById("error").textContent = e.message;
+    });
+  }
+
+  function search() {
+    var q = "/api/pages/search?q=" + encodeURIComponent(document.getElementById("q").value) +
+      "&state=" + document.getElementById("state").value + "&offset=" + offset + "&limit=" + limit;
+    get(q).then(function (pages) {
+      table("pages", ["url", "state", "depth", "fetched", "remark"], pages.map(function (p) {
+        return [p.url, p.state, p.depth, time(p.fetched_at), p.remark];
+      }));
+      document.getElementById("pageinfo").textContent = (offset + 1) + " - " + (offset + pages.length);
+    }).catch(function (e) {
+      document.getElementById("error").textContent = e.message;
+    });
+  }
+
+  document.getElementById("search").addEventListener("submit", function (e) {
+    e.preventDefault();
+    offset = 0;
+    search();
+  });
+  document.getElementById("prev").addEventListener("click", function () {
+    offset = Math.max(0, offset - limit);
+    search();
+  });
+  document.getElementById("next").addEventListener("click", function () {
+    offset += limit;
+    search();
+  });
+
+  function states() {
+    get("/api/states").then(function (states) {
+      var html = "<option value=\"\">any state</option>";
+      states.forEach(function (st) {
+        html += "<option value=\"" + st.value + "\">" + esc(st.name) + "</option>";
+      });
+      document.getElementById("state").innerHTML = html;
+    }).catch(function (e) {
+      document.getElementById("error").textContent = e.message;
+    });
+  }
+
+  refresh();
+  states();
+  search();
+  setInterval(refresh, 5000);
+})();
+</script>
+</body>
+</html>
+`
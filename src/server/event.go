@@ -0,0 +1,49 @@
+package server
+
+import (
+	"time"
+
+	"github.com/andrewyi/crawler/src/enum"
+	"github.com/andrewyi/crawler/src/event"
+)
+
+// 所有角色使用相同的sink配置，每个进程只发送自己产生的事件
+func (s *Server) initEvents() error {
+	cfg := s.config.Events
+	var sinks = make([]event.SinkConfig, 0, len(cfg.Sinks))
+	for _, c := range cfg.Sinks {
+		sinks = append(sinks, event.SinkConfig(c))
+	}
+	bus, err := event.NewBus(sinks, int(cfg.Buffer), s.logger)
+	if err != nil {
+		return err
+	}
+	s.events = bus
+	return nil
+}
+
+// 因任务完成而关闭时发送，此时流水线已经排空，附带各状态的页面数量
+func (s *Server) emitCompleted() {
+	var data = make(map[string]interface{})
+	if states, err := s.pagesByState(); err != nil {
+		s.logger.WithError(err).Error("fail to count pages by state")
+	} else {
+		var pages = make(map[string]int64)
+		for state, n := range states {
+			pages[enum.PageStateName(int(state))] = n
+		}
+		data["pages"] = pages
+	}
+	s.events.Emit(event.Event{
+		Type: enum.EventCrawlCompleted,
+		Data: data,
+	})
+}
+
+func (s *Server) closeEvents() {
+	timeout := time.Duration(s.config.Events.FlushTimeout) * time.Second
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	s.events.Close(timeout)
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/andrewyi/crawler/src/dbstorage"
+	"github.com/andrewyi/crawler/src/history"
+	"github.com/andrewyi/crawler/src/util"
+)
+
+// ChangeHook 被监控的url产生新版本时执行，此时新版本已经写入数据库
+type ChangeHook func(ctx context.Context, change history.Change) error
+
+type namedChangeHook struct {
+	name string
+	hook ChangeHook
+}
+
+// 按照注册顺序执行，需要在Start之前调用，配置中的storage.history.monitor.commands排在最后
+// 仅在controller角色中、且url匹配storage.history.monitor.urls时执行
+func (s *Server) OnChanged(name string, hook ChangeHook) {
+	s.changeHooks = append(s.changeHooks, namedChangeHook{name: name, hook: hook})
+}
+
+// 仅在controller角色中调用
+func (s *Server) initHistory() error {
+	cfg := s.config.Storage.History
+	if cfg.Location == "" {
+		return nil
+	}
+	monitor, err := history.NewMonitor(cfg.Monitor.URLs)
+	if err != nil {
+		return err
+	}
+	s.monitor = monitor
+	for _, c := range cfg.Monitor.Commands {
+		s.changeHooks = append(s.changeHooks, namedChangeHook{name: c, hook: s.changeCommandHook(c)})
+	}
+	return nil
+}
+
+// 除CRAWLER_CONFIG之外，通过环境变量传递变化的url、job及前后的版本号
+func (s *Server) changeCommandHook(command string) ChangeHook {
+	return func(ctx context.Context, change history.Change) error {
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Env = append(os.Environ(),
+			"CRAWLER_CONFIG="+s.configPath,
+			"CRAWLER_URL="+change.URL,
+			"CRAWLER_JOB="+change.JobID,
+			"CRAWLER_VERSION="+strconv.FormatUint(uint64(change.Version), 10),
+			"CRAWLER_PREVIOUS_VERSION="+strconv.FormatUint(uint64(change.Previous), 10),
+		)
+		return cmd.Run()
+	}
+}
+
+// 由controller在新版本提交之后调用，hook在单独的协程中依次执行，不阻塞controller
+// 执行完成之前不会认为任务已经结束
+func (s *Server) notifyChange(change history.Change) {
+	if s.monitor == nil || !s.monitor.Match(change.URL) {
+		return
+	}
+	logger := s.logger.WithField("url", change.URL).WithField("version", change.Version)
+	logger.WithField("previous", change.Previous).Info("monitored url changed")
+	if len(s.changeHooks) == 0 {
+		return
+	}
+
+	release := s.work.Hold()
+	go func() {
+		defer release()
+		for _, h := range s.changeHooks {
+			if err := s.runChangeHook(h, change); err != nil {
+				logger.WithError(err).WithField("hook", h.name).Error("change hook failed")
+			}
+		}
+	}()
+}
+
+func (s *Server) runChangeHook(h namedChangeHook, change history.Change) error {
+	ctx := context.Background()
+	if timeout := s.config.Storage.History.Monitor.Timeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		defer cancel()
+	}
+	return h.hook(ctx, change)
+}
+
+type versionView struct {
+	Version     uint32    `json:"version"`
+	Job         string    `json:"job"`
+	ContentHash string    `json:"content_hash"`
+	StoragePath string    `json:"storage_path"`
+	TextPath    string    `json:"text_path,omitempty"`
+	FetchedAt   time.Time `json:"fetched_at"`
+	SeenAt      time.Time `json:"seen_at"`
+}
+
+// /api/history?url=a.com/x，url的所有版本，按版本号顺序
+func (s *Server) handleListVersions(w http.ResponseWriter, r *http.Request) {
+	nURL, err := util.ShortifyURL(r.URL.Query().Get("url"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	t, err := s.dbStorage.NewTransaction()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer t.Rollback()
+
+	versions, err := t.GetPageVersions(nURL)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if len(versions) == 0 {
+		writeError(w, http.StatusNotFound, dbstorage.ErrDataNotExist)
+		return
+	}
+	var views = make([]versionView, 0, len(versions))
+	for _, v := range versions {
+		views = append(views, versionView{
+			Version:     v.Version,
+			Job:         v.JobID,
+			ContentHash: v.ContentHash,
+			StoragePath: v.StoragePath,
+			TextPath:    v.TextPath,
+			FetchedAt:   v.FetchedAt,
+			SeenAt:      v.SeenAt,
+		})
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+// /api/history/diff?url=a.com/x&from=1&to=2&context=3
+// to为空时为最新的版本，from为空时为to的上一个版本，diff为unified格式，内容相同时为空
+func (s *Server) handleDiffVersions(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	nURL, err := util.ShortifyURL(q.Get("url"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var nums = map[string]int{"from": 0, "to": 0, "context": 3}
+	for name := range nums {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid %s: %s", name, v))
+				return
+			}
+			nums[name] = n
+		}
+	}
+
+	t, err := s.dbStorage.NewTransaction()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer t.Rollback()
+
+	from, to, err := history.Versions(t, nURL, uint32(nums["from"]), uint32(nums["to"]))
+	if err == dbstorage.ErrDataNotExist {
+		writeError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	diff, err := history.Diff(from, to, nums["context"])
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"url":  nURL,
+		"from": from.Version,
+		"to":   to.Version,
+		"diff": diff,
+	})
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// PostCrawlHook 爬取任务完成后、进程退出前执行，此时流水线已经排空，数据库连接仍然可用
+type PostCrawlHook func(ctx context.Context) error
+
+type namedHook struct {
+	name string
+	hook PostCrawlHook
+}
+
+// 按照注册顺序执行，需要在Start之前调用，配置中的core.post_crawl.commands排在最后
+func (s *Server) OnFinished(name string, hook PostCrawlHook) {
+	s.hooks = append(s.hooks, namedHook{name: name, hook: hook})
+}
+
+// 命令在子进程中执行，输出直接写入当前进程的stdout/stderr
+// 通过CRAWLER_CONFIG环境变量获取当前使用的配置文件
+func (s *Server) commandHook(command string) PostCrawlHook {
+	return func(ctx context.Context) error {
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Env = append(os.Environ(), "CRAWLER_CONFIG="+s.configPath)
+		return cmd.Run()
+	}
+}
+
+// 某个hook失败时记录日志并继续执行后续的hook
+func (s *Server) runPostCrawlHooks() {
+	var hooks = s.hooks
+	for _, c := range s.config.Core.PostCrawl.Commands {
+		hooks = append(hooks, namedHook{name: c, hook: s.commandHook(c)})
+	}
+	if len(hooks) == 0 {
+		return
+	}
+
+	ctx := context.Background()
+	if timeout := s.config.Core.PostCrawl.Timeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		defer cancel()
+	}
+	for _, h := range hooks {
+		start := time.Now()
+		logger := s.logger.WithField("hook", h.name)
+		if err := h.hook(ctx); err != nil {
+			logger.WithError(err).Error("post crawl hook failed")
+			continue
+		}
+		logger.WithField("elapsed", time.Since(start)).Info("post crawl hook finished")
+	}
+}
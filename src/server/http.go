@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/andrewyi/crawler/src/metrics"
+)
+
+// 未配置监听地址时不启动http服务
+func (s *Server) startHTTP() error {
+	if s.config.HTTP.Listen == "" {
+		return nil
+	}
+
+	if err := metrics.RegisterStateCollector(s.QueueDepths, s.pagesByState); err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	s.registerAPI(mux)
+	s.registerDashboard(mux)
+
+	s.httpServer = &http.Server{
+		Addr:    s.config.HTTP.Listen,
+		Handler: mux,
+	}
+	go func() {
+		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			s.logger.WithError(err).Error("http server exited")
+		}
+	}()
+	s.logger.WithField("listen", s.config.HTTP.Listen).Info("http server started")
+	return nil
+}
+
+func (s *Server) stopHTTP() {
+	if s.httpServer == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		s.logger.WithError(err).Error("fail to shutdown http server")
+	}
+}
+
+func (s *Server) pagesByState() (map[uint8]int64, error) {
+	t, err := s.dbStorage.NewTransaction()
+	if err != nil {
+		return nil, err
+	}
+	defer t.Rollback()
+	return t.GetPageCountByState()
+}
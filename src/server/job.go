@@ -0,0 +1,100 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/andrewyi/crawler/src/dbstorage"
+	"github.com/andrewyi/crawler/src/entity"
+	"github.com/andrewyi/crawler/src/enum"
+	"github.com/andrewyi/crawler/src/frontier"
+	"github.com/andrewyi/crawler/src/job"
+)
+
+// core角色负责维护default job（crawl命令的seed文件及全局配置），之后所有角色载入全部job
+func (s *Server) initJobs() error {
+	cfg := s.config
+	if cfg.Core.JobSyncPeriod == 0 {
+		return fmt.Errorf("core.job_sync_period must be greater than 0")
+	}
+	s.jobs = job.NewRegistry(s.dbStorage)
+	if s.hasRole(enum.RoleCore) {
+		if err := job.EnsureDefault(s.dbStorage, cfg.Controller.Depth, cfg.Storage.Location, cfg.Storage.TextLocation); err != nil {
+			return fmt.Errorf("fail to create default job, err: %w", err)
+		}
+	}
+	return s.jobs.Load()
+}
+
+// 每个job使用单独的url队列，default job沿用原有的队列名称
+func (s *Server) newURLFrontier(scorer frontier.Scorer) frontier.Frontier {
+	cfg := s.config.Core
+	create := func(jobID string) (frontier.Frontier, error) {
+		name := enum.QueueURL
+		if jobID != enum.DefaultJob {
+			name = enum.QueueURL + "-" + jobID
+		}
+		q, err := s.newQueue(name, cfg.URLQueueSize)
+		if err != nil {
+			return nil, err
+		}
+		return frontier.NewSimpleFrontier(q, scorer), nil
+	}
+	pollInterval := time.Duration(cfg.QueuePollInterval) * time.Millisecond
+	return frontier.NewFairFrontier(create, s.jobs.Schedulable, enum.DefaultJob, pollInterval)
+}
+
+// core角色中由job.Manager定时载入并维护job状态，其他进程仅定时载入
+func (s *Server) startJobSync() {
+	period := time.Duration(s.config.Core.JobSyncPeriod) * time.Second
+	if !s.hasRole(enum.RoleCore) {
+		s.jobs.Run(s.ctx, period, s.logger)
+		return
+	}
+	// 内存及磁盘队列启动时已经提交过数据库中所有pending的url
+	restored := s.config.Core.Queue != enum.QueueTypePostgres
+	m := job.NewManager(s.jobs, s.dbStorage, s.logger, s.config.Core.FailureRetry.MaxRetries, restored, s.submitJob, s.events)
+	m.Run(s.ctx, period)
+}
+
+// 通过命令行创建的job开始运行，提交其seed（以及之前加入的所有pending url）
+func (s *Server) submitJob(jobID string) {
+	release := s.work.Hold()
+	go func() {
+		defer release()
+		filter := dbstorage.PageFilter{State: enum.PageStatePending, JobID: jobID}
+		count, err := s.resubmitPending(filter, 0)
+		if err != nil && s.ctx.Err() == nil {
+			s.logger.WithError(err).WithField("job", jobID).Error("fail to submit job")
+			return
+		}
+		s.logger.WithField("job", jobID).WithField("count", count).Info("job submitted")
+	}()
+}
+
+// downloader取出url之后检查所属job的状态，返回false时不下载
+// 暂停的job的url放回队列，恢复后继续；取消、结束及不存在的job的url直接丢弃，页面保持pending
+// 无法获取job时继续下载，避免数据库短暂不可用导致任务丢失
+func (s *Server) runnable(task entity.URLTask) bool {
+	j, err := s.jobs.Get(task.JobID)
+	if err == dbstorage.ErrDataNotExist {
+		s.logger.WithField("job", task.JobID).WithField("url", task.URL).Warn("job not found, url dropped")
+		return false
+	}
+	if err != nil {
+		s.logger.WithError(err).WithField("job", task.JobID).Error("fail to get job")
+		return true
+	}
+	switch j.State {
+	case enum.JobStateRunning:
+		return true
+	case enum.JobStatePaused:
+		if err = s.urlFrontier.Push(s.ctx, task); err != nil && s.ctx.Err() == nil {
+			s.logger.WithError(err).WithField("url", task.URL).Error("fail to push back url of paused job")
+		}
+		return false
+	default:
+		s.logger.WithField("job", task.JobID).WithField("url", task.URL).Debug("job is not running, url dropped")
+		return false
+	}
+}
@@ -0,0 +1,244 @@
+// 页面租约：downloader取出url时获取租约，同一个页面同一时间只会被一个进程下载
+// 进程只续期处理中的任务持有的租约；使用postgres队列时，下游stage从队列中取出页面后接管租约
+// 任务没有写入结果就结束时（数据库错误、写入队列失败、panic等）租约立即过期，由core的回收任务重新提交
+// 进程退出或卡住后租约不再续期，到期后同样由回收任务重新提交
+package server
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/andrewyi/crawler/src/dbstorage"
+	"github.com/andrewyi/crawler/src/enum"
+	"github.com/andrewyi/crawler/src/job"
+	"github.com/andrewyi/crawler/src/util"
+)
+
+const (
+	// 每个租约有效期内续期的次数，保证偶尔一次续期失败不会导致租约过期
+	leaseRenewsPerTTL = 3
+	// 单条续期语句包含的页面数量上限
+	leaseRenewBatch = 500
+)
+
+// 当前进程中处理中的任务持有的租约
+// 同一页面可能同时出现在多个任务中（队列中的重复任务），全部结束之后才不再续期
+type heldLeases struct {
+	mu     sync.Mutex
+	counts map[dbstorage.PageKey]int
+}
+
+func newHeldLeases() *heldLeases {
+	return &heldLeases{counts: make(map[dbstorage.PageKey]int)}
+}
+
+func (h *heldLeases) add(key dbstorage.PageKey) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[key]++
+}
+
+// 返回true表示这是该页面的最后一个任务
+func (h *heldLeases) remove(key dbstorage.PageKey) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n, ok := h.counts[key]
+	if !ok {
+		return false
+	}
+	if n > 1 {
+		h.counts[key] = n - 1
+		return false
+	}
+	delete(h.counts, key)
+	return true
+}
+
+func (h *heldLeases) list() []dbstorage.PageKey {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var keys = make([]dbstorage.PageKey, 0, len(h.counts))
+	for k := range h.counts {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func leaseKey(jobID string, url string) (dbstorage.PageKey, bool) {
+	nURL, err := util.ShortifyURL(url)
+	if err != nil {
+		return dbstorage.PageKey{}, false
+	}
+	return dbstorage.PageKey{JobID: job.Normalize(jobID), URL: nURL}, true
+}
+
+// 主机名-进程号-随机数，随机数用于区分重启后的同名进程
+func newLeaseOwner() string {
+	host, _ := os.Hostname()
+	var b = make([]byte, 4)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%s-%d-%x", host, os.Getpid(), b)
+}
+
+func (s *Server) leaseTTL() time.Duration {
+	return time.Duration(s.config.Core.TaskTimeout) * time.Second
+}
+
+// 返回false时任务无需处理：页面已经处理完成，或者正在被其他进程处理（队列中的重复任务）
+// 数据库错误时仍然继续处理，避免任务丢失
+// 返回true时任务结束后需要调用handOffLease、parkPage或者endLease之一
+func (s *Server) acquireLease(jobID string, url string) bool {
+	key, ok := leaseKey(jobID, url)
+	if !ok {
+		return true
+	}
+	t, err := s.dbStorage.NewTransaction()
+	if err != nil {
+		s.logger.WithError(err).WithField("url", url).Error("fail to start transaction")
+		return true
+	}
+	defer t.Rollback()
+
+	now := time.Now()
+	acquired, err := t.AcquireLease(key.JobID, key.URL, s.leaseOwner, now, now.Add(s.leaseTTL()))
+	if err == nil {
+		err = t.Commit()
+	}
+	if err != nil {
+		s.logger.WithError(err).WithField("url", url).Error("fail to acquire lease")
+		return true
+	}
+	if acquired {
+		s.leases.add(key)
+	}
+	return acquired
+}
+
+// 仅在使用postgres队列时接管，本地队列中的页面始终由当前进程持有
+// 接管之后同样需要调用handOffLease或者endLease之一
+func (s *Server) claimLease(jobID string, url string) {
+	if s.config.Core.Queue != enum.QueueTypePostgres {
+		return
+	}
+	if key, ok := leaseKey(jobID, url); ok {
+		s.leases.add(key)
+	}
+	s.updateLease(jobID, url, "claim")
+}
+
+// 任务已经写入下游队列
+// postgres队列时由下游接管租约，在此之前不再续期（消息可能随消费者崩溃丢失），接管不及时则由回收任务重新提交
+// 本地队列中的页面仍由当前进程持有，直到下游stage结束任务
+func (s *Server) handOffLease(jobID string, url string) {
+	if s.config.Core.Queue != enum.QueueTypePostgres {
+		return
+	}
+	if key, ok := leaseKey(jobID, url); ok {
+		s.leases.remove(key)
+	}
+}
+
+// 任务结束，不再续期；页面仍为pending时（没有写入结果）租约立即过期，由回收任务重新提交
+// 页面已经写入结果时数据库中的租约不再有效，不做修改
+func (s *Server) endLease(jobID string, url string) {
+	key, ok := leaseKey(jobID, url)
+	if !ok || !s.leases.remove(key) {
+		return
+	}
+	s.updateLease(jobID, url, "expire")
+}
+
+// 放弃租约后页面不会被回收任务重新提交
+func (s *Server) releaseLease(jobID string, url string) {
+	s.updateLease(jobID, url, "release")
+}
+
+// 域名熔断时搁置页面并放弃租约，域名恢复后由resubmitDomain重新提交
+func (s *Server) parkPage(jobID string, url string) {
+	if key, ok := leaseKey(jobID, url); ok {
+		s.leases.remove(key)
+	}
+	s.updateLease(jobID, url, "park")
+}
+
+func (s *Server) updateLease(jobID string, url string, op string) {
+	key, ok := leaseKey(jobID, url)
+	if !ok {
+		return
+	}
+	t, err := s.dbStorage.NewTransaction()
+	if err != nil {
+		s.logger.WithError(err).WithField("url", url).Error("fail to start transaction")
+		return
+	}
+	defer t.Rollback()
+
+	switch op {
+	case "claim":
+		err = t.ClaimLease(key.JobID, key.URL, s.leaseOwner, time.Now().Add(s.leaseTTL()))
+	case "park":
+		err = t.ParkPage(key.JobID, key.URL, s.leaseOwner)
+	case "expire":
+		err = t.ExpireLease(key.JobID, key.URL, s.leaseOwner, time.Now())
+	default:
+		err = t.ReleaseLease(key.JobID, key.URL, s.leaseOwner)
+	}
+	if err == nil {
+		err = t.Commit()
+	}
+	if err != nil {
+		s.logger.WithError(err).WithField("url", url).WithField("op", op).Error("fail to update lease")
+	}
+}
+
+// 定时续期处理中的任务持有的租约，直到s.ctx结束
+func (s *Server) startLeaseRenewal() {
+	ticker := time.NewTicker(s.leaseTTL() / leaseRenewsPerTTL)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+				s.renewLeases()
+			}
+		}
+	}()
+}
+
+func (s *Server) renewLeases() {
+	keys := s.leases.list()
+	if len(keys) == 0 {
+		return
+	}
+	t, err := s.dbStorage.NewTransaction()
+	if err != nil {
+		s.logger.WithError(err).Error("fail to start transaction")
+		return
+	}
+	defer t.Rollback()
+
+	until := time.Now().Add(s.leaseTTL())
+	var n int64
+	for start := 0; start < len(keys) && err == nil; start += leaseRenewBatch {
+		end := start + leaseRenewBatch
+		if end > len(keys) {
+			end = len(keys)
+		}
+		var renewed int64
+		renewed, err = t.RenewLeases(s.leaseOwner, until, keys[start:end])
+		n += renewed
+	}
+	if err == nil {
+		err = t.Commit()
+	}
+	if err != nil {
+		s.logger.WithError(err).Error("fail to renew leases")
+		return
+	}
+	s.logger.WithField("count", n).Debug("leases renewed")
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"gopkg.in/urfave/cli.v1"
+
+	"github.com/andrewyi/crawler/src/enum"
+	"github.com/andrewyi/crawler/src/scheduler"
+)
+
+// Daemon 常驻运行：按照scheduler.schedules定时开始新的一代，任务完成后不退出
+func (s *Server) Daemon(ctx *cli.Context) error {
+	s.daemon = true
+	return s.Start(ctx)
+}
+
+// 仅在daemon模式下的core角色中创建
+func (s *Server) initScheduler() error {
+	cfg := s.config
+	var schedules = make([]scheduler.Schedule, 0, len(cfg.Scheduler.Schedules))
+	for _, c := range cfg.Scheduler.Schedules {
+		schedules = append(schedules, scheduler.Schedule(c))
+	}
+	defaults := scheduler.Defaults{
+		Depth:        cfg.Controller.Depth,
+		Location:     cfg.Storage.Location,
+		TextLocation: cfg.Storage.TextLocation,
+	}
+	sch, err := scheduler.NewScheduler(schedules, defaults, s.dbStorage, s.logger)
+	if err != nil {
+		return err
+	}
+	s.scheduler = sch
+	return nil
+}
+
+func (s *Server) startScheduler() {
+	if len(s.scheduler.Names()) == 0 {
+		s.logger.Warn("no schedule configured, only jobs created by command are crawled")
+	}
+	s.scheduler.Run(s.ctx, time.Duration(s.config.Core.JobSyncPeriod)*time.Second)
+}
+
+type generationView struct {
+	Schedule   string    `json:"schedule"`
+	Generation uint32    `json:"generation"`
+	Job        string    `json:"job"`
+	State      string    `json:"state"`
+	Success    int64     `json:"success"`
+	Failed     int64     `json:"failed"`
+	Previous   string    `json:"previous,omitempty"`
+	Added      int64     `json:"added"`
+	Removed    int64     `json:"removed"`
+	Changed    int64     `json:"changed"`
+	CreatedAt  time.Time `json:"created_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// /api/generations?schedule=xxx&limit=n，schedule为空时返回所有schedule，按时间倒序
+// 运行中的一代只记录了开始时间，统计在结束时写入
+func (s *Server) handleListGenerations(w http.ResponseWriter, r *http.Request) {
+	_, limit := pagination(r)
+	t, err := s.dbStorage.NewTransaction()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer t.Rollback()
+
+	gens, err := t.GetGenerations(r.URL.Query().Get("schedule"), limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	var views = make([]generationView, 0, len(gens))
+	for _, g := range gens {
+		views = append(views, generationView{
+			Schedule:   g.Schedule,
+			Generation: g.Generation,
+			Job:        g.JobID,
+			State:      enum.GenerationStateName(int(g.State)),
+			Success:    g.Success,
+			Failed:     g.Failed,
+			Previous:   g.Previous,
+			Added:      g.Added,
+			Removed:    g.Removed,
+			Changed:    g.Changed,
+			CreatedAt:  g.CreatedAt,
+			FinishedAt: g.FinishedAt,
+		})
+	}
+	writeJSON(w, http.StatusOK, views)
+}
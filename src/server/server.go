@@ -2,23 +2,35 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
+	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/urfave/cli.v1"
 
-	"github.com/andrewyi/crawler/src/analyzer"
+	"github.com/andrewyi/crawler/src/breaker"
 	"github.com/andrewyi/crawler/src/config"
-	"github.com/andrewyi/crawler/src/controller"
 	"github.com/andrewyi/crawler/src/core"
 	"github.com/andrewyi/crawler/src/dbstorage"
+	"github.com/andrewyi/crawler/src/dbstorage/schema"
 	"github.com/andrewyi/crawler/src/downloader"
 	"github.com/andrewyi/crawler/src/entity"
+	"github.com/andrewyi/crawler/src/enum"
+	"github.com/andrewyi/crawler/src/event"
+	"github.com/andrewyi/crawler/src/fingerprint"
+	"github.com/andrewyi/crawler/src/frontier"
+	"github.com/andrewyi/crawler/src/history"
+	"github.com/andrewyi/crawler/src/index"
+	"github.com/andrewyi/crawler/src/job"
+	"github.com/andrewyi/crawler/src/metrics"
+	"github.com/andrewyi/crawler/src/queue"
 	"github.com/andrewyi/crawler/src/routingpool"
-	"github.com/andrewyi/crawler/src/util"
+	"github.com/andrewyi/crawler/src/scheduler"
+	"github.com/andrewyi/crawler/src/tracker"
 )
 
 type Server struct {
@@ -27,15 +39,61 @@ type Server struct {
 	logger *log.Logger
 	config *config.Config
 
-	urlQueue        chan string
-	pageQueue       chan entity.PageInfo
-	parsedPageQueue chan entity.ParsedPageInfo
+	// 当前进程运行的角色，多个进程通过共享队列协作完成同一个爬取任务
+	roles map[string]bool
+
+	urlFrontier     frontier.Frontier
+	pageQueue       queue.Queue
+	parsedPageQueue queue.Queue
 
 	downloader routingpool.RoutingPool
 	analyzer   routingpool.RoutingPool
 	controller routingpool.RoutingPool
 
 	dbStorage *dbstorage.SimpleDBStorage
+	// 所有job，由所有worker共享，见job.go
+	jobs *job.Registry
+	// 仅在daemon模式下的core角色中创建，见schedule.go
+	daemon    bool
+	scheduler *scheduler.Scheduler
+
+	// 仅在启用index且运行controller角色时打开，由所有controller worker共享
+	index index.Index
+
+	// 仅在启用render时创建，由所有downloader worker共享
+	browser     *downloader.Browser
+	renderRules []*downloader.Rule
+	hostLimiter *downloader.HostLimiter
+	// 仅在启用breaker时创建，由所有downloader worker共享
+	breaker *breaker.DomainBreaker
+	// 仅在配置了认证时创建，由所有downloader worker共享
+	authenticator *downloader.Authenticator
+
+	// 用于downloader worker池的自动扩缩容
+	downloadLatency *routingpool.LatencyWindow
+
+	// 每个stage单独的任务获取ctx，关闭时按照流水线顺序依次取消，从而在退出前排空下游队列
+	intakes map[string]*intake
+	// downloader已经取出但尚未写入page队列的任务
+	inflight *inflightTasks
+
+	// 当前进程持有的页面租约的owner，以及处理中的任务持有的租约，见lease.go
+	leaseOwner string
+	leases     *heldLeases
+
+	// 进程内的工作量统计，postgres队列时为nil，见work.go
+	work *tracker.Tracker
+	// 任务完成后执行的hook，收到信号退出时不执行
+	hooks     []namedHook
+	completed bool
+	// 爬取事件，没有配置sink时为nil，见event.go
+	events *event.Bus
+	// 被监控的url产生新版本时执行的hook，仅在controller角色中保存历史时使用，见history.go
+	changeHooks []namedChangeHook
+	monitor     *history.Monitor
+
+	configPath string
+	httpServer *http.Server
 
 	finished chan struct{}
 }
@@ -43,9 +101,14 @@ type Server struct {
 func NewServer() *Server {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &Server{
-		ctx:      ctx,
-		cancel:   cancel,
-		finished: make(chan struct{}),
+		ctx:             ctx,
+		cancel:          cancel,
+		downloadLatency: &routingpool.LatencyWindow{},
+		intakes:         make(map[string]*intake),
+		inflight:        newInflightTasks(),
+		leaseOwner:      newLeaseOwner(),
+		leases:          newHeldLeases(),
+		finished:        make(chan struct{}),
 	}
 }
 
@@ -72,131 +135,466 @@ func (s *Server) initLog() {
 func (s *Server) Start(ctx *cli.Context) error {
 	var err error
 
-	configPath := ctx.String("config")
-	var cfg = &config.Config{}
-	if err = util.ReadConfig(configPath, cfg); err != nil {
-		return fmt.Errorf("fail to load config, err: %w", err)
+	s.configPath = ctx.GlobalString("config")
+	cfg, err := config.Load(s.configPath)
+	if err != nil {
+		return err
 	}
 	s.config = cfg
 
 	s.initLog()
+	if err = s.initEvents(); err != nil {
+		return err
+	}
 
-	// downloader从中读取url
-	urlQueue := make(chan string, cfg.Core.URLQueueSize)
-	// downloader下载的内容将被放入此queue，并由analyzer读取
-	pageQueue := make(chan entity.PageInfo, cfg.Core.PageInfoQueueSize)
-	// analyzer分析好的内容将被放入此queue，并由controller读取
-	parsedPageQueue := make(chan entity.ParsedPageInfo, cfg.Core.ParsedPageInfoQueueSize)
-
-	s.downloader = routingpool.NewSimpleRoutingPool(
-		s.ctx,
-		cfg.Downloader.Worker,
-		func(ctx context.Context) {
-			d := downloader.NewSimpleDownloader(ctx, cfg.Downloader.Timeout, cfg.Downloader.Retry)
-			for {
-				select {
-				case <-ctx.Done():
-					break
-				case url := <-urlQueue:
-					page := d.Download(url)
-					pageQueue <- page
-				}
-			}
-		},
-	)
-
-	s.analyzer = routingpool.NewSimpleRoutingPool(
-		s.ctx,
-		cfg.Analyzer.Worker,
-		func(ctx context.Context) {
-			a := analyzer.NewSimpleAnalyzer(ctx)
-			for {
-				select {
-				case <-ctx.Done():
-					break
-				case page := <-pageQueue:
-					parsedPage := a.Analyze(page)
-					parsedPageQueue <- parsedPage
-				}
-			}
-		},
-	)
+	// 不指定子命令运行时--role为全局参数
+	role := ctx.String("role")
+	if role == "" {
+		role = ctx.GlobalString("role")
+	}
+	if s.roles, err = parseRoles(role); err != nil {
+		return err
+	}
 
-	// 不仅controller需要用到dbstorage，后续的相关也需要此handler
+	// 所有角色都需要用到dbstorage（postgres队列同样基于此handler）
 	dbStorage, err := dbstorage.NewSimpleDBStorage(cfg.Database.URL)
 	if err != nil {
 		// 无法恢复的灾难，直接终止
 		s.logger.WithError(err).Fatal("fail to create dbstorage handler")
 	}
 	s.dbStorage = dbStorage
+	if err = dbStorage.Sync(); err != nil {
+		s.logger.WithError(err).Fatal("fail to sync database schema")
+	}
 
-	s.controller = routingpool.NewSimpleRoutingPool(
-		s.ctx,
-		cfg.Controller.Worker,
-		func(ctx context.Context) {
-			c := controller.NewSimpleController(ctx, cfg.Controller.Depth, cfg.Storage.Location, dbStorage, s.logger)
-			for {
-				select {
-				case <-ctx.Done():
-					break
-				case parsedPage := <-parsedPageQueue:
-					// 与其他queue 1:1的请求/结果不同，这里一个请求对应多个结果（解析出多个sub url）
-					urls := c.Process(parsedPage)
-					for _, u := range urls {
-						urlQueue <- u
-					}
-				}
+	if cfg.Core.TaskTimeout == 0 {
+		return fmt.Errorf("core.task_timeout must be greater than 0")
+	}
+	if err = s.initJobs(); err != nil {
+		return err
+	}
+	if s.daemon && s.hasRole(enum.RoleCore) {
+		if err = s.initScheduler(); err != nil {
+			return err
+		}
+	}
+	if err = s.initQueues(); err != nil {
+		return err
+	}
+	s.initTracker()
+	// 需要在启动worker之前载入，避免与重试提交产生竞争
+	if err = s.loadDeferred(); err != nil {
+		s.logger.WithError(err).Fatal("fail to load retryable pages")
+	}
+	s.startLeaseRenewal()
+	s.reportQueueDepths()
+
+	if err = s.startHTTP(); err != nil {
+		return fmt.Errorf("fail to start http server, err: %w", err)
+	}
+
+	if s.hasRole(enum.RoleDownloader) {
+		if cfg.Downloader.Render.Enabled {
+			if err = s.initBrowser(); err != nil {
+				s.logger.WithError(err).Fatal("fail to start headless browser")
+			}
+		}
+		s.hostLimiter = downloader.NewHostLimiter(cfg.Downloader.HostRate)
+		if err = s.initAuth(); err != nil {
+			return fmt.Errorf("invalid downloader.auth, err: %w", err)
+		}
+		if cfg.Downloader.Breaker.Enabled {
+			if cfg.Downloader.Breaker.Threshold == 0 || cfg.Downloader.Breaker.Cooldown == 0 {
+				return fmt.Errorf("downloader.breaker.threshold and cooldown must be greater than 0")
+			}
+			if err = s.initBreaker(); err != nil {
+				s.logger.WithError(err).Fatal("fail to load domain states")
+			}
+		}
+		s.downloader = s.newPool(enum.RoleDownloader, cfg.Downloader.Worker, s.downloadWorker)
+		if err = s.downloader.Start(); err != nil {
+			s.logger.WithError(err).Fatal("fail to start downloader")
+		}
+		if cfg.Downloader.Autoscale.Enabled {
+			s.startAutoscaler()
+		}
+	}
+
+	if s.hasRole(enum.RoleAnalyzer) {
+		s.analyzer = s.newPool(enum.RoleAnalyzer, cfg.Analyzer.Worker, s.analyzeWorker)
+		if err = s.analyzer.Start(); err != nil {
+			s.logger.WithError(err).Fatal("fail to start analyzer")
+		}
+	}
+
+	if s.hasRole(enum.RoleController) {
+		if cfg.Controller.Dedup.Enabled && cfg.Controller.Dedup.MaxDistance > fingerprint.MaxDistance {
+			return fmt.Errorf("controller.dedup.max_distance must not exceed %d", fingerprint.MaxDistance)
+		}
+		if cfg.Index.Enabled {
+			if s.index, err = index.NewBleveIndex(cfg.Index.Path, false); err != nil {
+				s.logger.WithError(err).Fatal("fail to open index")
 			}
+		}
+		if err = s.initHistory(); err != nil {
+			return err
+		}
+		s.controller = s.newPool(enum.RoleController, cfg.Controller.Worker, s.controlWorker)
+		if err = s.controller.Start(); err != nil {
+			s.logger.WithError(err).Fatal("fail to start controller")
+		}
+	}
+
+	// 多进程部署时，seed、重试、job管理以及终止探测只需要在一个进程中运行，其他进程仅定时载入job
+	if !s.hasRole(enum.RoleCore) {
+		s.startJobSync()
+	}
+	if s.hasRole(enum.RoleCore) {
+		// 内存及磁盘队列不会保留上一次运行的消息，重新提交数据库中pending的url（包含通过seed/retry命令加入的）
+		// 需要在注入seed之前执行，避免新加入的seed被重复提交
+		if cfg.Core.Queue != enum.QueueTypePostgres {
+			core.RestorePending(s.ctx, s.logger, s.urlFrontier, dbStorage, s.work)
+		}
+
+		// 注入seed url数据，seed文件属于default job，其他job的seed通过job命令加入
+		if cfg.Core.SeedFilePath != "" {
+			core.CreateSeedRecord(s.ctx, s.logger, s.urlFrontier, dbStorage, enum.DefaultJob, cfg.Core.SeedFilePath, s.work)
+		}
+
+		// 启动新建的job，并检查job是否完成或者达到预算
+		s.startJobSync()
+
+		// 设置重试任务
+		core.CreateRetryTask(s.ctx, s.logger, s.urlFrontier, dbStorage, cfg.Core.RetryTaskScanPeriod, cfg.Core.FailureRetry.MaxRetries)
+
+		// daemon模式下定时开始新的一代，不进行终止探测，进程一直运行到收到信号
+		// 设置终止探测，所有角色在同一进程中时根据进程内的统计判断，否则定时查询数据库
+		if s.daemon {
+			s.startScheduler()
+		} else if s.work != nil {
+			s.watchCompletion()
+		} else {
+			core.CreateCheckCompletedTask(s.ctx, s.logger, dbStorage, cfg.Core.CheckCompletedPeriod, cfg.Core.FailureRetry.MaxRetries, s.finished)
+		}
+	}
+
+	s.wait()
+	s.Stop()
+
+	return nil
+}
+
+// worker panic时记录日志，worker会被自动重启
+// worker拿到的ctx为对应stage的intake ctx，仅用于控制是否继续获取任务
+func (s *Server) newPool(name string, size uint32, workerFn func(context.Context)) routingpool.RoutingPool {
+	p := routingpool.NewSimpleRoutingPool(s.intakeCtx(name), size, workerFn)
+	p.OnPanic(func(recovered interface{}, stack []byte) {
+		metrics.PoolRestarts.WithLabelValues(name).Inc()
+		s.logger.WithFields(log.Fields{
+			"pool":  name,
+			"panic": recovered,
+			"stack": string(stack),
+		}).Error("worker panicked, restarting")
+	})
+	return p
+}
+
+// 根据url队列长度以及下载耗时调整downloader的worker数量
+func (s *Server) startAutoscaler() {
+	cfg := s.config.Downloader.Autoscale
+	a := routingpool.NewAutoscaler(
+		s.downloader,
+		cfg.Min,
+		cfg.Max,
+		time.Duration(cfg.Period)*time.Second,
+		time.Duration(cfg.TargetLatency)*time.Millisecond,
+		s.urlFrontier.Len,
+		s.downloadLatency,
+		func(from uint32, to uint32, depth int64, latency time.Duration) {
+			s.logger.WithFields(log.Fields{
+				"from":    from,
+				"to":      to,
+				"depth":   depth,
+				"latency": latency,
+			}).Info("downloader pool resized")
 		},
 	)
+	a.Start(s.ctx)
+}
 
-	err = s.downloader.Start()
-	if err != nil {
-		s.logger.WithError(err).Fatal("fail to start downloader")
+// role为逗号分隔的角色列表，为空时运行所有角色
+func parseRoles(role string) (map[string]bool, error) {
+	var roles = make(map[string]bool)
+	if strings.TrimSpace(role) == "" {
+		for _, r := range enum.Roles {
+			roles[r] = true
+		}
+		return roles, nil
 	}
 
-	err = s.analyzer.Start()
-	if err != nil {
-		s.logger.WithError(err).Fatal("fail to start analyzer")
+	for _, r := range strings.Split(role, ",") {
+		r = strings.TrimSpace(r)
+		var valid bool
+		for _, v := range enum.Roles {
+			if r == v {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("unknown role: %s", r)
+		}
+		roles[r] = true
 	}
+	return roles, nil
+}
+
+func (s *Server) hasRole(role string) bool {
+	return s.roles[role]
+}
+
+func (s *Server) initQueues() error {
+	cfg := s.config.Core
 
-	err = s.controller.Start()
+	scorer, err := frontier.NewScorer(s.config.Frontier.Scorers)
 	if err != nil {
-		s.logger.WithError(err).Fatal("fail to start controller")
+		return fmt.Errorf("fail to create frontier scorer, err: %w", err)
 	}
 
-	// 注入seed url数据
-	core.CreateSeedRecord(s.logger, urlQueue, dbStorage, cfg.Core.SeedFilePath)
+	// 内存及磁盘队列无法跨进程共享，必须在同一个进程中运行所有角色
+	if cfg.Queue != enum.QueueTypePostgres && len(s.roles) != len(enum.Roles) {
+		return fmt.Errorf("queue type %s requires all roles in one process", cfg.Queue)
+	}
 
-	// 设置重试任务
-	core.CreateRetryTask(s.ctx, s.logger, urlQueue, dbStorage, cfg.Core.RetryTaskScanPeriod, cfg.Core.TaskTimeout)
+	if s.pageQueue, err = s.newQueue(enum.QueuePage, cfg.PageInfoQueueSize); err != nil {
+		return err
+	}
+	if s.parsedPageQueue, err = s.newQueue(enum.QueueParsedPage, cfg.ParsedPageInfoQueueSize); err != nil {
+		return err
+	}
 
-	// 设置终止探测
-	core.CreateCheckCompletedTask(s.ctx, s.logger, dbStorage, cfg.Core.CheckCompletedPeriod, s.finished)
+	// downloader在各个job之间轮转，从中读取优先级最高的url
+	s.urlFrontier = s.newURLFrontier(scorer)
+	return nil
+}
 
-	s.wait()
-	s.Stop()
+// size对于内存队列为容量上限，对于磁盘队列为内存中保留的消息数量
+func (s *Server) newQueue(name string, size uint32) (queue.Queue, error) {
+	cfg := s.config.Core
+	switch cfg.Queue {
+	case "", enum.QueueTypeMemory:
+		return queue.NewMemoryQueue(size), nil
+	case enum.QueueTypeDisk:
+		q, err := queue.NewDiskQueue(cfg.QueueSpillDir, name, size)
+		if err != nil {
+			return nil, fmt.Errorf("fail to create disk queue %s, err: %w", name, err)
+		}
+		return q, nil
+	case enum.QueueTypePostgres:
+		return queue.NewPGQueue(name, s.dbStorage, s.logger, cfg.QueuePollInterval), nil
+	default:
+		return nil, fmt.Errorf("unknown queue type: %s", cfg.Queue)
+	}
+}
+
+// 各个stage队列中当前的消息数量，获取失败的队列不包含在结果中
+func (s *Server) QueueDepths() map[string]int64 {
+	var depths = make(map[string]int64)
+	queues := map[string]interface{ Len() (int64, error) }{
+		enum.QueueURL:        s.urlFrontier,
+		enum.QueuePage:       s.pageQueue,
+		enum.QueueParsedPage: s.parsedPageQueue,
+	}
+	for name, q := range queues {
+		n, err := q.Len()
+		if err != nil {
+			s.logger.WithError(err).WithField("queue", name).Error("fail to get queue depth")
+			continue
+		}
+		depths[name] = n
+	}
+	return depths
+}
+
+// 定时打印各个队列的长度
+func (s *Server) reportQueueDepths() {
+	period := s.config.Core.QueueReportPeriod
+	if period == 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(period) * time.Second)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+				depths := s.QueueDepths()
+				s.logger.WithFields(log.Fields{
+					enum.QueueURL:        depths[enum.QueueURL],
+					enum.QueuePage:       depths[enum.QueuePage],
+					enum.QueueParsedPage: depths[enum.QueueParsedPage],
+				}).Info("queue depths")
+			}
+		}
+	}()
+}
+
+func (s *Server) initBrowser() error {
+	renderCfg := s.config.Downloader.Render
+	for _, r := range renderCfg.Rules {
+		rule, err := downloader.NewRule(r.Domain, r.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid render rule, pattern: %s, err: %w", r.Pattern, err)
+		}
+		s.renderRules = append(s.renderRules, rule)
+	}
 
+	browser, err := downloader.NewBrowser(s.ctx, renderCfg.ExecPath, renderCfg.Worker)
+	if err != nil {
+		return err
+	}
+	s.browser = browser
 	return nil
 }
 
-func (s *Server) wait() {
-	c := make(chan os.Signal)
-	signal.Notify(c, syscall.SIGINT)
-	select {
-	case <-c:
-		s.logger.Warn("interrupt signal, server gonna stop")
-		break
-	case <-s.finished: // 这是checkcompleted任务触发的，认为当前所有的page都已经被抓取，可以终止程序
-		s.logger.Info("task finished, server gonna stop")
-		break
+func (s *Server) initBreaker() error {
+	cfg := s.config.Downloader.Breaker
+	b := breaker.NewDomainBreaker(breaker.Config{
+		Threshold:   cfg.Threshold,
+		Cooldown:    time.Duration(cfg.Cooldown) * time.Second,
+		MaxCooldown: time.Duration(cfg.MaxCooldown) * time.Second,
+		MaxProbes:   cfg.MaxProbes,
+	}, s.dbStorage, s.logger, s.resubmitDomain, s.events)
+	if err := b.Load(); err != nil {
+		return err
 	}
+	b.Run(s.ctx)
+	s.breaker = b
+	s.watchJobStarts()
+	return nil
+}
+
+// 新的job（包括定时爬取的新一代）开始时重置dead的域名
+// job的开始时间由core角色写入，各个进程定时重新载入job，因此在job_sync_period之内生效
+func (s *Server) watchJobStarts() {
+	latest := s.latestJobStart()
+	period := time.Duration(s.config.Core.JobSyncPeriod) * time.Second
+	go func() {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+				if started := s.latestJobStart(); started.After(latest) {
+					latest = started
+					s.breaker.ResetDead()
+				}
+			}
+		}
+	}()
 }
 
-func (s *Server) Stop() {
-	s.cancel()
-	s.downloader.Stop()
-	s.analyzer.Stop()
-	s.controller.Stop()
-	s.dbStorage.Close()
+func (s *Server) latestJobStart() time.Time {
+	var latest time.Time
+	for _, j := range s.jobs.Jobs() {
+		if j.StartedAt.After(latest) {
+			latest = j.StartedAt
+		}
+	}
+	return latest
+}
+
+func (s *Server) initAuth() error {
+	cfg := s.config.Downloader
+	if len(cfg.Auth) == 0 {
+		return nil
+	}
+	var configs []downloader.AuthConfig
+	for _, c := range cfg.Auth {
+		configs = append(configs, downloader.AuthConfig(c))
+	}
+	auth, err := downloader.NewAuthenticator(configs, time.Duration(cfg.Timeout)*time.Second)
+	if err != nil {
+		return err
+	}
+	// render下载不使用认证
+	for i, rule := range s.renderRules {
+		if domain, ok := auth.Overlap(rule); ok {
+			return fmt.Errorf("render rule for %s overlaps auth of %s, render downloader does not support auth",
+				s.config.Downloader.Render.Rules[i].Domain, domain)
+		}
+	}
+	s.authenticator = auth
+	return nil
+}
+
+const resubmitBatchSize = 500
+
+var errResubmitLimit = errors.New("resubmit limit reached")
+
+// 熔断期间被搁置的url仍为pending，从数据库中重新提交，limit为0时提交全部
+// 仍在队列中、尚未被取出的url不会被重复提交
+func (s *Server) resubmitDomain(domain string, limit int) {
+	filter := dbstorage.PageFilter{State: enum.PageStatePending, Domain: domain, Parked: true}
+	count, err := s.resubmitPending(filter, limit)
+	if err != nil && s.ctx.Err() == nil {
+		s.logger.WithError(err).WithField("domain", domain).Error("fail to resubmit pending urls")
+		return
+	}
+	s.logger.WithField("domain", domain).WithField("count", count).Debug("pending urls resubmitted")
+}
+
+// 按filter从数据库中提交页面，limit为0时提交全部，返回提交的数量
+func (s *Server) resubmitPending(filter dbstorage.PageFilter, limit int) (int, error) {
+	var count int
+	err := s.dbStorage.ScanPages(filter, resubmitBatchSize, func(p *schema.Page) error {
+		if limit > 0 && count >= limit {
+			return errResubmitLimit
+		}
+		count++
+		// 先清除搁置标记再提交，否则提交之后再次被搁置的标记可能被清除
+		if p.Parked {
+			if err := s.unparkPage(p.ID); err != nil {
+				return err
+			}
+		}
+		return s.urlFrontier.Push(s.ctx, entity.URLTask{JobID: p.JobID, URL: p.URL, Depth: p.Depth, Seed: p.Depth == 0})
+	})
+	if err == errResubmitLimit {
+		err = nil
+	}
+	return count, err
+}
+
+func (s *Server) unparkPage(id uint64) error {
+	t, err := s.dbStorage.NewTransaction()
+	if err != nil {
+		return err
+	}
+	defer t.Rollback()
+	if err = t.UnparkPage(id); err != nil {
+		return err
+	}
+	return t.Commit()
+}
+
+// 未启用render时直接使用SimpleDownloader，否则按照规则在两者之间选择
+func (s *Server) newDownloader(ctx context.Context) downloader.Downloader {
+	cfg := s.config.Downloader
+	simple := downloader.NewSimpleDownloader(ctx, cfg.Timeout, cfg.Retry, cfg.MaxSize, s.authenticator)
+	if s.browser == nil {
+		return simple
+	}
+
+	d := downloader.NewRoutingDownloader(simple)
+	for i, rule := range s.renderRules {
+		r := cfg.Render.Rules[i]
+		d.AddRoute(rule, downloader.NewRenderDownloader(
+			ctx, s.browser, cfg.Render.Timeout, cfg.Retry, cfg.MaxSize, cfg.Render.IdleTime, r.WaitSelector, s.authenticator))
+	}
+	return d
 }
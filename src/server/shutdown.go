@@ -0,0 +1,221 @@
+// 关闭流程：按照流水线顺序依次停止各个stage获取新任务，并等待下游队列排空
+// 整个过程不超过core.shutdown_grace_period，超时后直接取消所有进行中的任务
+package server
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/andrewyi/crawler/src/entity"
+	"github.com/andrewyi/crawler/src/enum"
+	"github.com/andrewyi/crawler/src/queue"
+	"github.com/andrewyi/crawler/src/routingpool"
+)
+
+const (
+	drainPollInterval = 200 * time.Millisecond
+	// 归还未完成的url时使用，此时s.ctx已经被取消
+	requeueTimeout = 5 * time.Second
+)
+
+type intake struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// 不存在时创建，所有stage的intake ctx均派生自s.ctx
+func (s *Server) intakeCtx(stage string) context.Context {
+	in, ok := s.intakes[stage]
+	if !ok {
+		ctx, cancel := context.WithCancel(s.ctx)
+		in = &intake{ctx: ctx, cancel: cancel}
+		s.intakes[stage] = in
+	}
+	return in.ctx
+}
+
+func (s *Server) stopIntake(stage string) {
+	if in, ok := s.intakes[stage]; ok {
+		in.cancel()
+	}
+}
+
+type inflightTasks struct {
+	mu    sync.Mutex
+	seq   uint64
+	tasks map[uint64]entity.URLTask
+}
+
+func newInflightTasks() *inflightTasks {
+	return &inflightTasks{tasks: make(map[uint64]entity.URLTask)}
+}
+
+func (f *inflightTasks) add(task entity.URLTask) uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.seq++
+	f.tasks[f.seq] = task
+	return f.seq
+}
+
+func (f *inflightTasks) done(id uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.tasks, id)
+}
+
+func (f *inflightTasks) list() []entity.URLTask {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var tasks = make([]entity.URLTask, 0, len(f.tasks))
+	for _, t := range f.tasks {
+		tasks = append(tasks, t)
+	}
+	return tasks
+}
+
+func (s *Server) wait() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case sig := <-c:
+		s.logger.WithField("signal", sig.String()).Warn("interrupt signal, server gonna stop")
+	case <-s.finished: // 这是终止探测触发的，认为当前所有的page都已经被抓取，可以终止程序
+		s.logger.Info("task finished, server gonna stop")
+		s.completed = true
+	}
+
+	// 排空过程中再次收到信号时不再等待，直接退出
+	go func() {
+		sig := <-c
+		s.logger.WithField("signal", sig.String()).Warn("second signal, force exit")
+		os.Exit(1)
+	}()
+}
+
+func (s *Server) Stop() {
+	grace := time.Duration(s.config.Core.ShutdownGracePeriod) * time.Second
+	deadline := time.Now().Add(grace)
+	s.logger.WithField("grace_period", grace).Info("draining pipeline")
+
+	// 1. downloader不再获取新的url，等待已经开始的下载完成
+	s.drain(enum.RoleDownloader, s.downloader, nil, false, deadline)
+	// 2. 上游downloader在当前进程中时，等待page队列被analyzer消费完
+	//    否则上游可能仍在其他进程中运行，队列中的消息留在postgres中由其他进程处理
+	s.drain(enum.RoleAnalyzer, s.analyzer, s.pageQueue, s.downloader != nil, deadline)
+	// 3. 同理，等待parsed page队列被controller写入数据库
+	s.drain(enum.RoleController, s.controller, s.parsedPageQueue, s.analyzer != nil, deadline)
+
+	// 超时仍未完成的任务将在此处被取消
+	s.cancel()
+	for _, p := range s.pools() {
+		p.Stop()
+	}
+	s.requeueInflight()
+	s.reportLeftover()
+
+	if s.browser != nil {
+		s.browser.Close()
+	}
+	// 先关闭索引，hook中的命令（例如search）才能打开
+	if s.index != nil {
+		if err := s.index.Close(); err != nil {
+			s.logger.WithError(err).Error("fail to close index")
+		}
+	}
+	if s.completed {
+		s.emitCompleted()
+		s.runPostCrawlHooks()
+	}
+	s.stopHTTP()
+	s.closeEvents()
+	s.dbStorage.Close()
+}
+
+// upstreamLocal为true时，先等待q被排空再停止获取
+func (s *Server) drain(stage string, pool routingpool.RoutingPool, q queue.Queue, upstreamLocal bool, deadline time.Time) {
+	if pool == nil {
+		return
+	}
+	if upstreamLocal {
+		for time.Now().Before(deadline) {
+			n, err := q.Len()
+			if err != nil {
+				s.logger.WithError(err).WithField("stage", stage).Error("fail to get queue depth")
+				break
+			}
+			if n == 0 {
+				break
+			}
+			time.Sleep(drainPollInterval)
+		}
+	}
+
+	s.stopIntake(stage)
+	if !waitPool(pool, deadline) {
+		s.logger.WithField("stage", stage).Warn("grace period exceeded, in-flight tasks will be cancelled")
+		return
+	}
+	s.logger.WithField("stage", stage).Info("stage drained")
+}
+
+// 在deadline之前pool中所有worker都已退出时返回true
+func waitPool(pool routingpool.RoutingPool, deadline time.Time) bool {
+	stopped := make(chan struct{})
+	go func() {
+		pool.Stop()
+		close(stopped)
+	}()
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-stopped:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// 被取消的下载任务对应的page在数据库中仍为pending状态
+// postgres队列会持久保存，直接放回url队列；其他队列类型由下次运行时的重试任务处理
+func (s *Server) requeueInflight() {
+	tasks := s.inflight.list()
+	if len(tasks) == 0 {
+		return
+	}
+	if s.config.Core.Queue != enum.QueueTypePostgres {
+		s.logger.WithField("count", len(tasks)).Warn("in-flight urls cancelled, left pending for retry")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requeueTimeout)
+	defer cancel()
+	var requeued int
+	for _, t := range tasks {
+		// 放弃租约，其他进程取出后可以立即获取
+		s.releaseLease(t.JobID, t.URL)
+		if err := s.urlFrontier.Push(ctx, t); err != nil {
+			s.logger.WithError(err).WithField("url", t.URL).Error("fail to requeue url")
+			continue
+		}
+		requeued++
+	}
+	s.logger.WithField("count", requeued).Info("in-flight urls returned to url queue")
+}
+
+// 内存及磁盘队列中剩余的消息会随进程退出而丢失，对应的page仍为pending状态
+func (s *Server) reportLeftover() {
+	if s.config.Core.Queue == enum.QueueTypePostgres {
+		return
+	}
+	depths := s.QueueDepths()
+	for name, n := range depths {
+		if n > 0 {
+			s.logger.WithField("queue", name).WithField("count", n).Warn("messages dropped on exit, left pending for retry")
+		}
+	}
+}
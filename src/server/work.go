@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+
+	"github.com/andrewyi/crawler/src/dbstorage"
+	"github.com/andrewyi/crawler/src/dbstorage/schema"
+	"github.com/andrewyi/crawler/src/entity"
+	"github.com/andrewyi/crawler/src/enum"
+	"github.com/andrewyi/crawler/src/frontier"
+	"github.com/andrewyi/crawler/src/queue"
+	"github.com/andrewyi/crawler/src/tracker"
+)
+
+// 提交url时计数，被downloader取出时流转到downloader
+// 计数先于Push，避免消息在计数之前就被取出
+type trackedFrontier struct {
+	frontier.Frontier
+	work *tracker.Tracker
+}
+
+func (f *trackedFrontier) Push(ctx context.Context, task entity.URLTask) error {
+	f.work.Add(enum.QueueURL)
+	if err := f.Frontier.Push(ctx, task); err != nil {
+		f.work.Done(enum.QueueURL)
+		return err
+	}
+	f.work.Resume(task.JobID, task.URL)
+	return nil
+}
+
+func (f *trackedFrontier) Pop(ctx context.Context) (entity.URLTask, error) {
+	task, err := f.Frontier.Pop(ctx)
+	if err == nil {
+		f.work.Move(enum.QueueURL, enum.RoleDownloader)
+	}
+	return task, err
+}
+
+// name为队列名，stage为从中读取消息的角色
+type trackedQueue struct {
+	queue.Queue
+	name  string
+	stage string
+	work  *tracker.Tracker
+}
+
+func (q *trackedQueue) Push(ctx context.Context, msg queue.Message) error {
+	q.work.Add(q.name)
+	if err := q.Queue.Push(ctx, msg); err != nil {
+		q.work.Done(q.name)
+		return err
+	}
+	return nil
+}
+
+func (q *trackedQueue) Pop(ctx context.Context) (queue.Message, error) {
+	msg, err := q.Queue.Pop(ctx)
+	if err == nil {
+		q.work.Move(q.name, q.stage)
+	}
+	return msg, err
+}
+
+// 所有角色在同一进程中时才能统计全部的任务，postgres队列时s.work为nil，继续通过数据库判断任务是否完成
+func (s *Server) initTracker() {
+	if s.config.Core.Queue == enum.QueueTypePostgres {
+		return
+	}
+	s.work = tracker.NewTracker()
+	s.urlFrontier = &trackedFrontier{Frontier: s.urlFrontier, work: s.work}
+	s.pageQueue = &trackedQueue{Queue: s.pageQueue, name: enum.QueuePage, stage: enum.RoleAnalyzer, work: s.work}
+	s.parsedPageQueue = &trackedQueue{Queue: s.parsedPageQueue, name: enum.QueueParsedPage, stage: enum.RoleController, work: s.work}
+}
+
+// 上一次运行留下的、仍会被重试的暂时性失败页面，由重试任务在退避时间之后重新提交
+func (s *Server) loadDeferred() error {
+	maxRetries := s.config.Core.FailureRetry.MaxRetries
+	if s.work == nil || maxRetries == 0 {
+		return nil
+	}
+	filter := dbstorage.PageFilter{State: enum.PageStateFailTransient, RetryCountBelow: maxRetries}
+	return s.dbStorage.ScanPages(filter, resubmitBatchSize, func(p *schema.Page) error {
+		s.work.Defer(p.JobID, p.URL)
+		return nil
+	})
+}
+
+// 所有初始任务提交之后开始判断，统计归零时通知关闭
+func (s *Server) watchCompletion() {
+	s.work.Arm()
+	go func() {
+		select {
+		case <-s.ctx.Done():
+		case <-s.work.Idle():
+			select {
+			case s.finished <- struct{}{}:
+			case <-s.ctx.Done():
+			}
+		}
+	}()
+}
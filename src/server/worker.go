@@ -0,0 +1,253 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/andrewyi/crawler/src/analyzer"
+	"github.com/andrewyi/crawler/src/breaker"
+	"github.com/andrewyi/crawler/src/controller"
+	"github.com/andrewyi/crawler/src/downloader"
+	"github.com/andrewyi/crawler/src/entity"
+	"github.com/andrewyi/crawler/src/enum"
+	"github.com/andrewyi/crawler/src/extractor"
+	"github.com/andrewyi/crawler/src/metrics"
+	"github.com/andrewyi/crawler/src/queue"
+	"github.com/andrewyi/crawler/src/routingpool"
+	"github.com/andrewyi/crawler/src/util"
+)
+
+// 所有worker的ctx参数仅用于控制是否继续获取新的任务（关闭时首先停止获取）
+// 已经取出的任务使用s.ctx继续处理并写入下游队列，直到强制退出
+func (s *Server) downloadWorker(ctx context.Context) {
+	d := s.newDownloader(s.ctx)
+	for {
+		if err := routingpool.Checkpoint(ctx); err != nil {
+			return
+		}
+		task, err := s.urlFrontier.Pop(ctx) // 仅在ctx结束时返回错误
+		if err != nil {
+			return
+		}
+		if !s.downloadTask(d, task) {
+			return
+		}
+	}
+}
+
+// 返回false表示s.ctx已经结束，worker需要退出
+func (s *Server) downloadTask(d downloader.Downloader, task entity.URLTask) bool {
+	// 任务以任何方式结束（包括panic）时离开stage
+	defer s.work.Done(enum.RoleDownloader)
+	if !s.runnable(task) {
+		return true
+	}
+	if !s.acquireLease(task.JobID, task.URL) {
+		s.logger.WithField("url", task.URL).Debug("url is done or leased by others, skipped")
+		return true
+	}
+	// 没有交给下游时（包括panic）结束租约
+	held := true
+	defer func() {
+		if held {
+			s.endLease(task.JobID, task.URL)
+		}
+	}()
+
+	id := s.inflight.add(task)
+	domain, _ := util.GetDomain(task.URL)
+	page, ok := s.download(d, domain, task.URL)
+	if s.ctx.Err() != nil {
+		return false // 留在inflight中，关闭时归还
+	}
+	if !ok {
+		// 域名熔断中，url保持pending并标记为搁置，恢复后重新提交，因此放弃租约避免被回收任务提交
+		metrics.ParkedURLs.Inc()
+		s.parkPage(task.JobID, task.URL)
+		held = false
+		s.inflight.done(id)
+		s.work.Defer(task.JobID, task.URL)
+		return true
+	}
+
+	page.JobID = task.JobID
+	// downloader下载的内容将被放入此queue，并由analyzer读取
+	if err := queue.PushJSON(s.ctx, s.pageQueue, 0, page); err != nil {
+		if s.ctx.Err() != nil {
+			return false
+		}
+		s.logger.WithError(err).WithField("url", page.URL).Error("fail to push page")
+	} else {
+		s.handOffLease(task.JobID, task.URL)
+		held = false
+	}
+	s.inflight.done(id)
+	return true
+}
+
+// 返回false时表示域名处于熔断中（或者s.ctx已经结束），没有可以写入下游的结果
+func (s *Server) download(d downloader.Downloader, domain string, url string) (entity.PageInfo, bool) {
+	if s.breaker != nil {
+		if s.breaker.Allow(domain) == breaker.Park {
+			return entity.PageInfo{}, false
+		}
+	}
+	if err := s.hostLimiter.Wait(s.ctx, domain); err != nil {
+		return entity.PageInfo{}, false
+	}
+	start := time.Now()
+	page := d.Download(url)
+	elapsed := time.Since(start)
+	metrics.DownloadDuration.Observe(elapsed.Seconds())
+	s.downloadLatency.Observe(elapsed)
+	observeDownload(page)
+
+	if s.breaker != nil && breaker.Reportable(page) && s.breaker.Report(domain, breaker.Healthy(page), failureRemark(page)) {
+		return entity.PageInfo{}, false
+	}
+	return page, true
+}
+
+func failureRemark(page entity.PageInfo) string {
+	if page.Remark != "" {
+		return page.Remark
+	}
+	return fmt.Sprintf("http status %d", page.StatusCode)
+}
+
+func observeDownload(page entity.PageInfo) {
+	domain, _ := util.GetDomain(page.URL)
+	result := "success"
+	if page.State != enum.PageStateSuccess {
+		result = "fail"
+	}
+	metrics.PagesDownloaded.WithLabelValues(result, metrics.StatusClass(page.StatusCode), domain).Inc()
+	metrics.BytesFetched.WithLabelValues(domain).Add(float64(len(page.Content)))
+}
+
+func (s *Server) analyzeWorker(ctx context.Context) {
+	var ext extractor.Extractor
+	if s.config.Analyzer.Extract {
+		ext = extractor.NewReadabilityExtractor()
+	}
+	a := analyzer.NewSimpleAnalyzer(s.ctx, ext)
+	for {
+		if err := routingpool.Checkpoint(ctx); err != nil {
+			return
+		}
+		msg, err := s.pageQueue.Pop(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			s.logger.WithError(err).Error("fail to pop page")
+			continue
+		}
+		if !s.analyzeTask(a, msg) {
+			return
+		}
+	}
+}
+
+// 返回false表示s.ctx已经结束，worker需要退出
+func (s *Server) analyzeTask(a analyzer.Analyzer, msg queue.Message) bool {
+	// 任务以任何方式结束（包括panic）时离开stage
+	defer s.work.Done(enum.RoleAnalyzer)
+	// 消息已经取出，反序列化失败时同样视为处理结束
+	var page entity.PageInfo
+	if err := json.Unmarshal(msg.Body, &page); err != nil {
+		s.logger.WithError(err).Error("fail to unmarshal page")
+		return true
+	}
+	s.claimLease(page.JobID, page.URL)
+	// 没有交给下游时（包括panic）结束租约
+	held := true
+	defer func() {
+		if held {
+			s.endLease(page.JobID, page.URL)
+		}
+	}()
+
+	start := time.Now()
+	parsedPage := a.Analyze(page)
+	metrics.AnalyzeDuration.Observe(time.Since(start).Seconds())
+	// analyzer分析好的内容将被放入此queue，并由controller读取
+	if err := queue.PushJSON(s.ctx, s.parsedPageQueue, 0, parsedPage); err != nil {
+		if s.ctx.Err() != nil {
+			return false
+		}
+		s.logger.WithError(err).WithField("url", page.URL).Error("fail to push parsed page")
+	} else {
+		s.handOffLease(page.JobID, page.URL)
+		held = false
+	}
+	return true
+}
+
+func (s *Server) controlWorker(ctx context.Context) {
+	cfg := s.config
+	dedupDistance := -1
+	if cfg.Controller.Dedup.Enabled {
+		dedupDistance = cfg.Controller.Dedup.MaxDistance
+	}
+	directives := controller.Directives(cfg.Controller.Directives)
+	retry := controller.RetryPolicy{
+		Backoff:    time.Duration(cfg.Core.FailureRetry.Backoff) * time.Second,
+		MaxBackoff: time.Duration(cfg.Core.FailureRetry.MaxBackoff) * time.Second,
+		MaxRetries: cfg.Core.FailureRetry.MaxRetries,
+		// 等待重试的页面不在任何队列中，需要单独计入未完成的工作
+		OnScheduled: s.work.Defer,
+	}
+	hist := controller.History{
+		Location:  cfg.Storage.History.Location,
+		OnChanged: s.notifyChange,
+	}
+	c := controller.NewSimpleController(s.ctx, s.jobs, dedupDistance, directives, retry, hist, s.events, s.index, s.dbStorage, s.logger)
+	for {
+		if err := routingpool.Checkpoint(ctx); err != nil {
+			return
+		}
+		msg, err := s.parsedPageQueue.Pop(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			s.logger.WithError(err).Error("fail to pop parsed page")
+			continue
+		}
+		if !s.controlTask(c, msg) {
+			return
+		}
+	}
+}
+
+// 返回false表示s.ctx已经结束，worker需要退出
+func (s *Server) controlTask(c controller.Controller, msg queue.Message) bool {
+	// 任务以任何方式结束（包括panic）时离开stage
+	defer s.work.Done(enum.RoleController)
+	var parsedPage entity.ParsedPageInfo
+	if err := json.Unmarshal(msg.Body, &parsedPage); err != nil {
+		s.logger.WithError(err).Error("fail to unmarshal parsed page")
+		return true
+	}
+	s.claimLease(parsedPage.JobID, parsedPage.URL)
+	// controller是最后一个stage，任务总是在此结束租约，没有写入结果时（数据库或者文件存储错误、panic）由回收任务重新提交
+	defer s.endLease(parsedPage.JobID, parsedPage.URL)
+	// 页面在等待重试期间可能已经被其他途径提交并处理，以本次的结果为准，需要重试时由controller再次记录
+	s.work.Resume(parsedPage.JobID, parsedPage.URL)
+	// 与其他queue 1:1的请求/结果不同，这里一个请求对应多个结果（解析出多个sub url）
+	start := time.Now()
+	tasks := c.Process(parsedPage)
+	metrics.ProcessDuration.Observe(time.Since(start).Seconds())
+	for _, t := range tasks {
+		if err := s.urlFrontier.Push(s.ctx, t); err != nil {
+			if s.ctx.Err() != nil {
+				return false
+			}
+			s.logger.WithError(err).WithField("url", t.URL).Error("fail to push url")
+		}
+	}
+	return true
+}
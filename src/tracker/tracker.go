@@ -0,0 +1,178 @@
+// 进程内的工作量统计，所有角色运行在同一进程中时，据此判断爬取任务是否已经完成，不再依赖轮询数据库
+// 每个url任务从提交到url队列开始计数，依次经过各个队列及stage，在controller处理完成或者被丢弃时结束
+// 任务在stage之间流转时先计入下游再从上游移除，因此总数不会在处理过程中短暂归零
+// 等待重试以及被熔断搁置的url不在任何队列中，单独记录为deferred，再次提交到url队列时移除
+package tracker
+
+import (
+	"sync"
+
+	"github.com/andrewyi/crawler/src/enum"
+	"github.com/andrewyi/crawler/src/metrics"
+	"github.com/andrewyi/crawler/src/util"
+)
+
+const (
+	// 正在从数据库批量提交url的任务（例如启动时恢复pending），提交完成之前不会认为任务已经结束
+	Producer = "producer"
+	Deferred = "deferred"
+)
+
+// 多进程部署时无法在单个进程中统计，Tracker为nil，所有方法均不做任何操作
+type Tracker struct {
+	mu       sync.Mutex
+	counts   map[string]int64
+	deferred map[string]struct{}
+	// Arm之后总数归零时关闭idle，只触发一次
+	armed bool
+	fired bool
+	idle  chan struct{}
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{
+		counts:   make(map[string]int64),
+		deferred: make(map[string]struct{}),
+		idle:     make(chan struct{}),
+	}
+}
+
+// 任务进入stage（队列或者worker）
+func (t *Tracker) Add(stage string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.add(stage, 1)
+}
+
+// 任务离开stage并且不再进入其他stage，即处理完成或者被丢弃
+func (t *Tracker) Done(stage string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.add(stage, -1)
+	t.check()
+}
+
+// 任务从from流转到to，例如从url队列中被downloader取出
+func (t *Tracker) Move(from string, to string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.add(to, 1)
+	t.add(from, -1)
+}
+
+// 开始批量提交url，返回的函数在提交结束后调用
+func (t *Tracker) Hold() func() {
+	if t == nil {
+		return func() {}
+	}
+	t.Add(Producer)
+	var once sync.Once
+	return func() {
+		once.Do(func() { t.Done(Producer) })
+	}
+}
+
+// job中的url暂时不需要处理，但之后会被重新提交（等待重试、域名熔断中）
+func (t *Tracker) Defer(jobID string, url string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.deferred[key(jobID, url)] = struct{}{}
+	metrics.WorkOutstanding.WithLabelValues(Deferred).Set(float64(len(t.deferred)))
+}
+
+// url已经被重新提交，不存在时忽略
+func (t *Tracker) Resume(jobID string, url string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	k := key(jobID, url)
+	if _, ok := t.deferred[k]; !ok {
+		return
+	}
+	delete(t.deferred, k)
+	metrics.WorkOutstanding.WithLabelValues(Deferred).Set(float64(len(t.deferred)))
+	t.check()
+}
+
+// 初始的任务全部提交之后调用，此后总数归零时认为任务已经完成
+// 在此之前worker可能已经处理完了先提交的任务，总数归零并不代表任务结束
+func (t *Tracker) Arm() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.armed = true
+	t.check()
+}
+
+// 任务完成时被关闭，Tracker为nil时返回nil，即永远不会完成
+func (t *Tracker) Idle() <-chan struct{} {
+	if t == nil {
+		return nil
+	}
+	return t.idle
+}
+
+// 各个stage当前的任务数量，以及deferred的url数量
+func (t *Tracker) Snapshot() map[string]int64 {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var snapshot = make(map[string]int64, len(t.counts)+1)
+	for stage, n := range t.counts {
+		snapshot[stage] = n
+	}
+	snapshot[Deferred] = int64(len(t.deferred))
+	return snapshot
+}
+
+// 计数出现负数说明存在没有配对的调用，此时按0处理，避免之后永远无法归零
+func (t *Tracker) add(stage string, n int64) {
+	v := t.counts[stage] + n
+	if v < 0 {
+		v = 0
+	}
+	t.counts[stage] = v
+	metrics.WorkOutstanding.WithLabelValues(stage).Set(float64(v))
+}
+
+func (t *Tracker) check() {
+	if !t.armed || t.fired || len(t.deferred) > 0 {
+		return
+	}
+	for _, n := range t.counts {
+		if n > 0 {
+			return
+		}
+	}
+	t.fired = true
+	close(t.idle)
+}
+
+// 数据库中的url不包含协议，统一之后与job一起作为key，不同job中的同一url分别记录
+func key(jobID string, url string) string {
+	if k, err := util.ShortifyURL(url); err == nil {
+		url = k
+	}
+	if jobID == "" {
+		jobID = enum.DefaultJob
+	}
+	return jobID + " " + url
+}
@@ -0,0 +1,149 @@
+package tracker
+
+import (
+	"testing"
+
+	"github.com/andrewyi/crawler/src/enum"
+)
+
+const (
+	queueStage  = "url_queue"
+	workerStage = "downloader"
+)
+
+func idle(t *Tracker) bool {
+	select {
+	case <-t.Idle():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestArm(t *testing.T) {
+	tr := NewTracker()
+	// Arm之前总数为0不代表任务结束
+	tr.Add(queueStage)
+	tr.Done(queueStage)
+	if idle(tr) {
+		t.Fatal("idle before Arm")
+	}
+	tr.Arm()
+	if !idle(tr) {
+		t.Fatal("not idle after Arm with nothing outstanding")
+	}
+	// 只触发一次，之后的任务不会导致重复关闭
+	tr.Add(queueStage)
+	tr.Done(queueStage)
+}
+
+func TestHold(t *testing.T) {
+	tr := NewTracker()
+	release := tr.Hold()
+	tr.Arm()
+	if idle(tr) {
+		t.Fatal("idle while producer is holding")
+	}
+
+	tr.Add(queueStage)
+	release()
+	release() // 重复调用只生效一次
+	if got := tr.Snapshot()[Producer]; got != 0 {
+		t.Fatalf("producer count = %d, want 0", got)
+	}
+	if idle(tr) {
+		t.Fatal("idle with a queued task")
+	}
+	tr.Move(queueStage, workerStage)
+	if idle(tr) {
+		t.Fatal("idle after move")
+	}
+	tr.Done(workerStage)
+	if !idle(tr) {
+		t.Fatal("not idle after last task done")
+	}
+}
+
+func TestDeferred(t *testing.T) {
+	tr := NewTracker()
+	tr.Add(workerStage)
+	tr.Arm()
+	tr.Defer("", "http://example.com/a")
+	tr.Defer("job1", "http://example.com/a")
+	tr.Defer("", "http://example.com/a") // 同一url只记录一次
+	tr.Done(workerStage)
+	if idle(tr) {
+		t.Fatal("idle with deferred urls")
+	}
+	if got := tr.Snapshot()[Deferred]; got != 2 {
+		t.Fatalf("deferred = %d, want 2", got)
+	}
+
+	// 忽略协议及fragment之后与job一起匹配，默认job与空job相同
+	tr.Resume(enum.DefaultJob, "https://example.com/a#top")
+	tr.Resume("job2", "http://example.com/a") // 不存在时忽略
+	if got := tr.Snapshot()[Deferred]; got != 1 {
+		t.Fatalf("deferred = %d after resume, want 1", got)
+	}
+	if idle(tr) {
+		t.Fatal("idle with a deferred url left")
+	}
+	tr.Resume("job1", "http://example.com/a")
+	if !idle(tr) {
+		t.Fatal("not idle after all deferred urls resumed")
+	}
+}
+
+// worker通过defer离开stage，处理过程中panic时计数同样能够归零
+func TestDonePanic(t *testing.T) {
+	tr := NewTracker()
+	tr.Add(queueStage)
+	tr.Arm()
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("no panic")
+			}
+		}()
+		tr.Move(queueStage, workerStage)
+		defer tr.Done(workerStage)
+		panic("boom")
+	}()
+
+	if got := tr.Snapshot()[workerStage]; got != 0 {
+		t.Fatalf("%s count = %d after panic, want 0", workerStage, got)
+	}
+	if !idle(tr) {
+		t.Fatal("not idle after panicked task")
+	}
+}
+
+// 没有配对的Done按0处理，之后仍然能够归零
+func TestUnpairedDone(t *testing.T) {
+	tr := NewTracker()
+	tr.Done(workerStage)
+	tr.Add(workerStage)
+	if got := tr.Snapshot()[workerStage]; got != 1 {
+		t.Fatalf("count = %d, want 1", got)
+	}
+	tr.Arm()
+	tr.Done(workerStage)
+	if !idle(tr) {
+		t.Fatal("not idle")
+	}
+}
+
+func TestNilTracker(t *testing.T) {
+	var tr *Tracker
+	tr.Add(queueStage)
+	tr.Move(queueStage, workerStage)
+	tr.Done(workerStage)
+	tr.Defer("", "http://example.com/")
+	tr.Resume("", "http://example.com/")
+	tr.Hold()()
+	tr.Arm()
+	if tr.Idle() != nil || tr.Snapshot() != nil {
+		t.Fatal("nil tracker should never be idle")
+	}
+}
@@ -1,8 +1,10 @@
 package util
 
 import (
+	"fmt"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -56,3 +58,16 @@ func StringSliceEqual(s1 []string, s2 []string) bool {
 	}
 	return true
 }
+
+// 解析命令行及接口中的时间参数，格式为2006-01-02或RFC3339，为空时返回零值，即不限制
+func ParseTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time: %s", v)
+}